@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"toDoList/internal/storage/migrations"
+	"toDoList/pkg/config"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const usage = `Usage: go run cmd/migrate/main.go <command>
+
+Commands:
+  status      show applied and pending migrations
+  up          apply all pending migrations
+  down [n]    roll back the last n migrations (default 1)`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	cfg := config.LoadConfig()
+	if cfg.DBType != "postgres" {
+		log.Fatalf("Migrations are only supported for postgres, DB_TYPE is %v", cfg.DBType)
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, cfg.DBConnectionString)
+	if err != nil {
+		log.Fatalf("Could not connect to db: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	switch os.Args[1] {
+	case "status":
+		statuses, err := migrations.GetStatus(ctx, conn)
+		if err != nil {
+			log.Fatalf("Could not get migrations status: %v", err)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied at " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, state)
+		}
+	case "up":
+		applied, err := migrations.Up(ctx, conn)
+		if err != nil {
+			log.Fatalf("Could not apply migrations: %v", err)
+		}
+		fmt.Printf("Applied %d migrations\n", applied)
+	case "down":
+		steps := 1
+		if len(os.Args) > 2 {
+			steps, err = strconv.Atoi(os.Args[2])
+			if err != nil || steps < 1 {
+				log.Fatalf("Invalid number of steps: %v", os.Args[2])
+			}
+		}
+		rolledBack, err := migrations.Down(ctx, conn, steps)
+		if err != nil {
+			log.Fatalf("Could not roll back migrations: %v", err)
+		}
+		fmt.Printf("Rolled back %d migrations\n", rolledBack)
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+}
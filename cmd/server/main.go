@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"log"
 	"net"
 	"net/http"
@@ -9,8 +10,12 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // time zones of reminders work without zoneinfo in the image
+	"toDoList/internal/events"
 	"toDoList/internal/handler"
 	"toDoList/internal/loadbalancer"
+	"toDoList/internal/model"
+	"toDoList/internal/notify"
 	"toDoList/internal/service"
 	"toDoList/internal/storage"
 	"toDoList/pkg/config"
@@ -25,9 +30,19 @@ func main() {
 	var err error
 
 	if cfg.DBType == "postgres" {
-		store, err = storage.NewPostgresDb(cfg.DBConnectionString)
+		store, err = storage.NewPostgresDb(cfg.DBConnectionString, storage.PostgresPoolConfig{
+			MaxConns:          cfg.PgMaxConns,
+			MinConns:          cfg.PgMinConns,
+			MaxConnLifetime:   cfg.PgMaxConnLifetime,
+			MaxConnIdleTime:   cfg.PgMaxConnIdleTime,
+			HealthCheckPeriod: cfg.PgHealthCheckPeriod,
+		})
 	} else if cfg.DBType == "mongo" {
-		store, err = storage.NewMongoDb(cfg.MongoURI, cfg.MongoDBName, cfg.MongoCollectionName)
+		store, err = storage.NewMongoDb(cfg.MongoURI, cfg.MongoDBName, cfg.MongoCollectionName, cfg.MongoReplicaSet)
+	} else if cfg.DBType == "sqlite" {
+		store, err = storage.NewSqliteDb(cfg.SqlitePath)
+	} else if cfg.DBType == "memory" {
+		store = storage.NewMemoryDb()
 	} else {
 		log.Fatalf("Unsupported DB type: %v", cfg.DBType)
 	}
@@ -37,13 +52,32 @@ func main() {
 	}
 	defer store.Close()
 
-	notificationChannel := make(chan string)
-
-	todoService := service.NewTodoService(store)
-	reminderService := service.NewReminderService(notificationChannel)
-
-	// Launching reminder worker
-	reminderService.StartWorker()
+	// buffered, so the reminder worker does not wait for slow readers
+	notificationChannel := make(chan model.ReminderNotification, 100)
+	// the hub sends every notification to every connected client, the log keeps them for clients that reconnect
+	notificationHub := notify.NewHub(notify.DefaultBufferSize)
+	notificationService := service.NewNotificationService(store, notificationHub, cfg.NotificationLogSize)
+	go notificationService.Run(notificationChannel)
+
+	reminderService := service.NewReminderService(store, notificationChannel, time.Now)
+
+	// the storage writes an outbox event with every change, the relay publishes it on the bus:
+	// reminders follow the changes before the request returns, notifications and the audit log
+	// do not slow requests down
+	bus := events.NewBus()
+	bus.Subscribe("reminders", reminderService.HandleTodoEvent, events.TodoCreated, events.TodoUpdated, events.TodoDeleted)
+	bus.SubscribeAsync("notifications", events.DefaultAsyncBuffer, notificationService.HandleTodoEvent,
+		events.TodoCreated, events.TodoUpdated, events.TodoImageUploaded, events.TodoDeleted)
+	bus.SubscribeAsync("audit", events.DefaultAsyncBuffer, events.LogEvent)
+	outboxRelay := service.NewOutboxRelay(store, bus, cfg.OutboxMaxAttempts, cfg.OutboxRetention)
+	todoService := service.NewTodoService(store, reminderService, outboxRelay, cfg.TimeZone, time.Now)
+
+	// Launching reminder worker, it fires reminders missed while the server was down
+	if err := reminderService.StartWorker(context.Background()); err != nil {
+		log.Fatalf("Could not load reminders: %v", err)
+	}
+	// publishes the events of changes made before a restart and retries failed ones
+	outboxRelay.StartWorker()
 
 	// List of servers to which we will send requests
 	servers := []string{
@@ -72,20 +106,45 @@ func main() {
 	router.Use(handler.RateLimiter())       // limit the number of requests
 
 	// Added new route for SSE
-	router.GET("/notifications", handler.SSENotificationHandler(notificationChannel))
+	router.GET("/notifications", handler.SSENotificationHandler(notificationService))
+	// two-way alternative to /notifications: subscriptions to todos and lists, and commands
+	router.GET("/ws", handler.WebSocket(todoService, reminderService, notificationService, cfg.RequestTimeout))
 
 	router.GET("/", handler.HomePage(todoService))
-	router.GET("/todos", handler.GetToDos(todoService))
-	router.GET("/todos/:id", handler.GetToDosById(todoService))
-	router.GET("/todos/:id/image", handler.GetTodosImageById(todoService))
-	router.POST("/todos", handler.PostToDos(todoService, reminderService))
-	router.POST("/todos/:id/image", handler.UploadToDoImage(todoService))
-	router.PUT("/todos/:id", handler.UpdateToDos(todoService))
-	router.DELETE("/todos/:id", handler.DeleteToDosById(todoService))
+	router.GET("/stats/db", handler.DBStats(store))
+	router.GET("/debug/vars", gin.WrapH(expvar.Handler())) // metrics, including storage retries
+
+	todos := router.Group("/todos", handler.RequestTimeout(cfg.RequestTimeout))
+	todos.GET("", handler.GetToDos(todoService))
+	todos.GET("/:id", handler.GetToDosById(todoService))
+	todos.GET("/:id/image", handler.GetTodosImageById(todoService))
+	todos.POST("", handler.PostToDos(todoService))
+	todos.POST("/:id/image", handler.UploadToDoImage(todoService))
+	todos.PUT("/:id", handler.UpdateToDos(todoService))
+	todos.PATCH("/:id", handler.PatchToDos(todoService))
+	todos.DELETE("/:id", handler.DeleteToDosById(todoService))
+	todos.GET("/:id/reminders", handler.GetReminders(todoService, reminderService))
+	todos.POST("/:id/reminders", handler.PostReminder(todoService))
+	todos.POST("/:id/reminders/reschedule", handler.RescheduleReminders(todoService))
+	todos.DELETE("/:id/reminders/:rid", handler.DeleteReminder(reminderService))
+	todos.GET("/:id/reminders/history", handler.GetReminderHistory(todoService, reminderService))
+	todos.POST("/:id/reminders/:rid/snooze", handler.SnoozeReminder(reminderService))
+	todos.POST("/:id/reminders/:rid/ack", handler.AckReminder(reminderService))
+
+	outbox := router.Group("/outbox", handler.RequestTimeout(cfg.RequestTimeout))
+	outbox.GET("", handler.GetOutboxEvents(outboxRelay))
+	outbox.POST("/:id/requeue", handler.RequeueOutboxEvent(outboxRelay))
+
+	// requests are started with this context, cancelling it stops their db work
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
 
 	srv := &http.Server{
 		Addr:    cfg.ServerAddress,
 		Handler: router,
+		BaseContext: func(net.Listener) context.Context {
+			return requestsCtx
+		},
 	}
 
 	// Step to handle termination signals (for graceful shutdown)
@@ -104,13 +163,20 @@ func main() {
 	log.Println("Shutdown signal received, starting graceful shutdown...")
 
 	reminderService.StopWorker()
+	// ends the notification streams, otherwise Shutdown waits for them until the timeout
+	notificationHub.Close()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		// stop requests that did not finish in time
+		cancelRequests()
+		log.Printf("Server forced to shutdown: %v", err)
 	}
+	// handles the events of the finished requests before the db is closed
+	outboxRelay.StopWorker()
+	bus.Close()
 
 	<-ctx.Done()
 	log.Println("Timeout of 3 seconds reached.")
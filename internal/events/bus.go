@@ -0,0 +1,204 @@
+// Package events is an in-process bus for domain events of todos. The todo service publishes
+// every change, reminders, notifications and audit logging subscribe to the ones they need.
+package events
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"toDoList/internal/model"
+)
+
+// Type names a kind of change
+type Type string
+
+const (
+	TodoCreated       Type = "todo.created"
+	TodoUpdated       Type = "todo.updated"        // every change by PUT or PATCH
+	TodoStatusChanged Type = "todo.status_changed" // published after TodoUpdated if the status changed
+	TodoImageUploaded Type = "todo.image_uploaded"
+	TodoDeleted       Type = "todo.deleted"
+)
+
+// DefaultAsyncBuffer is how many events an async subscriber may fall behind before events are dropped.
+// Async subscribers get an event at most once, a dropped one is not delivered again
+const DefaultAsyncBuffer = 256
+
+// timeout of a single async handler call, the request that published the event is over by then
+const asyncHandlerTimeout = 10 * time.Second
+
+var droppedCount = expvar.NewMap("events_dropped") // events dropped per async subscriber
+
+// Event is a change of a todo that is already stored
+type Event struct {
+	ID         string // of the outbox event, the same when the event is delivered again
+	Type       Type
+	TodoID     string
+	Todo       model.ToDo   // the todo after the change, only the ID is set for TodoDeleted
+	OldStatus  model.Status // set for TodoStatusChanged
+	OccurredAt time.Time
+}
+
+// Handler reacts to an event, an error is logged by the bus or returned by Publish
+type Handler func(ctx context.Context, event Event) error
+
+type subscriber struct {
+	name    string
+	types   map[Type]bool // all types if empty
+	handler Handler
+	queue   chan Event // nil for sync subscribers
+}
+
+func (s *subscriber) wants(eventType Type) bool {
+	return len(s.types) == 0 || s.types[eventType]
+}
+
+// Bus delivers events to sync subscribers in the publishing goroutine, in the order they subscribed,
+// and then, if all of them succeeded, to every async subscriber in its own goroutine, in the order
+// the events were published
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers []*subscriber
+	closed      bool
+	wg          sync.WaitGroup // running async subscribers
+}
+
+// NewBus creates a bus without subscribers
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe adds a sync subscriber for the event types, all types if none are given.
+// Publish waits for it and returns its error, so it suits work that must be done with the change
+func (b *Bus) Subscribe(name string, handler Handler, types ...Type) {
+	b.add(&subscriber{name: name, types: typeSet(types), handler: handler})
+}
+
+// SubscribeAsync adds a subscriber that handles the events in its own goroutine, so it does not slow
+// down requests. Up to buffer events are queued, further events are dropped until it catches up
+func (b *Bus) SubscribeAsync(name string, buffer int, handler Handler, types ...Type) {
+	if buffer <= 0 {
+		buffer = DefaultAsyncBuffer
+	}
+	sub := &subscriber{name: name, types: typeSet(types), handler: handler, queue: make(chan Event, buffer)}
+	if !b.add(sub) {
+		return
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for event := range sub.queue {
+			ctx, cancel := context.WithTimeout(context.Background(), asyncHandlerTimeout)
+			if err := sub.handler(ctx, event); err != nil {
+				log.Printf("Event subscriber %v could not handle %v of todo %v: %v", sub.name, event.Type, event.TodoID, err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// reports false if the bus is closed
+func (b *Bus) add(sub *subscriber) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		log.Printf("Event bus is closed, %v is not subscribed", sub.name)
+		return false
+	}
+	b.subscribers = append(b.subscribers, sub)
+	return true
+}
+
+// Publish runs the sync subscribers and returns their errors. Only if none failed the event is queued
+// for the async ones, so an event published again after a failure does not reach them twice.
+// It returns the number of async subscribers that dropped the event
+func (b *Bus) Publish(ctx context.Context, event Event) (int, error) {
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now().UTC()
+	}
+
+	// sync handlers run without the lock, so they may publish themselves while Close waits for it
+	b.mu.RLock()
+	subscribers := b.subscribers
+	closed := b.closed
+	b.mu.RUnlock()
+	if closed {
+		return 0, fmt.Errorf("event bus is closed, %v of todo %v is dropped", event.Type, event.TodoID)
+	}
+
+	var errs []error
+	for _, sub := range subscribers {
+		if sub.queue != nil || !sub.wants(event.Type) {
+			continue
+		}
+		if err := sub.handler(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", sub.name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return 0, errors.Join(errs...)
+	}
+
+	// queues are closed by Close under the lock, so they are only sent to while it is held
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return 0, fmt.Errorf("event bus is closed, %v of todo %v is not queued for async subscribers", event.Type, event.TodoID)
+	}
+	dropped := 0
+	for _, sub := range subscribers {
+		if sub.queue == nil || !sub.wants(event.Type) {
+			continue
+		}
+		select {
+		case sub.queue <- event:
+		default:
+			dropped++
+			droppedCount.Add(sub.name, 1)
+			log.Printf("Event subscriber %v is %d events behind, %v of todo %v is dropped",
+				sub.name, cap(sub.queue), event.Type, event.TodoID)
+		}
+	}
+	return dropped, nil
+}
+
+// Close stops accepting events and waits until the async subscribers handled the queued ones
+func (b *Bus) Close() {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		for _, sub := range b.subscribers {
+			if sub.queue != nil {
+				close(sub.queue)
+			}
+		}
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+func typeSet(types []Type) map[Type]bool {
+	set := make(map[Type]bool, len(types))
+	for _, eventType := range types {
+		set[eventType] = true
+	}
+	return set
+}
+
+// LogEvent is an audit log subscriber, it writes every event to the server log
+func LogEvent(ctx context.Context, event Event) error {
+	switch event.Type {
+	case TodoStatusChanged:
+		log.Printf("Audit: %v of todo %v from %q to %q", event.Type, event.TodoID, event.OldStatus, event.Todo.Status)
+	case TodoDeleted:
+		log.Printf("Audit: %v of todo %v", event.Type, event.TodoID)
+	default:
+		log.Printf("Audit: %v of todo %v, version %d", event.Type, event.TodoID, event.Todo.Version)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+	"toDoList/internal/model"
+)
+
+var ctx = context.Background()
+
+func event(eventType Type, todoID string) Event {
+	return Event{Type: eventType, TodoID: todoID, Todo: model.ToDo{ID: todoID}}
+}
+
+func TestPublishJoinsSyncErrors(t *testing.T) {
+	bus := NewBus()
+	defer bus.Close()
+	first, second := errors.New("first failed"), errors.New("second failed")
+	var calls []string
+	handler := func(name string, err error) Handler {
+		return func(ctx context.Context, event Event) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+	bus.Subscribe("a", handler("a", first))
+	bus.Subscribe("b", handler("b", nil))
+	bus.Subscribe("c", handler("c", second))
+	bus.Subscribe("deleted only", handler("deleted only", errors.New("not called")), TodoDeleted)
+
+	_, err := bus.Publish(ctx, event(TodoCreated, "1"))
+	if !errors.Is(err, first) || !errors.Is(err, second) {
+		t.Errorf("Publish returned %v, want both errors", err)
+	}
+	// a failing subscriber does not stop the later ones, they run in the order they subscribed
+	if len(calls) != 3 || calls[0] != "a" || calls[1] != "b" || calls[2] != "c" {
+		t.Errorf("subscribers ran as %v, want a, b, c", calls)
+	}
+
+	calls = nil
+	if _, err := bus.Publish(ctx, event(TodoUpdated, "1")); !errors.Is(err, first) || errors.Is(err, errors.New("not called")) {
+		t.Errorf("Publish of todo.updated returned %v", err)
+	}
+	if len(calls) != 3 {
+		t.Errorf("subscribers of todo.updated ran as %v, want a, b, c", calls)
+	}
+}
+
+func TestPublishSkipsAsyncAfterSyncError(t *testing.T) {
+	bus := NewBus()
+	var async []string
+	bus.SubscribeAsync("async", 10, func(ctx context.Context, event Event) error {
+		async = append(async, event.TodoID)
+		return nil
+	})
+	bus.Subscribe("sync", func(ctx context.Context, event Event) error {
+		if event.TodoID == "1" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	if _, err := bus.Publish(ctx, event(TodoCreated, "1")); err == nil {
+		t.Fatal("Publish returned no error of the sync subscriber")
+	}
+	if _, err := bus.Publish(ctx, event(TodoCreated, "2")); err != nil {
+		t.Fatal(err)
+	}
+	bus.Close()
+	if len(async) != 1 || async[0] != "2" {
+		t.Errorf("async subscriber got %v, want only 2, the sync subscriber failed on 1", async)
+	}
+}
+
+func TestPublishSetsOccurredAt(t *testing.T) {
+	bus := NewBus()
+	defer bus.Close()
+	var got Event
+	bus.Subscribe("sync", func(ctx context.Context, event Event) error {
+		got = event
+		return nil
+	})
+	if _, err := bus.Publish(ctx, event(TodoCreated, "1")); err != nil {
+		t.Fatal(err)
+	}
+	if got.OccurredAt.IsZero() || got.TodoID != "1" {
+		t.Errorf("sync subscriber got %+v, want the event with its time", got)
+	}
+}
+
+func TestAsyncSubscriberKeepsOrder(t *testing.T) {
+	bus := NewBus()
+	var mu sync.Mutex
+	var got []string
+	bus.SubscribeAsync("async", 1000, func(ctx context.Context, event Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, event.TodoID)
+		return errors.New("logged, not returned")
+	}, TodoCreated)
+
+	var want []string
+	for i := 0; i < 500; i++ {
+		id := strconv.Itoa(i)
+		want = append(want, id)
+		if _, err := bus.Publish(ctx, event(TodoCreated, id)); err != nil {
+			t.Fatalf("Publish returned %v, async errors are only logged", err)
+		}
+		bus.Publish(ctx, event(TodoDeleted, id)) // not subscribed
+	}
+	bus.Close() // waits for the queued events
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != len(want) {
+		t.Fatalf("async subscriber got %d events, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("async subscriber got %v at %d, want %v", got[i], i, want[i])
+		}
+	}
+}
+
+func TestAsyncSubscriberDropsWhenFull(t *testing.T) {
+	bus := NewBus()
+	started, release := make(chan struct{}), make(chan struct{})
+	var mu sync.Mutex
+	var slow, fast []string
+	bus.SubscribeAsync("slow", 1, func(ctx context.Context, event Event) error {
+		if event.TodoID == "1" {
+			close(started)
+			<-release
+		}
+		mu.Lock()
+		slow = append(slow, event.TodoID)
+		mu.Unlock()
+		return nil
+	})
+	bus.SubscribeAsync("fast", 10, func(ctx context.Context, event Event) error {
+		mu.Lock()
+		fast = append(fast, event.TodoID)
+		mu.Unlock()
+		return nil
+	})
+	before := droppedBy("slow")
+
+	bus.Publish(ctx, event(TodoCreated, "1"))
+	<-started // the slow subscriber handles 1, its queue is empty
+	for i, id := range []string{"2", "3", "4"} {
+		dropped, err := bus.Publish(ctx, event(TodoCreated, id))
+		if err != nil {
+			t.Fatalf("Publish returned %v, drops are not errors", err)
+		}
+		if want := min(i, 1); dropped != want {
+			t.Errorf("Publish of %v reported %d drops, want %d", id, dropped, want)
+		}
+	}
+	close(release)
+	bus.Close()
+
+	if len(slow) != 2 || slow[0] != "1" || slow[1] != "2" {
+		t.Errorf("slow subscriber got %v, want 1 and 2, the rest did not fit into its queue", slow)
+	}
+	if len(fast) != 4 {
+		t.Errorf("fast subscriber got %v, want every event", fast)
+	}
+	if dropped := droppedBy("slow") - before; dropped != 2 {
+		t.Errorf("events_dropped of slow grew by %d, want 2", dropped)
+	}
+}
+
+func droppedBy(name string) int64 {
+	if v, ok := droppedCount.Get(name).(interface{ Value() int64 }); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestCloseDrainsQueuedEvents(t *testing.T) {
+	bus := NewBus()
+	var handled int
+	bus.SubscribeAsync("async", 100, func(ctx context.Context, event Event) error {
+		time.Sleep(time.Millisecond)
+		handled++
+		return nil
+	})
+	for i := 0; i < 20; i++ {
+		bus.Publish(ctx, event(TodoCreated, "1"))
+	}
+	bus.Close()
+	if handled != 20 {
+		t.Errorf("Close returned after %d of 20 queued events were handled", handled)
+	}
+
+	// a closed bus rejects events and subscribers
+	if _, err := bus.Publish(ctx, event(TodoCreated, "1")); err == nil {
+		t.Error("Publish on a closed bus returned no error")
+	}
+	bus.SubscribeAsync("late", 1, func(ctx context.Context, event Event) error { return nil })
+	bus.Close() // more than once is fine
+}
+
+func TestSyncHandlerPublishesWhileClosing(t *testing.T) {
+	bus := NewBus()
+	entered := make(chan struct{})
+	inner := make(chan error, 1)
+	bus.Subscribe("publisher", func(ctx context.Context, event Event) error {
+		close(entered)
+		time.Sleep(50 * time.Millisecond) // Close is waiting meanwhile
+		_, err := bus.Publish(ctx, Event{Type: TodoStatusChanged, TodoID: event.TodoID, Todo: event.Todo})
+		inner <- err
+		return nil
+	}, TodoCreated)
+
+	published := make(chan struct{})
+	go func() {
+		bus.Publish(ctx, event(TodoCreated, "1"))
+		close(published)
+	}()
+	<-entered
+	closed := make(chan struct{})
+	go func() {
+		bus.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a sync handler that publishes deadlocked with Close")
+	}
+	<-closed
+	if err := <-inner; err == nil {
+		t.Error("Publish from the handler after Close returned no error")
+	}
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"toDoList/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statusFromError maps service errors to HTTP status codes
+func statusFromError(err error) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled): // client went away or server is shutting down
+		return http.StatusServiceUnavailable
+	case errors.Is(err, service.ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// respondError sends the error with the status code matching its kind
+func respondError(c *gin.Context, message string, err error) {
+	c.JSON(statusFromError(err), gin.H{"message": message, "error": err.Error()})
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"toDoList/internal/model"
+	"toDoList/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// todoETag returns a strong entity tag of the todo. Every change moves the version, so the tag
+// is derived from the ID and the version only, not from the body: storages may return the same
+// version with times in another zone, e.g. Postgres reads them in the local one
+func todoETag(todo model.ToDo) string {
+	return `"` + url.PathEscape(todo.ID) + "-" + strconv.FormatInt(todo.Version, 10) + `"`
+}
+
+// reports whether the If-Match or If-None-Match header lists the etag.
+// If-None-Match uses the weak comparison, so W/ tags match too
+func etagMatches(header string, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch evaluates the If-Match header of the request and returns the todo
+// the change must be applied to, a zero todo if the header is not set. If the condition
+// fails, the response is sent and ok is false
+func checkIfMatch(c *gin.Context, todoService service.TodoService, id string) (current model.ToDo, ok bool) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return model.ToDo{}, true
+	}
+
+	current, err := todoService.GetTodoById(c.Request.Context(), id)
+	if errors.Is(err, service.ErrNotFound) {
+		preconditionFailed(c, err)
+		return model.ToDo{}, false
+	}
+	if err != nil {
+		respondError(c, "Could not get todo", err)
+		return model.ToDo{}, false
+	}
+	if !etagMatches(header, todoETag(current), false) {
+		preconditionFailed(c, errors.New("todo was changed, If-Match does not match its ETag"))
+		return model.ToDo{}, false
+	}
+	return current, true
+}
+
+func preconditionFailed(c *gin.Context, err error) {
+	c.JSON(http.StatusPreconditionFailed, gin.H{"message": "Precondition failed", "error": err.Error()})
+}
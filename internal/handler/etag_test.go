@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"toDoList/internal/model"
+)
+
+func TestTodoETagIgnoresTimeZone(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := model.ToDo{ID: "1", Title: "a", CreatedAt: created, UpdatedAt: created, Version: 3}
+	local := utc
+	local.CreatedAt, local.UpdatedAt = created.In(berlin), created.In(berlin)
+
+	if todoETag(utc) != todoETag(local) {
+		t.Errorf("todoETag differs by time zone: %v and %v", todoETag(utc), todoETag(local))
+	}
+	next := utc
+	next.Version++
+	if todoETag(utc) == todoETag(next) {
+		t.Errorf("todoETag of versions 3 and 4 is the same: %v", todoETag(utc))
+	}
+}
+
+func TestTodoETagQuotesID(t *testing.T) {
+	etag := todoETag(model.ToDo{ID: `a "b"`, Version: 1})
+	if inner := strings.Trim(etag, `"`); strings.ContainsAny(inner, `" `) || len(inner) != len(etag)-2 {
+		t.Errorf("todoETag returned %v, want an opaque tag without quotes or spaces inside", etag)
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		weak   bool
+		want   bool
+	}{
+		{`"1-2"`, false, true},
+		{`"1-1", "1-2"`, false, true},
+		{`*`, false, true},
+		{`"1-1"`, false, false},
+		{`W/"1-2"`, false, false},
+		{`W/"1-2"`, true, true},
+		{``, true, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, `"1-2"`, tt.weak); got != tt.want {
+			t.Errorf("etagMatches(%q, weak %v) = %v, want %v", tt.header, tt.weak, got, tt.want)
+		}
+	}
+}
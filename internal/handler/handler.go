@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -8,14 +10,20 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 	"toDoList/internal/model"
 	"toDoList/internal/service"
+	"toDoList/internal/storage"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/time/rate"
 )
 
+// comments sent on idle notification streams, so proxies do not close them
+const sseHeartbeatInterval = 15 * time.Second
+
 var (
 	limiter   = make(map[string]*rate.Limiter) // map limit for every user
 	rateLimit = rate.Every(1 * time.Second)    // Limit: 1 request per second
@@ -61,28 +69,91 @@ func MaxConnections(limit int) gin.HandlerFunc {
 	}
 }
 
-func SSENotificationHandler(notificationChannel chan string) gin.HandlerFunc {
+// RequestTimeout cancels the request context after the timeout, so db calls stop waiting
+func RequestTimeout(timeout time.Duration) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// SSENotificationHandler streams every notification to the client until the client disconnects,
+// falls behind or the server stops. A client reconnecting with Last-Event-ID first gets the
+// notifications it missed
+func SSENotificationHandler(notifications *service.NotificationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var lastID int64
+		if header := c.GetHeader("Last-Event-ID"); header != "" {
+			var err error
+			if lastID, err = strconv.ParseInt(header, 10, 64); err != nil || lastID < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "Incorrect data", "error": "Last-Event-ID must be a notification ID"})
+				return
+			}
+		}
+
+		// every connection gets its own subscription, so every client gets every notification.
+		// It is taken before the log is read, so nothing is lost in between
+		sub := notifications.Subscribe()
+		defer sub.Close()
+
+		var missed []model.Notification
+		if lastID > 0 {
+			var err error
+			if missed, err = notifications.Replay(c.Request.Context(), lastID); err != nil {
+				respondError(c, "Could not read missed notifications", err)
+				return
+			}
+		}
+
 		// Set heasers for SSE
 		c.Header("Content-Type", "text/event-stream")
 		c.Header("Cache-Control", "no-cache")
 		c.Header("Connection", "keep-alive")
+		for _, notification := range missed {
+			writeSSEEvent(c.Writer, notification)
+			lastID = notification.ID
+		}
 		c.Writer.Flush()
 
-		// Sent notification to the client through SSE, if exist in the channel
-		for msg := range notificationChannel {
-			// Sent notification in format SSE
-			fmt.Fprintf(c.Writer, "data: %s\n\n", msg)
-			c.Writer.Flush()
-
-			// Close if client closed connection
-			if c.Writer.CloseNotify() != nil {
-				return
+		heartbeat := time.NewTicker(sseHeartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case notification, ok := <-sub.C:
+				if !ok {
+					return // evicted or the server stops, the browser reconnects
+				}
+				if notification.ID != 0 && notification.ID <= lastID {
+					continue // already replayed
+				}
+				writeSSEEvent(c.Writer, notification)
+				c.Writer.Flush()
+			case <-heartbeat.C:
+				fmt.Fprint(c.Writer, ": heartbeat\n\n")
+				c.Writer.Flush()
+			case <-c.Request.Context().Done():
+				return // client closed connection
 			}
 		}
 	}
 }
 
+// writes the notification as an SSE event, a notification that was not logged has no ID
+func writeSSEEvent(w io.Writer, notification model.Notification) {
+	if notification.ID != 0 {
+		fmt.Fprintf(w, "id: %d\n", notification.ID)
+	}
+	fmt.Fprintf(w, "event: %s\n", notification.Type)
+	// every line of the data needs its own field
+	for _, line := range strings.Split(notification.Data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
 func HomePage(todoService service.TodoService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		time.Sleep(5 * time.Second)
@@ -90,28 +161,71 @@ func HomePage(todoService service.TodoService) gin.HandlerFunc {
 	}
 }
 
+// DBStats returns the connection pool statistics if the storage has a pool
+func DBStats(store storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, ok := store.(storage.StatsProvider)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Storage does not use a connection pool"})
+			return
+		}
+		c.JSON(http.StatusOK, provider.PoolStats())
+	}
+}
+
+// GetToDos returns a page of todos, supported query parameters:
+// status, title (part of the title), sort (id, title, status), order (asc, desc), limit and cursor
 func GetToDos(todoService service.TodoService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		todos, err := todoService.GetAllTodos()
+		opts := model.ListOptions{
+			Status: model.Status(c.Query("status")),
+			Title:  c.Query("title"),
+			SortBy: c.Query("sort"),
+			Cursor: c.Query("cursor"),
+		}
+
+		switch order := c.DefaultQuery("order", "asc"); order {
+		case "asc":
+		case "desc":
+			opts.SortDesc = true
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Incorrect data", "error": "order must be asc or desc"})
+			return
+		}
+
+		if limit := c.Query("limit"); limit != "" {
+			var err error
+			opts.Limit, err = strconv.Atoi(limit)
+			if err != nil || opts.Limit < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "Incorrect data", "error": "limit must be a positive number"})
+				return
+			}
+		}
+
+		page, err := todoService.GetAllTodos(c.Request.Context(), opts)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "There are no any todos", "error": err.Error()})
+			respondError(c, "Could not get todos", err)
 			return
 		}
-		c.JSON(http.StatusOK, todos)
+		c.JSON(http.StatusOK, page)
 	}
 }
 
 func GetToDosById(todoService service.TodoService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
-		todo, err := todoService.GetTodoById(id)
+		todo, err := todoService.GetTodoById(c.Request.Context(), id)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"message": "todo not found"})
+			respondError(c, "Could not get todo", err)
 			return
 		}
-		// Add the path to the image
-		if todo.ImagePath != "" {
-			todo.ImagePath = "/images/" + filepath.Base(todo.ImagePath)
+		todo = todo.Public()
+
+		etag := todoETag(todo)
+		c.Header("ETag", etag)
+		if etagMatches(c.GetHeader("If-None-Match"), etag, true) {
+			c.Status(http.StatusNotModified) // the client already has this version
+			return
 		}
 		c.JSON(http.StatusOK, todo)
 	}
@@ -121,9 +235,9 @@ func GetTodosImageById(todoService service.TodoService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
 
-		todo, err := todoService.GetTodoImageById(id)
+		todo, err := todoService.GetTodoImageById(c.Request.Context(), id)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"message": "Todo not found"})
+			respondError(c, "Could not get todo", err)
 			return
 		}
 
@@ -145,7 +259,7 @@ func GetTodosImageById(todoService service.TodoService) gin.HandlerFunc {
 	}
 }
 
-func PostToDos(todoService service.TodoService, reminderService *service.ReminderService) gin.HandlerFunc {
+func PostToDos(todoService service.TodoService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var newTodo model.ToDo
 		if err := c.BindJSON(&newTodo); err != nil {
@@ -153,28 +267,14 @@ func PostToDos(todoService service.TodoService, reminderService *service.Reminde
 			return
 		}
 
-		if newTodo.ReminderTime != "" {
-			duration, err := time.ParseDuration(newTodo.ReminderTime)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid time format", "error": err.Error()})
-				return
-			}
-
-			reminderTime := time.Now().Add(duration)
-
-			reminderService.AddReminder(service.Reminder{
-				ID:           newTodo.ID,
-				ReminderTime: reminderTime,
-				TaskName:     newTodo.Title,
-			})
-		}
-
-		err := todoService.AddTodo(newTodo)
+		created, err := todoService.AddTodo(c.Request.Context(), newTodo)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not add todo", "error": err.Error()})
+			respondError(c, "Could not add todo", err)
 			return
 		}
-		c.JSON(http.StatusCreated, gin.H{"message": "todo added"})
+		created = created.Public()
+		c.Header("ETag", todoETag(created))
+		c.JSON(http.StatusCreated, gin.H{"message": "todo added", "todo": created})
 	}
 }
 
@@ -186,12 +286,78 @@ func UpdateToDos(todoService service.TodoService) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"message": "Incorrect data", "error": err.Error()})
 			return
 		}
-		err := todoService.UpdateTodo(id, updatedTodo)
+		current, ok := checkIfMatch(c, todoService, id)
+		if !ok {
+			return
+		}
+		// a stale version in the body makes the update fail with 409
+		version := current.Version
+		if updatedTodo.Version == 0 {
+			updatedTodo.Version = version
+		}
+
+		todo, err := todoService.UpdateTodo(c.Request.Context(), id, updatedTodo)
+		if errors.Is(err, service.ErrConflict) && version != 0 && updatedTodo.Version == version {
+			preconditionFailed(c, err) // changed after If-Match was checked
+			return
+		}
+		if err != nil {
+			respondError(c, "Could not update todo", err)
+			return
+		}
+		todo = todo.Public()
+		c.Header("ETag", todoETag(todo))
+		c.JSON(http.StatusOK, gin.H{"message": "todo updated", "todo": todo})
+	}
+}
+
+// PatchToDos changes only the fields present in a JSON Merge Patch (RFC 7396)
+// or JSON Patch (RFC 6902) document
+func PatchToDos(todoService service.TodoService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		contentType := c.ContentType()
+		if contentType != mergePatchType && contentType != jsonPatchType {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"message": "Unsupported media type",
+				"error": fmt.Sprintf("use %s or %s", mergePatchType, jsonPatchType)})
+			return
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Incorrect data", "error": err.Error()})
+			return
+		}
+
+		current, ok := checkIfMatch(c, todoService, id)
+		if !ok {
+			return
+		}
+		ifMatch := current.Version != 0
+		if !ifMatch {
+			if current, err = todoService.GetTodoById(c.Request.Context(), id); err != nil {
+				respondError(c, "Could not get todo", err)
+				return
+			}
+		}
+
+		patch, err := patchTodo(current.Public(), contentType, body)
+		if err != nil {
+			respondError(c, "Could not apply patch", err)
+			return
+		}
+		// the patch was applied to this version, so it must not have changed since
+		todo, err := todoService.PatchTodo(c.Request.Context(), id, patch, current.Version)
+		if errors.Is(err, service.ErrConflict) && ifMatch {
+			preconditionFailed(c, err)
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"message": "todo not found"})
+			respondError(c, "Could not update todo", err)
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"message": "todo updated"})
+		todo = todo.Public()
+		c.Header("ETag", todoETag(todo))
+		c.JSON(http.StatusOK, gin.H{"message": "todo updated", "todo": todo})
 	}
 }
 
@@ -248,9 +414,9 @@ func UploadToDoImage(todoService service.TodoService) gin.HandlerFunc {
 		}
 
 		// update ToDo in db with new file path
-		err = todoService.UpdateTodoImage(id, imagePath)
+		err = todoService.UpdateTodoImage(c.Request.Context(), id, imagePath)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"message": "Todo not found"})
+			respondError(c, "Could not update todo image", err)
 			return
 		}
 
@@ -261,9 +427,17 @@ func UploadToDoImage(todoService service.TodoService) gin.HandlerFunc {
 func DeleteToDosById(todoService service.TodoService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
-		err := todoService.DeleteTodo(id)
+		current, ok := checkIfMatch(c, todoService, id)
+		if !ok {
+			return
+		}
+		err := todoService.DeleteTodo(c.Request.Context(), id, current.Version)
+		if errors.Is(err, service.ErrConflict) {
+			preconditionFailed(c, err) // changed after If-Match was checked
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"message": "todo not found"})
+			respondError(c, "Could not delete todo", err)
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"message": "todo deleted"})
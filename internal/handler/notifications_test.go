@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"toDoList/internal/model"
+	"toDoList/internal/notify"
+	"toDoList/internal/service"
+	"toDoList/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// replayHook runs beforeReplay the first time the log is read, so a test can publish
+// a notification after the handler subscribed but before it read the log
+type replayHook struct {
+	storage.NotificationStorage
+	beforeReplay func()
+}
+
+func (h *replayHook) GetNotifications(ctx context.Context, afterID int64, limit int) ([]model.Notification, error) {
+	if hook := h.beforeReplay; hook != nil {
+		h.beforeReplay = nil
+		hook()
+	}
+	return h.NotificationStorage.GetNotifications(ctx, afterID, limit)
+}
+
+func newSSEServer(t *testing.T, notifications *service.NotificationService) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/notifications", SSENotificationHandler(notifications))
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// sseEvent is an event read from the stream
+type sseEvent struct {
+	id, event, data string
+}
+
+// opens the stream with the Last-Event-ID and returns a function that reads its next event
+func openStream(t *testing.T, url string, lastEventID string) func() sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/notifications", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /notifications failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET /notifications returned %v %v", resp.Status, resp.Header.Get("Content-Type"))
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	return func() sseEvent {
+		t.Helper()
+		var event sseEvent
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("could not read the stream: %v", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "" && event.event != "":
+				return event
+			case strings.HasPrefix(line, "id: "):
+				event.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}
+}
+
+func expectEvent(t *testing.T, next func() sseEvent, id, data string) {
+	t.Helper()
+	if event := next(); event.id != id || event.data != data {
+		t.Fatalf("received event %+v, want id %v with data %v", event, id, data)
+	}
+}
+
+func TestSSEReplaysMissedNotifications(t *testing.T) {
+	hook := &replayHook{NotificationStorage: storage.NewMemoryDb()}
+	notifications := service.NewNotificationService(hook, notify.NewHub(8), 100)
+	ctx := context.Background()
+	for _, n := range []string{"1", "2", "3"} {
+		notifications.Notify(ctx, model.NotificationTodoCreated, map[string]string{"n": n})
+	}
+	// published after the handler subscribed, so it is in the log and on the hub, it is sent once
+	hook.beforeReplay = func() {
+		notifications.Notify(ctx, model.NotificationTodoUpdated, map[string]string{"n": "4"})
+	}
+
+	next := openStream(t, newSSEServer(t, notifications).URL, "1")
+	expectEvent(t, next, "2", `{"n":"2"}`)
+	expectEvent(t, next, "3", `{"n":"3"}`)
+	expectEvent(t, next, "4", `{"n":"4"}`)
+
+	notifications.Notify(ctx, model.NotificationTodoDeleted, map[string]string{"n": "5"})
+	event := next()
+	if event.id != "5" || event.event != string(model.NotificationTodoDeleted) {
+		t.Fatalf("received event %+v after the replay, want 5 %v", event, model.NotificationTodoDeleted)
+	}
+}
+
+func TestSSEWithoutLastEventID(t *testing.T) {
+	hub := notify.NewHub(8)
+	notifications := service.NewNotificationService(storage.NewMemoryDb(), hub, 100)
+	ctx := context.Background()
+	notifications.Notify(ctx, model.NotificationTodoCreated, map[string]string{"n": "1"})
+
+	// a new client only gets what is published after it connected
+	server := newSSEServer(t, notifications)
+	next := openStream(t, server.URL, "")
+	for deadline := time.Now().Add(5 * time.Second); hub.Len() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the client did not subscribe")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	notifications.Notify(ctx, model.NotificationTodoCreated, map[string]string{"n": "2"})
+	expectEvent(t, next, "2", `{"n":"2"}`)
+}
+
+func TestSSEInvalidLastEventID(t *testing.T) {
+	notifications := service.NewNotificationService(storage.NewMemoryDb(), notify.NewHub(8), 100)
+	server := newSSEServer(t, notifications)
+
+	for _, id := range []string{"abc", "-1"} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/notifications", nil)
+		req.Header.Set("Last-Event-ID", id)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Last-Event-ID %q returned %v, want 400", id, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"toDoList/internal/model"
+	"toDoList/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetOutboxEvents returns the outbox events in the status, dead ones by default, oldest first
+func GetOutboxEvents(relay *service.OutboxRelay) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := model.OutboxStatus(c.DefaultQuery("status", string(model.OutboxDead)))
+		limit := 50
+		if value := c.Query("limit"); value != "" {
+			var err error
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > 200 {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "Incorrect data", "error": "limit must be a number from 1 to 200"})
+				return
+			}
+		}
+
+		outbox, err := relay.Events(c.Request.Context(), status, limit)
+		if err != nil {
+			respondError(c, "Could not get outbox events", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"events": outbox})
+	}
+}
+
+// RequeueOutboxEvent publishes a dead outbox event again
+func RequeueOutboxEvent(relay *service.OutboxRelay) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		event, err := relay.Requeue(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			respondError(c, "Could not requeue outbox event", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "outbox event requeued", "event": event})
+	}
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"toDoList/internal/model"
+	"toDoList/internal/service"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// media types accepted by PATCH /todos/:id
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// applies the patch document to the todo as clients see it and returns the changed fields
+func patchTodo(todo model.ToDo, contentType string, body []byte) (model.ToDoPatch, error) {
+	doc, err := json.Marshal(todo)
+	if err != nil {
+		return model.ToDoPatch{}, err
+	}
+
+	var patched []byte
+	if contentType == mergePatchType {
+		patched, err = jsonpatch.MergePatch(doc, body)
+	} else {
+		var operations jsonpatch.Patch
+		if operations, err = jsonpatch.DecodePatch(body); err == nil {
+			patched, err = operations.Apply(doc)
+		}
+	}
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		return model.ToDoPatch{}, fmt.Errorf("%v: %w", err, service.ErrConflict)
+	}
+	if err != nil {
+		return model.ToDoPatch{}, fmt.Errorf("invalid patch: %v: %w", err, service.ErrValidation)
+	}
+
+	var result model.ToDo
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&result); err != nil {
+		return model.ToDoPatch{}, fmt.Errorf("invalid patch: %v: %w", err, service.ErrValidation)
+	}
+
+	// only title, status and due date can be changed, same as with PUT
+	for _, field := range []struct {
+		name    string
+		changed bool
+	}{
+		{"id", result.ID != todo.ID},
+		{"image_path", result.ImagePath != todo.ImagePath},
+		{"reminder_time", result.ReminderTime != todo.ReminderTime},
+		{"time_zone", result.TimeZone != todo.TimeZone},
+		{"reminder_at", !sameInstant(result.ReminderAt, todo.ReminderAt)},
+		{"created_at", !result.CreatedAt.Equal(todo.CreatedAt)},
+		{"updated_at", !result.UpdatedAt.Equal(todo.UpdatedAt)},
+		{"version", result.Version != todo.Version},
+	} {
+		if field.changed {
+			return model.ToDoPatch{}, fmt.Errorf("field %s cannot be changed: %w", field.name, service.ErrValidation)
+		}
+	}
+
+	var patch model.ToDoPatch
+	if result.Title != todo.Title {
+		patch.Title = &result.Title
+	}
+	if result.Status != todo.Status {
+		patch.Status = &result.Status
+	}
+	if !sameInstant(result.DueAt, todo.DueAt) {
+		patch.DueAt = &result.DueAt
+	}
+	return patch, nil
+}
+
+// reports whether both times are unset or are the same instant
+func sameInstant(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"net/http"
+	"toDoList/internal/model"
+	"toDoList/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetReminders returns the reminders of the todo in every state, ordered by time
+func GetReminders(todoService service.TodoService, reminderService *service.ReminderService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if _, err := todoService.GetTodoById(c.Request.Context(), id); err != nil {
+			respondError(c, "Could not get todo", err)
+			return
+		}
+
+		reminders, err := reminderService.TodoReminders(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, "Could not get reminders", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"reminders": reminders})
+	}
+}
+
+// PostReminder adds a reminder to the todo, at an absolute time or at an offset from its due date
+func PostReminder(todoService service.TodoService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var spec model.ReminderSpec
+		if err := c.BindJSON(&spec); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Incorrect data", "error": err.Error()})
+			return
+		}
+
+		reminder, err := todoService.AddReminder(c.Request.Context(), c.Param("id"), spec)
+		if err != nil {
+			respondError(c, "Could not add reminder", err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "reminder added", "reminder": reminder})
+	}
+}
+
+// RescheduleReminders moves the reminders of the todo at a fixed time to another time
+func RescheduleReminders(todoService service.TodoService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			At string `json:"at"` // a time like "tomorrow 9am", read in the time zone of the todo
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Incorrect data", "error": err.Error()})
+			return
+		}
+
+		reminders, err := todoService.RescheduleReminders(c.Request.Context(), c.Param("id"), body.At)
+		if err != nil {
+			respondError(c, "Could not reschedule reminders", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "reminders rescheduled", "reminders": reminders})
+	}
+}
+
+// DeleteReminder removes a reminder of the todo
+func DeleteReminder(reminderService *service.ReminderService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := reminderService.Delete(c.Request.Context(), c.Param("id"), c.Param("rid")); err != nil {
+			respondError(c, "Could not delete reminder", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "reminder deleted"})
+	}
+}
+
+// SnoozeReminder puts a reminder of the todo off for a duration or a preset
+func SnoozeReminder(reminderService *service.ReminderService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var snooze service.Snooze
+		if err := c.BindJSON(&snooze); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Incorrect data", "error": err.Error()})
+			return
+		}
+
+		reminder, err := reminderService.Snooze(c.Request.Context(), c.Param("id"), c.Param("rid"), snooze)
+		if err != nil {
+			respondError(c, "Could not snooze reminder", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "reminder snoozed", "reminder": reminder})
+	}
+}
+
+// AckReminder stops a reminder of the todo
+func AckReminder(reminderService *service.ReminderService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reminder, err := reminderService.Acknowledge(c.Request.Context(), c.Param("id"), c.Param("rid"))
+		if err != nil {
+			respondError(c, "Could not acknowledge reminder", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "reminder acknowledged", "reminder": reminder})
+	}
+}
+
+// GetReminderHistory returns every transition of the reminders of the todo, oldest first
+func GetReminderHistory(todoService service.TodoService, reminderService *service.ReminderService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if _, err := todoService.GetTodoById(c.Request.Context(), id); err != nil {
+			respondError(c, "Could not get todo", err)
+			return
+		}
+
+		events, err := reminderService.History(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, "Could not get reminder history", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"events": events})
+	}
+}
@@ -0,0 +1,259 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"toDoList/internal/events"
+	"toDoList/internal/model"
+	"toDoList/internal/service"
+	"toDoList/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// reminderResponse is the body of a reminder endpoint
+type reminderResponse struct {
+	Message   string                `json:"message"`
+	Error     string                `json:"error"`
+	Reminder  model.Reminder        `json:"reminder"`
+	Reminders []model.Reminder      `json:"reminders"`
+	Events    []model.ReminderEvent `json:"events"`
+}
+
+type reminderTest struct {
+	t         *testing.T
+	url       string
+	todos     service.TodoService
+	reminders *service.ReminderService
+	now       time.Time
+}
+
+// starts the reminder endpoints with the services wired as in main, their clock stays at 2024-03-01 09:00 UTC, a Friday
+func newReminderTest(t *testing.T) *reminderTest {
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	store := storage.NewMemoryDb()
+	reminders := service.NewReminderService(store, make(chan model.ReminderNotification, 10), clock)
+	bus := events.NewBus()
+	t.Cleanup(bus.Close)
+	bus.Subscribe("reminders", reminders.HandleTodoEvent, events.TodoCreated, events.TodoUpdated, events.TodoDeleted)
+	relay := service.NewOutboxRelay(store, bus, 10, time.Hour)
+	todos := service.NewTodoService(store, reminders, relay, time.UTC, clock)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/todos/:id/reminders", GetReminders(todos, reminders))
+	router.POST("/todos/:id/reminders", PostReminder(todos))
+	router.POST("/todos/:id/reminders/reschedule", RescheduleReminders(todos))
+	router.DELETE("/todos/:id/reminders/:rid", DeleteReminder(reminders))
+	router.GET("/todos/:id/reminders/history", GetReminderHistory(todos, reminders))
+	router.POST("/todos/:id/reminders/:rid/snooze", SnoozeReminder(reminders))
+	router.POST("/todos/:id/reminders/:rid/ack", AckReminder(reminders))
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return &reminderTest{t: t, url: server.URL, todos: todos, reminders: reminders, now: now}
+}
+
+// adds a todo, its reminder is added when todo.created is published
+func (r *reminderTest) addTodo(todo model.ToDo) model.ToDo {
+	r.t.Helper()
+	if todo.Status == "" {
+		todo.Status = model.Created
+	}
+	added, err := r.todos.AddTodo(context.Background(), todo)
+	if err != nil {
+		r.t.Fatalf("AddTodo failed: %v", err)
+	}
+	return added
+}
+
+// returns the only reminder of the todo
+func (r *reminderTest) reminder(todoID string) model.Reminder {
+	r.t.Helper()
+	status, resp := r.call(http.MethodGet, "/todos/"+todoID+"/reminders", "")
+	if status != http.StatusOK || len(resp.Reminders) != 1 {
+		r.t.Fatalf("GET reminders returned %v %+v, want one reminder", status, resp)
+	}
+	return resp.Reminders[0]
+}
+
+// sends the request and returns its status and body
+func (r *reminderTest) call(method, path, body string) (int, reminderResponse) {
+	r.t.Helper()
+	req, err := http.NewRequest(method, r.url+path, strings.NewReader(body))
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		r.t.Fatalf("%v %v failed: %v", method, path, err)
+	}
+	defer res.Body.Close()
+	var resp reminderResponse
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		r.t.Fatalf("%v %v returned an invalid body: %v", method, path, err)
+	}
+	return res.StatusCode, resp
+}
+
+// returns the types of the recorded transitions of the todo
+func (r *reminderTest) history(todoID string) string {
+	r.t.Helper()
+	status, resp := r.call(http.MethodGet, "/todos/"+todoID+"/reminders/history", "")
+	if status != http.StatusOK {
+		r.t.Fatalf("GET history returned %v %+v", status, resp)
+	}
+	var types []string
+	for _, event := range resp.Events {
+		types = append(types, string(event.Type))
+	}
+	return strings.Join(types, ",")
+}
+
+func TestSnoozeAndAckReminder(t *testing.T) {
+	r := newReminderTest(t)
+	todo := r.addTodo(model.ToDo{Title: "call", ReminderTime: "in 1 hour", TimeZone: "Europe/Berlin"})
+	reminder := r.reminder(todo.ID)
+	path := "/todos/" + todo.ID + "/reminders/" + reminder.ID
+
+	status, resp := r.call(http.MethodPost, path+"/snooze", `{"duration": "30m"}`)
+	if status != http.StatusOK || !resp.Reminder.ReminderTime.Equal(r.now.Add(30*time.Minute)) ||
+		resp.Reminder.State != model.ReminderPending {
+		t.Fatalf("snooze for 30m returned %v %+v, want the reminder pending at %v", status, resp, r.now.Add(30*time.Minute))
+	}
+	// presets are read in the time zone of the reminder, 9am in Berlin is 8am UTC
+	status, resp = r.call(http.MethodPost, path+"/snooze", `{"preset": "tomorrow"}`)
+	if want := time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC); status != http.StatusOK || !resp.Reminder.ReminderTime.Equal(want) {
+		t.Fatalf("snooze until tomorrow returned %v %+v, want the reminder at %v", status, resp, want)
+	}
+
+	status, resp = r.call(http.MethodPost, path+"/ack", "")
+	if status != http.StatusOK || resp.Reminder.State != model.ReminderAcknowledged {
+		t.Fatalf("ack returned %v %+v, want the reminder acknowledged", status, resp)
+	}
+	if got := r.reminder(todo.ID); got.State != model.ReminderAcknowledged {
+		t.Errorf("stored reminder is %v after the ack", got.State)
+	}
+
+	// an acknowledged reminder stays so
+	if status, resp := r.call(http.MethodPost, path+"/snooze", `{"duration": "30m"}`); status != http.StatusConflict {
+		t.Errorf("snooze of an acknowledged reminder returned %v %+v, want 409", status, resp)
+	}
+	if status, resp := r.call(http.MethodPost, path+"/ack", ""); status != http.StatusConflict {
+		t.Errorf("second ack returned %v %+v, want 409", status, resp)
+	}
+	if got := r.history(todo.ID); got != "created,snoozed,snoozed,acknowledged" {
+		t.Errorf("history is %v, want created,snoozed,snoozed,acknowledged", got)
+	}
+}
+
+func TestSnoozeReminderRejectsInvalidRequests(t *testing.T) {
+	r := newReminderTest(t)
+	todo := r.addTodo(model.ToDo{Title: "call", ReminderTime: "in 1 hour"})
+	other := r.addTodo(model.ToDo{Title: "other"})
+	reminder := r.reminder(todo.ID)
+
+	tests := []struct {
+		path string
+		body string
+		want int
+	}{
+		{"/todos/" + todo.ID + "/reminders/" + reminder.ID, `{"duration": "-5m"}`, http.StatusBadRequest},
+		{"/todos/" + todo.ID + "/reminders/" + reminder.ID, `{"duration": "soon"}`, http.StatusBadRequest},
+		{"/todos/" + todo.ID + "/reminders/" + reminder.ID, `{"preset": "someday"}`, http.StatusBadRequest},
+		{"/todos/" + todo.ID + "/reminders/" + reminder.ID, `{"duration": "5m", "preset": "short"}`, http.StatusBadRequest},
+		{"/todos/" + todo.ID + "/reminders/" + reminder.ID, `{}`, http.StatusBadRequest},
+		{"/todos/" + todo.ID + "/reminders/" + reminder.ID, `{"duration":`, http.StatusBadRequest},
+		{"/todos/" + todo.ID + "/reminders/missing", `{"duration": "5m"}`, http.StatusNotFound},
+		{"/todos/" + other.ID + "/reminders/" + reminder.ID, `{"duration": "5m"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if status, resp := r.call(http.MethodPost, tt.path+"/snooze", tt.body); status != tt.want {
+			t.Errorf("snooze %v with %v returned %v %+v, want %v", tt.path, tt.body, status, resp, tt.want)
+		}
+	}
+	if status, resp := r.call(http.MethodPost, "/todos/"+other.ID+"/reminders/"+reminder.ID+"/ack", ""); status != http.StatusNotFound {
+		t.Errorf("ack of the reminder of another todo returned %v %+v, want 404", status, resp)
+	}
+	if got := r.reminder(todo.ID); got.State != model.ReminderPending || !got.ReminderTime.Equal(reminder.ReminderTime) {
+		t.Errorf("reminder is %v at %v after the rejected requests, want it unchanged", got.State, got.ReminderTime)
+	}
+	if got := r.history(todo.ID); got != "created" {
+		t.Errorf("history is %v, rejected requests must not be recorded", got)
+	}
+}
+
+func TestAddAndDeleteReminders(t *testing.T) {
+	r := newReminderTest(t)
+	due := r.now.Add(48 * time.Hour)
+	todo := r.addTodo(model.ToDo{Title: "report", DueAt: &due, TimeZone: "Europe/Berlin"})
+	path := "/todos/" + todo.ID + "/reminders"
+
+	status, resp := r.call(http.MethodPost, path, `{"at": "tomorrow 9am", "message": "{{.TaskName}} is due soon"}`)
+	if want := time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC); status != http.StatusCreated || !resp.Reminder.ReminderTime.Equal(want) ||
+		resp.Reminder.Channel != model.ChannelSSE || resp.Reminder.TimeZone != "Europe/Berlin" {
+		t.Fatalf("POST at tomorrow 9am returned %v %+v, want an sse reminder at %v", status, resp, want)
+	}
+	at := resp.Reminder
+	status, resp = r.call(http.MethodPost, path, `{"due_offset": "-30m", "channel": "log"}`)
+	if want := due.Add(-30 * time.Minute); status != http.StatusCreated || !resp.Reminder.ReminderTime.Equal(want) ||
+		resp.Reminder.Channel != model.ChannelLog || resp.Reminder.DueOffset != "-30m" {
+		t.Fatalf("POST due_offset -30m returned %v %+v, want a log reminder at %v", status, resp, want)
+	}
+	offset := resp.Reminder
+
+	status, resp = r.call(http.MethodGet, path, "")
+	if status != http.StatusOK || len(resp.Reminders) != 2 || resp.Reminders[0].ID != at.ID || resp.Reminders[1].ID != offset.ID {
+		t.Fatalf("GET reminders returned %v %+v, want both ordered by time", status, resp)
+	}
+
+	if status, resp := r.call(http.MethodDelete, path+"/"+at.ID, ""); status != http.StatusOK {
+		t.Fatalf("DELETE returned %v %+v", status, resp)
+	}
+	if status, resp := r.call(http.MethodDelete, path+"/"+at.ID, ""); status != http.StatusNotFound {
+		t.Errorf("second DELETE returned %v %+v, want 404", status, resp)
+	}
+	if got := r.reminder(todo.ID); got.ID != offset.ID {
+		t.Errorf("remaining reminder is %v, want %v", got.ID, offset.ID)
+	}
+	if got := r.history(todo.ID); got != "created,created,canceled" {
+		t.Errorf("history is %v, want created,created,canceled", got)
+	}
+}
+
+func TestAddReminderRejectsInvalidRequests(t *testing.T) {
+	r := newReminderTest(t)
+	todo := r.addTodo(model.ToDo{Title: "report"})
+	done := r.addTodo(model.ToDo{Title: "sent", Status: model.Done})
+
+	tests := []struct {
+		todoID string
+		body   string
+		want   int
+	}{
+		{todo.ID, `{}`, http.StatusBadRequest},
+		{todo.ID, `{"at": "tomorrow 9am", "due_offset": "-30m"}`, http.StatusBadRequest},
+		{todo.ID, `{"at": "someday"}`, http.StatusBadRequest},
+		{todo.ID, `{"at": "2024-02-29T09:00:00Z"}`, http.StatusBadRequest}, // in the past
+		{todo.ID, `{"due_offset": "-30m"}`, http.StatusBadRequest},         // no due date
+		{todo.ID, `{"at": "tomorrow 9am", "channel": "email"}`, http.StatusBadRequest},
+		{todo.ID, `{"at": "tomorrow 9am", "message": "{{.TaskName"}`, http.StatusBadRequest},
+		{todo.ID, `{"at":`, http.StatusBadRequest},
+		{done.ID, `{"at": "tomorrow 9am"}`, http.StatusBadRequest},
+		{"missing", `{"at": "tomorrow 9am"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if status, resp := r.call(http.MethodPost, "/todos/"+tt.todoID+"/reminders", tt.body); status != tt.want {
+			t.Errorf("POST %v returned %v %+v, want %v", tt.body, status, resp, tt.want)
+		}
+	}
+	if status, resp := r.call(http.MethodGet, "/todos/"+todo.ID+"/reminders", ""); status != http.StatusOK || len(resp.Reminders) != 0 {
+		t.Errorf("GET reminders returned %v %+v, want none after the rejected requests", status, resp)
+	}
+}
@@ -0,0 +1,291 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	"toDoList/internal/model"
+	"toDoList/internal/notify"
+	"toDoList/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait      = 10 * time.Second    // time allowed to write a message
+	wsPongWait       = 60 * time.Second    // a client that does not answer pings for this long is gone
+	wsPingPeriod     = wsPongWait * 9 / 10 // pings are sent before the pong wait runs out
+	wsMaxMessageSize = 64 << 10
+	wsSendBuffer     = 32 // replies a client may fall behind before it is disconnected
+	wsAllTodos       = "*"
+)
+
+// the default origin check only accepts pages served by this server
+var wsUpgrader = websocket.Upgrader{ReadBufferSize: 4096, WriteBufferSize: 4096}
+
+// wsRequest is a message sent by a WebSocket client
+type wsRequest struct {
+	ID   string `json:"id"`   // echoed in the reply, so the client can match them
+	Type string `json:"type"` // subscribe, unsubscribe, create, update or ack_reminder
+
+	Todos      []string    `json:"todos"`       // subscribe, unsubscribe: IDs of todos
+	Lists      []string    `json:"lists"`       // subscribe, unsubscribe: "*" for all todos or a status
+	TodoID     string      `json:"todo_id"`     // update, ack_reminder
+	ReminderID string      `json:"reminder_id"` // ack_reminder
+	Todo       *model.ToDo `json:"todo"`        // create, update
+}
+
+// wsReply answers a request, failed requests have the HTTP status code and body of the same REST request
+type wsReply struct {
+	Type     string          `json:"type"` // always "reply"
+	ID       string          `json:"id,omitempty"`
+	OK       bool            `json:"ok"`
+	Status   int             `json:"status,omitempty"`
+	Message  string          `json:"message,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Todo     *model.ToDo     `json:"todo,omitempty"`
+	Reminder *model.Reminder `json:"reminder,omitempty"`
+}
+
+// wsEvent is a notification the client subscribed to
+type wsEvent struct {
+	Type  string                 `json:"type"`         // always "event"
+	ID    int64                  `json:"id,omitempty"` // same as the SSE event ID
+	Event model.NotificationType `json:"event"`
+	Data  json.RawMessage        `json:"data"`
+}
+
+// wsSubscriptions are the todos and lists a client follows, changed by the reader and read by the writer
+type wsSubscriptions struct {
+	mu    sync.Mutex
+	todos map[string]bool
+	lists map[string]bool // wsAllTodos or a status
+}
+
+func (s *wsSubscriptions) change(req wsRequest, subscribe bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range req.Todos {
+		s.todos[id] = subscribe
+	}
+	for _, list := range req.Lists {
+		s.lists[list] = subscribe
+	}
+}
+
+// reports whether the client follows the todo of the notification. A status list gets the todos
+// that have the status after the change, deletions are sent to every list
+func (s *wsSubscriptions) matches(notification model.Notification) bool {
+	var payload struct {
+		ID     string       `json:"id"`
+		TodoID string       `json:"todo_id"`
+		Status model.Status `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(notification.Data), &payload); err != nil {
+		return false
+	}
+	todoID := payload.ID
+	if notification.Type == model.NotificationReminder {
+		todoID = payload.TodoID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.todos[todoID] || s.lists[wsAllTodos]:
+		return true
+	case notification.Type == model.NotificationTodoDeleted:
+		for _, subscribed := range s.lists {
+			if subscribed {
+				return true
+			}
+		}
+		return false
+	case notification.Type == model.NotificationReminder:
+		return false // reminders have no status
+	}
+	return s.lists[string(payload.Status)]
+}
+
+// wsClient is a WebSocket connection, only the writer goroutine writes to it
+type wsClient struct {
+	conn *websocket.Conn
+	send chan interface{} // replies for the writer
+	done chan struct{}    // closed when the reader stops
+	subs wsSubscriptions
+
+	todoService     service.TodoService
+	reminderService *service.ReminderService
+	commandTimeout  time.Duration
+}
+
+// WebSocket lets clients subscribe to todos and lists and send commands over one connection,
+// every request gets a reply with the same id
+func WebSocket(todoService service.TodoService, reminderService *service.ReminderService,
+	notifications *service.NotificationService, commandTimeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			return // the upgrader already replied with an error
+		}
+		client := &wsClient{
+			conn:            conn,
+			send:            make(chan interface{}, wsSendBuffer),
+			done:            make(chan struct{}),
+			subs:            wsSubscriptions{todos: make(map[string]bool), lists: make(map[string]bool)},
+			todoService:     todoService,
+			reminderService: reminderService,
+			commandTimeout:  commandTimeout,
+		}
+
+		sub := notifications.Subscribe()
+		defer sub.Close()
+		go client.writeLoop(sub)
+		client.readLoop(c.Request.Context())
+	}
+}
+
+// writes replies, subscribed notifications and pings until the reader stops or a write fails
+func (ws *wsClient) writeLoop(sub *notify.Subscription) {
+	ping := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ping.Stop()
+		ws.conn.Close() // stops the reader too
+	}()
+
+	for {
+		var msg interface{}
+		select {
+		case notification, ok := <-sub.C:
+			if !ok {
+				// evicted or the server stops
+				ws.conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "notifications stopped"), time.Now().Add(wsWriteWait))
+				return
+			}
+			if !ws.subs.matches(notification) {
+				continue
+			}
+			msg = wsEvent{Type: "event", ID: notification.ID, Event: notification.Type, Data: json.RawMessage(notification.Data)}
+		case msg = <-ws.send:
+		case <-ping.C:
+			if err := ws.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+			continue
+		case <-ws.done:
+			return
+		}
+
+		ws.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := ws.conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+// reads and handles requests one at a time until the client goes away
+func (ws *wsClient) readLoop(ctx context.Context) {
+	defer close(ws.done)
+
+	ws.conn.SetReadLimit(wsMaxMessageSize)
+	ws.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	ws.conn.SetPongHandler(func(string) error {
+		return ws.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := ws.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("WebSocket client %v disconnected: %v", ws.conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		var reply wsReply
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			reply = wsReply{Status: http.StatusBadRequest, Message: "Incorrect data", Error: err.Error()}
+		} else {
+			reply = ws.handle(ctx, req)
+			reply.ID = req.ID
+		}
+		reply.Type = "reply"
+		reply.OK = reply.Status == 0
+
+		select {
+		case ws.send <- reply:
+		default:
+			log.Printf("WebSocket client %v does not read its replies, disconnecting", ws.conn.RemoteAddr())
+			return
+		}
+	}
+}
+
+// runs the request with the same services as the REST endpoints, they publish the changes
+func (ws *wsClient) handle(ctx context.Context, req wsRequest) wsReply {
+	ctx, cancel := context.WithTimeout(ctx, ws.commandTimeout)
+	defer cancel()
+
+	switch req.Type {
+	case "subscribe", "unsubscribe":
+		if len(req.Todos) == 0 && len(req.Lists) == 0 {
+			return wsBadRequest("set todos or lists")
+		}
+		for _, list := range req.Lists {
+			if list != wsAllTodos && !model.IsValidStatus(model.Status(list)) {
+				return wsBadRequest(fmt.Sprintf("invalid list %q, use %q or a status", list, wsAllTodos))
+			}
+		}
+		ws.subs.change(req, req.Type == "subscribe")
+		return wsReply{}
+
+	case "create":
+		if req.Todo == nil {
+			return wsBadRequest("todo is required")
+		}
+		created, err := ws.todoService.AddTodo(ctx, *req.Todo)
+		if err != nil {
+			return wsError("Could not add todo", err)
+		}
+		created = created.Public()
+		return wsReply{Todo: &created}
+
+	case "update":
+		if req.Todo == nil || req.TodoID == "" {
+			return wsBadRequest("todo_id and todo are required")
+		}
+		// a stale version fails with 409, without a version the todo is updated unconditionally
+		updated, err := ws.todoService.UpdateTodo(ctx, req.TodoID, *req.Todo)
+		if err != nil {
+			return wsError("Could not update todo", err)
+		}
+		updated = updated.Public()
+		return wsReply{Todo: &updated}
+
+	case "ack_reminder":
+		if req.TodoID == "" || req.ReminderID == "" {
+			return wsBadRequest("todo_id and reminder_id are required")
+		}
+		reminder, err := ws.reminderService.Acknowledge(ctx, req.TodoID, req.ReminderID)
+		if err != nil {
+			return wsError("Could not acknowledge reminder", err)
+		}
+		return wsReply{Reminder: &reminder}
+	}
+	return wsBadRequest(fmt.Sprintf("unknown type %q", req.Type))
+}
+
+func wsBadRequest(msg string) wsReply {
+	return wsReply{Status: http.StatusBadRequest, Message: "Incorrect data", Error: msg}
+}
+
+func wsError(message string, err error) wsReply {
+	return wsReply{Status: statusFromError(err), Message: message, Error: err.Error()}
+}
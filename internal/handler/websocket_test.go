@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"toDoList/internal/events"
+	"toDoList/internal/model"
+	"toDoList/internal/notify"
+	"toDoList/internal/service"
+	"toDoList/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// wsMessage is a reply or an event read from the connection
+type wsMessage struct {
+	Type     string          `json:"type"`
+	ID       json.RawMessage `json:"id"` // a string in replies, a number in events
+	OK       bool            `json:"ok"`
+	Status   int             `json:"status"`
+	Error    string          `json:"error"`
+	Todo     *model.ToDo     `json:"todo"`
+	Reminder *model.Reminder `json:"reminder"`
+	Event    string          `json:"event"`
+	Data     model.ToDo      `json:"data"`
+}
+
+type wsTest struct {
+	t         *testing.T
+	conn      *websocket.Conn
+	reminders *service.ReminderService
+	events    []wsMessage // events read while waiting for replies
+}
+
+// starts the server with the services wired as in main, notifications follow the changes
+// synchronously, so an event is queued before the reply of the command that caused it
+func newWSTest(t *testing.T) *wsTest {
+	store := storage.NewMemoryDb()
+	notifications := service.NewNotificationService(store, notify.NewHub(notify.DefaultBufferSize), 100)
+	reminders := service.NewReminderService(store, make(chan model.ReminderNotification, 10), nil)
+	bus := events.NewBus()
+	bus.Subscribe("reminders", reminders.HandleTodoEvent, events.TodoCreated, events.TodoUpdated, events.TodoDeleted)
+	bus.Subscribe("notifications", notifications.HandleTodoEvent)
+	relay := service.NewOutboxRelay(store, bus, 10, time.Hour)
+	todos := service.NewTodoService(store, reminders, relay, time.UTC, time.Now)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ws", WebSocket(todos, reminders, notifications, 5*time.Second))
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &wsTest{t: t, conn: conn, reminders: reminders}
+}
+
+// sends the request and returns its reply, the events read meanwhile are kept
+func (w *wsTest) request(req string) wsMessage {
+	w.t.Helper()
+	if err := w.conn.WriteMessage(websocket.TextMessage, []byte(req)); err != nil {
+		w.t.Fatalf("could not send %v: %v", req, err)
+	}
+	var sent struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal([]byte(req), &sent)
+
+	for {
+		msg := w.read()
+		if msg.Type == "event" {
+			w.events = append(w.events, msg)
+			continue
+		}
+		var id string
+		json.Unmarshal(msg.ID, &id)
+		if msg.Type != "reply" || id != sent.ID {
+			w.t.Fatalf("got %+v, want the reply to %v", msg, req)
+		}
+		return msg
+	}
+}
+
+func (w *wsTest) read() wsMessage {
+	w.t.Helper()
+	w.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg wsMessage
+	if err := w.conn.ReadJSON(&msg); err != nil {
+		w.t.Fatalf("could not read a message: %v", err)
+	}
+	return msg
+}
+
+// returns the next event, read before a reply or from the connection
+func (w *wsTest) nextEvent() wsMessage {
+	w.t.Helper()
+	if len(w.events) > 0 {
+		event := w.events[0]
+		w.events = w.events[1:]
+		return event
+	}
+	msg := w.read()
+	if msg.Type != "event" {
+		w.t.Fatalf("got %+v, want an event", msg)
+	}
+	return msg
+}
+
+func expectOK(t *testing.T, reply wsMessage) {
+	t.Helper()
+	if !reply.OK || reply.Status != 0 {
+		t.Fatalf("got reply %+v, want ok", reply)
+	}
+}
+
+func TestWebSocketSubscriptions(t *testing.T) {
+	w := newWSTest(t)
+	expectOK(t, w.request(`{"id": "1", "type": "subscribe", "todos": ["t1"]}`))
+
+	// t2 is not followed, its event is filtered out before the one of t1
+	expectOK(t, w.request(`{"id": "2", "type": "create", "todo": {"id": "t2", "title": "other", "status": "created"}}`))
+	reply := w.request(`{"id": "3", "type": "create", "todo": {"id": "t1", "title": "followed", "status": "created"}}`)
+	expectOK(t, reply)
+	if reply.Todo == nil || reply.Todo.ID != "t1" || reply.Todo.Version != 1 {
+		t.Fatalf("create replied with todo %+v", reply.Todo)
+	}
+	if event := w.nextEvent(); event.Event != string(model.NotificationTodoCreated) || event.Data.ID != "t1" {
+		t.Fatalf("got event %+v, want todo.created of t1", event)
+	}
+
+	// a status list gets the todos that have the status after the change
+	expectOK(t, w.request(`{"id": "4", "type": "subscribe", "lists": ["done"]}`))
+	reply = w.request(`{"id": "5", "type": "update", "todo_id": "t2", "todo": {"title": "other", "status": "done", "version": 1}}`)
+	expectOK(t, reply)
+	if reply.Todo == nil || reply.Todo.Status != model.Done || reply.Todo.Version != 2 {
+		t.Fatalf("update replied with todo %+v", reply.Todo)
+	}
+	if event := w.nextEvent(); event.Event != string(model.NotificationTodoUpdated) || event.Data.ID != "t2" ||
+		event.Data.Status != model.Done {
+		t.Fatalf("got event %+v, want todo.updated of t2", event)
+	}
+
+	// after unsubscribing only the list is followed
+	expectOK(t, w.request(`{"id": "6", "type": "unsubscribe", "todos": ["t1"]}`))
+	expectOK(t, w.request(`{"id": "7", "type": "update", "todo_id": "t1", "todo": {"title": "renamed", "status": "in progress"}}`))
+	expectOK(t, w.request(`{"id": "8", "type": "update", "todo_id": "t1", "todo": {"title": "renamed", "status": "done"}}`))
+	if event := w.nextEvent(); event.Data.ID != "t1" || event.Data.Status != model.Done || event.Data.Version != 3 {
+		t.Fatalf("got event %+v, want todo.updated of t1 in the done list", event)
+	}
+}
+
+func TestWebSocketAckReminder(t *testing.T) {
+	w := newWSTest(t)
+	expectOK(t, w.request(`{"id": "1", "type": "create", "todo": {"id": "t1", "title": "a", "status": "created", "reminder_time": "1h"}}`))
+
+	// the reminder was added when todo.created was published
+	reminders, err := w.reminders.TodoReminders(context.Background(), "t1")
+	if err != nil || len(reminders) != 1 {
+		t.Fatalf("TodoReminders returned %v, %v, want the reminder of the todo", reminders, err)
+	}
+	reply := w.request(`{"id": "ack", "type": "ack_reminder", "todo_id": "t1", "reminder_id": "` + reminders[0].ID + `"}`)
+	expectOK(t, reply)
+	if reply.Reminder == nil || reply.Reminder.ID != reminders[0].ID || reply.Reminder.State != model.ReminderAcknowledged {
+		t.Fatalf("ack_reminder replied with reminder %+v", reply.Reminder)
+	}
+
+	reply = w.request(`{"id": "missing", "type": "ack_reminder", "todo_id": "t1", "reminder_id": "nope"}`)
+	if reply.OK || reply.Status != 404 {
+		t.Errorf("ack_reminder of a missing reminder replied %+v, want 404", reply)
+	}
+}
+
+func TestWebSocketBadRequests(t *testing.T) {
+	w := newWSTest(t)
+	for _, req := range []string{
+		`{"id": "1", "type": "subscribe"}`,
+		`{"id": "2", "type": "subscribe", "lists": ["someday"]}`,
+		`{"id": "3", "type": "create"}`,
+		`{"id": "4", "type": "create", "todo": {"title": "a", "status": "someday"}}`,
+		`{"id": "5", "type": "update", "todo": {"title": "a", "status": "done"}}`,
+		`{"id": "6", "type": "ack_reminder", "todo_id": "t1"}`,
+		`{"id": "7", "type": "delete"}`,
+	} {
+		reply := w.request(req)
+		if reply.OK || reply.Status != 400 || reply.Error == "" {
+			t.Errorf("%v replied %+v, want ok false with 400", req, reply)
+		}
+	}
+
+	// failures have the status of the same REST request
+	expectOK(t, w.request(`{"id": "8", "type": "create", "todo": {"id": "t1", "title": "a", "status": "created"}}`))
+	if reply := w.request(`{"id": "9", "type": "update", "todo_id": "t1", "todo": {"title": "b", "status": "done", "version": 7}}`); reply.OK || reply.Status != 409 {
+		t.Errorf("update with a stale version replied %+v, want 409", reply)
+	}
+	if reply := w.request(`{"id": "10", "type": "update", "todo_id": "nope", "todo": {"title": "b", "status": "done"}}`); reply.OK || reply.Status != 404 {
+		t.Errorf("update of a missing todo replied %+v, want 404", reply)
+	}
+
+	// a message that is not JSON gets a reply without an id
+	if err := w.conn.WriteMessage(websocket.TextMessage, []byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+	if reply := w.read(); reply.Type != "reply" || reply.OK || reply.Status != 400 || len(reply.ID) != 0 {
+		t.Errorf("invalid JSON replied %+v, want ok false with 400", reply)
+	}
+}
@@ -1,11 +1,64 @@
 package model
 
+import (
+	"path/filepath"
+	"time"
+)
+
 type ToDo struct {
 	ID           string `json:"id,omitempty" bson:"_id,omitempty"`
 	Title        string `json:"title" bson:"title"`
 	Status       Status `json:"status" bson:"status"`
 	ImagePath    string `json:"image_path,omitempty" bson:"image_path,omitempty"`
-	ReminderTime string `json:"reminder_time,omitempty" bson:"reminder_time,omitempty"`
+	ReminderTime string `json:"reminder_time,omitempty" bson:"reminder_time,omitempty"` // as sent by the client
+	TimeZone     string `json:"time_zone,omitempty" bson:"time_zone,omitempty"`         // IANA name, e.g. Europe/Berlin
+
+	// the instant ReminderTime resolves to, set by the service when the todo is added
+	ReminderAt *time.Time `json:"reminder_at,omitempty" bson:"reminder_at,omitempty"`
+	// reminders with a due offset follow it when it changes
+	DueAt *time.Time `json:"due_at,omitempty" bson:"due_at,omitempty"`
+
+	// managed by the storage, values sent by clients are ignored,
+	// except Version in updates: a stale version makes the update fail
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+	Version   int64     `json:"version" bson:"version"`
+}
+
+// Public returns the todo as clients see it, with the image URL instead of the file path
+// and the reminder time and due date in the time zone of the todo
+func (t ToDo) Public() ToDo {
+	if t.ImagePath != "" {
+		t.ImagePath = "/images/" + filepath.Base(t.ImagePath)
+	}
+	if t.TimeZone != "" {
+		if loc, err := time.LoadLocation(t.TimeZone); err == nil {
+			t.ReminderAt = inLocation(t.ReminderAt, loc)
+			t.DueAt = inLocation(t.DueAt, loc)
+		}
+	}
+	return t
+}
+
+// returns a copy of the time in loc, nil stays nil
+func inLocation(t *time.Time, loc *time.Location) *time.Time {
+	if t == nil {
+		return nil
+	}
+	local := t.In(loc)
+	return &local
+}
+
+// ToDoPatch holds the fields changed by a partial update, nil fields stay as they are
+type ToDoPatch struct {
+	Title  *string
+	Status *Status
+	DueAt  **time.Time // points to nil to clear the due date
+}
+
+// IsEmpty checks, if the patch changes nothing
+func (p ToDoPatch) IsEmpty() bool {
+	return p.Title == nil && p.Status == nil && p.DueAt == nil
 }
 
 // IsValidStatus checks, if status is valid
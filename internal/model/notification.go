@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// NotificationType is the SSE event name of a notification
+type NotificationType string
+
+const (
+	NotificationReminder    NotificationType = "reminder"
+	NotificationTodoCreated NotificationType = "todo.created"
+	NotificationTodoUpdated NotificationType = "todo.updated"
+	NotificationTodoDeleted NotificationType = "todo.deleted"
+)
+
+// Notification is an event sent to clients of GET /notifications and kept in the notification log,
+// so clients that reconnect get the ones they missed
+type Notification struct {
+	ID        int64            `json:"id" bson:"_id"` // grows with every notification, the SSE event ID
+	Type      NotificationType `json:"type" bson:"type"`
+	Data      string           `json:"data" bson:"data"` // JSON payload
+	CreatedAt time.Time        `json:"created_at" bson:"created_at"`
+}
+
+// ReminderNotification is the payload of a reminder notification
+type ReminderNotification struct {
+	ReminderID string `json:"reminder_id"`
+	TodoID     string `json:"todo_id"`
+	Message    string `json:"message"`
+}
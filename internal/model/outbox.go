@@ -0,0 +1,58 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OutboxStatus is a step of publishing an outbox event: pending → published, or dead after too many failures
+type OutboxStatus string
+
+const (
+	OutboxPending   OutboxStatus = "pending"   // waiting for the relay, NextAttemptAt tells when
+	OutboxPublished OutboxStatus = "published" // every sync subscriber handled it
+	OutboxDead      OutboxStatus = "dead"      // failed too often, it is published again only when requeued
+)
+
+// IsValidOutboxStatus checks, if status is valid
+func IsValidOutboxStatus(status OutboxStatus) bool {
+	switch status {
+	case OutboxPending, OutboxPublished, OutboxDead:
+		return true
+	}
+	return false
+}
+
+// OutboxEvent is a change of a todo, the storage writes it in the same transaction as the change,
+// so a stored change is always published, even if the server stops right after it
+type OutboxEvent struct {
+	ID        string `json:"id" bson:"_id"`
+	Type      string `json:"type" bson:"type"` // an events.Type
+	TodoID    string `json:"todo_id" bson:"todo_id"`
+	Todo      string `json:"todo" bson:"todo"` // JSON of the todo after the change, only the ID for todo.deleted
+	OldStatus Status `json:"old_status,omitempty" bson:"old_status,omitempty"`
+
+	Status        OutboxStatus `json:"status" bson:"status"`
+	Attempts      int          `json:"attempts" bson:"attempts"` // failed attempts to publish it
+	LastError     string       `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	Delivered     int          `json:"delivered,omitempty" bson:"delivered,omitempty"` // bus events of it every subscriber got, a retry skips them
+	Dropped       int          `json:"dropped,omitempty" bson:"dropped,omitempty"`     // async subscribers that dropped it, over all attempts
+	CreatedAt     time.Time    `json:"created_at" bson:"created_at"`
+	NextAttemptAt time.Time    `json:"next_attempt_at" bson:"next_attempt_at"`
+}
+
+// NewOutboxEvent returns a pending event of the change, the storage sets ID and times
+func NewOutboxEvent(eventType string, todo ToDo, oldStatus Status) (OutboxEvent, error) {
+	data, err := json.Marshal(todo)
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	return OutboxEvent{Type: eventType, TodoID: todo.ID, Todo: string(data), OldStatus: oldStatus, Status: OutboxPending}, nil
+}
+
+// DecodeTodo returns the todo after the change
+func (e OutboxEvent) DecodeTodo() (ToDo, error) {
+	var todo ToDo
+	err := json.Unmarshal([]byte(e.Todo), &todo)
+	return todo, err
+}
@@ -0,0 +1,35 @@
+package model
+
+// Fields todos can be sorted by
+const (
+	SortByID        = "id"
+	SortByTitle     = "title"
+	SortByStatus    = "status"
+	SortByCreatedAt = "created_at"
+	SortByUpdatedAt = "updated_at"
+)
+
+// ListOptions describes which page of todos to return
+type ListOptions struct {
+	Status   Status // only todos with this status, empty for all
+	Title    string // case-insensitive part of the title, empty for all
+	SortBy   string // one of the SortBy constants, id by default
+	SortDesc bool
+	Limit    int    // page size, the storage default is used when zero
+	Cursor   string // NextCursor of the previous page, empty for the first page
+}
+
+// TodoPage is one page of todos, NextCursor is empty on the last page
+type TodoPage struct {
+	Todos      []ToDo `json:"todos"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// IsValidSortField checks, if todos can be sorted by the field
+func IsValidSortField(field string) bool {
+	switch field {
+	case SortByID, SortByTitle, SortByStatus, SortByCreatedAt, SortByUpdatedAt:
+		return true
+	}
+	return false
+}
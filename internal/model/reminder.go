@@ -0,0 +1,81 @@
+package model
+
+import "time"
+
+// ReminderState is a step of the reminder delivery: pending → delivered → acknowledged
+type ReminderState string
+
+const (
+	ReminderPending      ReminderState = "pending"      // waiting for ReminderTime
+	ReminderDelivered    ReminderState = "delivered"    // the notification was sent, it is not sent again
+	ReminderAcknowledged ReminderState = "acknowledged" // the user has seen the notification
+)
+
+// Reminder notifies about a todo at ReminderTime
+type Reminder struct {
+	ID           string        `json:"id" bson:"_id"`
+	TodoID       string        `json:"todo_id" bson:"todo_id"`
+	TaskName     string        `json:"task_name" bson:"task_name"`
+	ReminderTime time.Time     `json:"reminder_time" bson:"reminder_time"`
+	State        ReminderState `json:"state" bson:"state"`
+
+	// a recurring reminder stays pending and moves to the next occurrence of Recurrence
+	// (e.g. "every weekday at 08:00", read in TimeZone) after every delivery
+	Recurrence string `json:"recurrence,omitempty" bson:"recurrence,omitempty"`
+	TimeZone   string `json:"time_zone,omitempty" bson:"time_zone,omitempty"`
+
+	// a reminder with a due offset (e.g. -30m) fires that long after the due date of the todo
+	// and moves with it
+	DueOffset string          `json:"due_offset,omitempty" bson:"due_offset,omitempty"`
+	Channel   ReminderChannel `json:"channel" bson:"channel"`
+	// text/template executed with the Reminder, e.g. "{{.TaskName}} is due soon"
+	Message string `json:"message,omitempty" bson:"message,omitempty"`
+}
+
+// ReminderChannel is where a reminder is delivered
+type ReminderChannel string
+
+const (
+	ChannelSSE ReminderChannel = "sse" // GET /notifications
+	ChannelLog ReminderChannel = "log" // the server log, for setups without clients listening
+)
+
+// IsValidChannel checks, if channel is valid
+func IsValidChannel(channel ReminderChannel) bool {
+	switch channel {
+	case ChannelSSE, ChannelLog:
+		return true
+	}
+	return false
+}
+
+// ReminderSpec describes a reminder added to a todo, either At or DueOffset must be set
+type ReminderSpec struct {
+	At        string          `json:"at"`         // a time like "tomorrow 9am", read in the time zone of the todo
+	DueOffset string          `json:"due_offset"` // a duration like -30m from the due date of the todo
+	Channel   ReminderChannel `json:"channel"`    // sse if empty
+	Message   string          `json:"message"`    // a template, see Reminder.Message
+}
+
+// ReminderEventType is a transition of a reminder
+type ReminderEventType string
+
+const (
+	ReminderEventCreated      ReminderEventType = "created"
+	ReminderEventDelivered    ReminderEventType = "delivered"
+	ReminderEventSnoozed      ReminderEventType = "snoozed"
+	ReminderEventRescheduled  ReminderEventType = "rescheduled"
+	ReminderEventAcknowledged ReminderEventType = "acknowledged"
+	ReminderEventCanceled     ReminderEventType = "canceled"
+)
+
+// ReminderEvent records a transition of a reminder, events make up the reminder history of a todo
+type ReminderEvent struct {
+	ID           string            `json:"id" bson:"_id"`
+	ReminderID   string            `json:"reminder_id" bson:"reminder_id"`
+	TodoID       string            `json:"todo_id" bson:"todo_id"`
+	Type         ReminderEventType `json:"type" bson:"type"`
+	State        ReminderState     `json:"state,omitempty" bson:"state,omitempty"` // after the event, empty if the reminder is canceled
+	ReminderTime time.Time         `json:"reminder_time" bson:"reminder_time"`     // when the reminder fires after the event
+	OccurredAt   time.Time         `json:"occurred_at" bson:"occurred_at"`
+}
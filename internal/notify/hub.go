@@ -0,0 +1,113 @@
+// Package notify fans notifications out to every connected client.
+package notify
+
+import (
+	"expvar"
+	"log"
+	"sync"
+	"toDoList/internal/model"
+)
+
+// DefaultBufferSize is how many notifications a subscriber may fall behind before it is evicted
+const DefaultBufferSize = 32
+
+var (
+	subscriberCount = expvar.NewInt("notify_subscribers") // connected subscribers
+	evictedCount    = expvar.NewInt("notify_evicted")     // subscribers evicted for reading too slowly
+)
+
+// Hub broadcasts every published notification to all subscribers. Publish never blocks:
+// a subscriber whose buffer is full is evicted, its channel is closed, so the client
+// reconnects instead of silently missing notifications
+type Hub struct {
+	bufferSize int
+
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// Subscription receives the notifications published after Subscribe
+type Subscription struct {
+	// C is closed when the subscription is closed, evicted or the hub is closed
+	C <-chan model.Notification
+
+	ch   chan model.Notification
+	hub  *Hub
+	once sync.Once
+}
+
+// NewHub creates a hub, every subscriber gets a buffer of bufferSize notifications
+func NewHub(bufferSize int) *Hub {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	return &Hub{bufferSize: bufferSize, subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscribe registers a new subscriber, it must be closed when the client is gone.
+// The channel of a subscription to a closed hub is closed right away
+func (h *Hub) Subscribe() *Subscription {
+	ch := make(chan model.Notification, h.bufferSize)
+	sub := &Subscription{C: ch, ch: ch, hub: h}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		sub.once.Do(func() { close(ch) })
+		return sub
+	}
+	h.subscribers[sub] = struct{}{}
+	subscriberCount.Add(1)
+	return sub
+}
+
+// Close unsubscribes, it can be called more than once
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// drops the subscriber and closes its channel, h.mu must be held
+func (h *Hub) remove(sub *Subscription) {
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		subscriberCount.Add(-1)
+	}
+	sub.once.Do(func() { close(sub.ch) })
+}
+
+// Publish sends the notification to every subscriber and evicts the ones that fell behind
+func (h *Hub) Publish(notification model.Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subscribers {
+		select {
+		case sub.ch <- notification:
+		default:
+			h.remove(sub)
+			evictedCount.Add(1)
+			log.Printf("Evicted a notification subscriber that fell %d notifications behind", h.bufferSize)
+		}
+	}
+}
+
+// Len returns the number of subscribers
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers)
+}
+
+// Close closes all subscriptions, so their streams end, and rejects new ones
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subscribers {
+		h.remove(sub)
+	}
+}
@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"testing"
+	"toDoList/internal/model"
+)
+
+func notification(id int64) model.Notification {
+	return model.Notification{ID: id, Type: model.NotificationTodoCreated, Data: "{}"}
+}
+
+// reads the buffered notifications without blocking and reports whether the channel is closed
+func received(sub *Subscription) (ids []int64, closed bool) {
+	for {
+		select {
+		case n, ok := <-sub.C:
+			if !ok {
+				return ids, true
+			}
+			ids = append(ids, n.ID)
+		default:
+			return ids, false
+		}
+	}
+}
+
+func expectIDs(t *testing.T, what string, got []int64, want ...int64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s received %v, want %v", what, got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("%s received %v, want %v", what, got, want)
+		}
+	}
+}
+
+func TestHubFansOutToEverySubscriber(t *testing.T) {
+	hub := NewHub(8)
+	first, second := hub.Subscribe(), hub.Subscribe()
+	defer first.Close()
+	defer second.Close()
+	if hub.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", hub.Len())
+	}
+
+	for id := int64(1); id <= 3; id++ {
+		hub.Publish(notification(id))
+	}
+	for name, sub := range map[string]*Subscription{"first": first, "second": second} {
+		ids, closed := received(sub)
+		if closed {
+			t.Errorf("%s subscription was closed", name)
+		}
+		expectIDs(t, name+" subscriber", ids, 1, 2, 3)
+	}
+
+	// a subscriber only gets what is published after it subscribed
+	late := hub.Subscribe()
+	defer late.Close()
+	hub.Publish(notification(4))
+	ids, _ := received(late)
+	expectIDs(t, "late subscriber", ids, 4)
+}
+
+func TestHubEvictsOnlySlowSubscriber(t *testing.T) {
+	hub := NewHub(2)
+	slow, fast := hub.Subscribe(), hub.Subscribe()
+	defer fast.Close()
+
+	for id := int64(1); id <= 5; id++ {
+		hub.Publish(notification(id))
+		// the fast subscriber keeps up, the slow one never reads
+		ids, closed := received(fast)
+		if closed {
+			t.Fatalf("fast subscriber was evicted after %d", id)
+		}
+		expectIDs(t, "fast subscriber", ids, id)
+	}
+
+	// the slow subscriber keeps what fit into its buffer, then its channel is closed
+	ids, closed := received(slow)
+	expectIDs(t, "slow subscriber", ids, 1, 2)
+	if !closed {
+		t.Error("channel of the evicted subscriber is not closed")
+	}
+	if hub.Len() != 1 {
+		t.Errorf("Len after the eviction = %d, want 1", hub.Len())
+	}
+	slow.Close() // closing an evicted subscription is fine
+}
+
+func TestHubSubscribeAfterClose(t *testing.T) {
+	hub := NewHub(2)
+	open := hub.Subscribe()
+	hub.Close()
+
+	if _, closed := received(open); !closed {
+		t.Error("Close did not close the channel of a subscriber")
+	}
+	sub := hub.Subscribe()
+	if _, closed := received(sub); !closed {
+		t.Error("Subscribe after Close returned an open channel")
+	}
+	if hub.Len() != 0 {
+		t.Errorf("Len after Close = %d, want 0", hub.Len())
+	}
+	hub.Publish(notification(1)) // nobody to send to
+	sub.Close()
+	open.Close()
+}
+
+func TestSubscriptionCloseIsIdempotent(t *testing.T) {
+	hub := NewHub(2)
+	sub := hub.Subscribe()
+	other := hub.Subscribe()
+	defer other.Close()
+
+	sub.Close()
+	sub.Close()
+	if _, closed := received(sub); !closed {
+		t.Error("Close did not close the channel")
+	}
+	if hub.Len() != 1 {
+		t.Errorf("Len after closing a subscription twice = %d, want 1", hub.Len())
+	}
+
+	// the other subscriber still gets notifications
+	hub.Publish(notification(1))
+	ids, _ := received(other)
+	expectIDs(t, "other subscriber", ids, 1)
+}
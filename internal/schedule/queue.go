@@ -0,0 +1,126 @@
+// Package schedule keeps pending reminders ordered by their due time.
+package schedule
+
+import (
+	"container/heap"
+	"time"
+	"toDoList/internal/model"
+)
+
+// Queue is a min-heap of reminders keyed by ReminderTime, with an index by reminder ID.
+// Push, Remove, Reschedule and Pop take O(log n), Next takes O(1).
+// Queue is not safe for concurrent use.
+type Queue struct {
+	items reminderHeap
+	index map[string]*item
+}
+
+type item struct {
+	reminder model.Reminder
+	position int // index in the heap, maintained by reminderHeap
+}
+
+// NewQueue creates an empty queue
+func NewQueue() *Queue {
+	return &Queue{index: make(map[string]*item)}
+}
+
+// Len returns the number of queued reminders
+func (q *Queue) Len() int {
+	return len(q.items)
+}
+
+// Push adds the reminder, a reminder with the same ID is replaced
+func (q *Queue) Push(reminder model.Reminder) {
+	if it, ok := q.index[reminder.ID]; ok {
+		it.reminder = reminder
+		heap.Fix(&q.items, it.position)
+		return
+	}
+	it := &item{reminder: reminder}
+	heap.Push(&q.items, it)
+	q.index[reminder.ID] = it
+}
+
+// Get returns the queued reminder with the ID
+func (q *Queue) Get(id string) (model.Reminder, bool) {
+	it, ok := q.index[id]
+	if !ok {
+		return model.Reminder{}, false
+	}
+	return it.reminder, true
+}
+
+// Remove drops the reminder with the ID and reports whether it was queued
+func (q *Queue) Remove(id string) bool {
+	it, ok := q.index[id]
+	if !ok {
+		return false
+	}
+	heap.Remove(&q.items, it.position)
+	delete(q.index, id)
+	return true
+}
+
+// Reschedule moves the reminder with the ID to another time and reports whether it was queued
+func (q *Queue) Reschedule(id string, at time.Time) bool {
+	it, ok := q.index[id]
+	if !ok {
+		return false
+	}
+	it.reminder.ReminderTime = at
+	heap.Fix(&q.items, it.position)
+	return true
+}
+
+// Next returns the earliest reminder without removing it
+func (q *Queue) Next() (model.Reminder, bool) {
+	if len(q.items) == 0 {
+		return model.Reminder{}, false
+	}
+	return q.items[0].reminder, true
+}
+
+// Pop removes and returns the earliest reminder
+func (q *Queue) Pop() (model.Reminder, bool) {
+	if len(q.items) == 0 {
+		return model.Reminder{}, false
+	}
+	it := heap.Pop(&q.items).(*item)
+	delete(q.index, it.reminder.ID)
+	return it.reminder, true
+}
+
+// reminderHeap implements heap.Interface, reminders due at the same time are ordered by ID
+type reminderHeap []*item
+
+func (h reminderHeap) Len() int { return len(h) }
+
+func (h reminderHeap) Less(i, j int) bool {
+	a, b := h[i].reminder, h[j].reminder
+	if !a.ReminderTime.Equal(b.ReminderTime) {
+		return a.ReminderTime.Before(b.ReminderTime)
+	}
+	return a.ID < b.ID
+}
+
+func (h reminderHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].position = i
+	h[j].position = j
+}
+
+func (h *reminderHeap) Push(x interface{}) {
+	it := x.(*item)
+	it.position = len(*h)
+	*h = append(*h, it)
+}
+
+func (h *reminderHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil // let the item be collected
+	*h = old[:n-1]
+	return it
+}
@@ -0,0 +1,286 @@
+package schedule
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+	"toDoList/internal/model"
+)
+
+var base = time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+func reminderAt(id string, minutes int) model.Reminder {
+	return model.Reminder{ID: id, TaskName: "task " + id, ReminderTime: base.Add(time.Duration(minutes) * time.Minute)}
+}
+
+// pops every reminder and returns their IDs
+func drain(q *Queue) []string {
+	var ids []string
+	for {
+		reminder, ok := q.Pop()
+		if !ok {
+			return ids
+		}
+		ids = append(ids, reminder.ID)
+	}
+}
+
+func expectOrder(t *testing.T, q *Queue, want ...string) {
+	t.Helper()
+	got := drain(q)
+	if len(got) != len(want) {
+		t.Fatalf("popped %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("popped %v, want %v", got, want)
+		}
+	}
+	if q.Len() != 0 {
+		t.Errorf("Len after popping every reminder is %d", q.Len())
+	}
+}
+
+func TestQueuePopOrder(t *testing.T) {
+	q := NewQueue()
+	if _, ok := q.Pop(); ok {
+		t.Fatal("Pop of an empty queue returned a reminder")
+	}
+	if _, ok := q.Next(); ok {
+		t.Fatal("Next of an empty queue returned a reminder")
+	}
+
+	for _, r := range []model.Reminder{reminderAt("c", 30), reminderAt("a", 10), reminderAt("e", 50),
+		reminderAt("b", 10), reminderAt("d", 20)} {
+		q.Push(r)
+	}
+	if q.Len() != 5 {
+		t.Fatalf("Len = %d, want 5", q.Len())
+	}
+	if next, ok := q.Next(); !ok || next.ID != "a" || q.Len() != 5 {
+		t.Fatalf("Next returned %v, %v, want a without removing it", next.ID, ok)
+	}
+	// reminders due at the same time are ordered by ID
+	expectOrder(t, q, "a", "b", "d", "c", "e")
+}
+
+func TestQueuePushExisting(t *testing.T) {
+	q := NewQueue()
+	q.Push(reminderAt("a", 10))
+	q.Push(reminderAt("b", 20))
+
+	// the reminder is replaced, not added twice
+	moved := reminderAt("a", 30)
+	moved.TaskName = "renamed"
+	q.Push(moved)
+	if q.Len() != 2 {
+		t.Fatalf("Len after pushing an existing ID = %d, want 2", q.Len())
+	}
+	if got, ok := q.Get("a"); !ok || got.TaskName != "renamed" || !got.ReminderTime.Equal(moved.ReminderTime) {
+		t.Errorf("Get returned %+v, %v, want %+v", got, ok, moved)
+	}
+	expectOrder(t, q, "b", "a")
+}
+
+func TestQueueRemove(t *testing.T) {
+	q := NewQueue()
+	for i, id := range []string{"a", "b", "c", "d"} {
+		q.Push(reminderAt(id, i))
+	}
+	if !q.Remove("c") {
+		t.Error("Remove of a queued reminder returned false")
+	}
+	if !q.Remove("a") {
+		t.Error("Remove of the earliest reminder returned false")
+	}
+	if q.Remove("a") || q.Remove("missing") {
+		t.Error("Remove of a reminder that is not queued returned true")
+	}
+	if _, ok := q.Get("c"); ok {
+		t.Error("Get returned a removed reminder")
+	}
+	expectOrder(t, q, "b", "d")
+}
+
+func TestQueueReschedule(t *testing.T) {
+	q := NewQueue()
+	for i, id := range []string{"a", "b", "c"} {
+		q.Push(reminderAt(id, i*10))
+	}
+	if !q.Reschedule("a", base.Add(time.Hour)) {
+		t.Error("Reschedule of a queued reminder returned false")
+	}
+	if !q.Reschedule("c", base.Add(-time.Hour)) {
+		t.Error("Reschedule of a queued reminder returned false")
+	}
+	if q.Reschedule("missing", base) {
+		t.Error("Reschedule of a reminder that is not queued returned true")
+	}
+	if q.Len() != 3 {
+		t.Errorf("Len after Reschedule = %d, want 3", q.Len())
+	}
+	if _, ok := q.Get("missing"); ok {
+		t.Error("Reschedule of a missing ID added it")
+	}
+	expectOrder(t, q, "c", "b", "a")
+}
+
+func TestQueueRandomOrder(t *testing.T) {
+	q := NewQueue()
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		id := strconv.Itoa(i)
+		q.Push(reminderAt(id, rnd.Intn(500)))
+		switch {
+		case i%7 == 0:
+			q.Remove(strconv.Itoa(rnd.Intn(i + 1)))
+		case i%5 == 0:
+			q.Reschedule(strconv.Itoa(rnd.Intn(i+1)), base.Add(time.Duration(rnd.Intn(500))*time.Minute))
+		}
+	}
+
+	var last model.Reminder
+	for i := 0; q.Len() > 0; i++ {
+		reminder, _ := q.Pop()
+		if i > 0 && (reminder.ReminderTime.Before(last.ReminderTime) ||
+			reminder.ReminderTime.Equal(last.ReminderTime) && reminder.ID < last.ID) {
+			t.Fatalf("popped %v at %v after %v at %v", reminder.ID, reminder.ReminderTime, last.ID, last.ReminderTime)
+		}
+		last = reminder
+	}
+}
+
+// sliceScheduler is the previous implementation of ReminderService: pending reminders
+// in a slice, scanned every second
+type sliceScheduler struct {
+	reminders []model.Reminder
+}
+
+func (s *sliceScheduler) add(reminder model.Reminder) {
+	s.reminders = append(s.reminders, reminder)
+}
+
+func (s *sliceScheduler) cancel(id string) {
+	for i, reminder := range s.reminders {
+		if reminder.ID == id {
+			s.reminders = append(s.reminders[:i], s.reminders[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *sliceScheduler) reschedule(id string, at time.Time) {
+	for i := range s.reminders {
+		if s.reminders[i].ID == id {
+			s.reminders[i].ReminderTime = at
+			return
+		}
+	}
+}
+
+func (s *sliceScheduler) due(now time.Time) int {
+	due := 0
+	for _, reminder := range s.reminders {
+		if now.After(reminder.ReminderTime) {
+			due++
+		}
+	}
+	return due
+}
+
+// heapScheduler wraps Queue the same way ReminderService uses it
+type heapScheduler struct {
+	queue *Queue
+}
+
+func (h *heapScheduler) add(reminder model.Reminder) { h.queue.Push(reminder) }
+
+func (h *heapScheduler) cancel(id string) { h.queue.Remove(id) }
+
+func (h *heapScheduler) reschedule(id string, at time.Time) { h.queue.Reschedule(id, at) }
+
+func (h *heapScheduler) due(now time.Time) int {
+	// only the earliest reminder is checked when nothing is due
+	if next, ok := h.queue.Next(); ok && now.After(next.ReminderTime) {
+		return 1
+	}
+	return 0
+}
+
+type scheduler interface {
+	add(reminder model.Reminder)
+	cancel(id string)
+	reschedule(id string, at time.Time)
+	due(now time.Time) int
+}
+
+// numbers of pending reminders every benchmark runs with
+var benchmarkSizes = []int{1000, 100000, 500000}
+
+// returns n reminders due within the next day, in random order
+func newReminders(n int) []model.Reminder {
+	rnd := rand.New(rand.NewSource(1))
+	start := time.Now().Add(time.Hour)
+	reminders := make([]model.Reminder, n)
+	for i := range reminders {
+		reminders[i] = model.Reminder{
+			ID:           strconv.Itoa(i),
+			TodoID:       strconv.Itoa(i),
+			TaskName:     "task " + strconv.Itoa(i),
+			ReminderTime: start.Add(time.Duration(rnd.Int63n(int64(24 * time.Hour)))),
+			State:        model.ReminderPending,
+		}
+	}
+	return reminders
+}
+
+// runs the operation on the slice and the heap scheduler with every number of pending reminders
+func benchmarkSchedulers(b *testing.B, run func(b *testing.B, s scheduler, reminders []model.Reminder)) {
+	for _, n := range benchmarkSizes {
+		reminders := newReminders(n)
+		for _, impl := range []struct {
+			name string
+			new  func() scheduler
+		}{
+			{"slice", func() scheduler { return &sliceScheduler{} }},
+			{"heap", func() scheduler { return &heapScheduler{queue: NewQueue()} }},
+		} {
+			s := impl.new()
+			for _, reminder := range reminders {
+				s.add(reminder)
+			}
+			b.Run(impl.name+"/"+strconv.Itoa(n), func(b *testing.B) {
+				run(b, s, reminders)
+			})
+		}
+	}
+}
+
+func BenchmarkQueueTick(b *testing.B) {
+	benchmarkSchedulers(b, func(b *testing.B, s scheduler, reminders []model.Reminder) {
+		now := time.Now()
+		for i := 0; i < b.N; i++ {
+			s.due(now)
+		}
+	})
+}
+
+func BenchmarkQueueAddCancel(b *testing.B) {
+	benchmarkSchedulers(b, func(b *testing.B, s scheduler, reminders []model.Reminder) {
+		for i := 0; i < b.N; i++ {
+			reminder := reminders[i%len(reminders)]
+			s.cancel(reminder.ID)
+			s.add(reminder)
+		}
+	})
+}
+
+func BenchmarkQueueReschedule(b *testing.B) {
+	benchmarkSchedulers(b, func(b *testing.B, s scheduler, reminders []model.Reminder) {
+		at := time.Now().Add(2 * time.Hour)
+		for i := 0; i < b.N; i++ {
+			s.reschedule(reminders[(i*7919)%len(reminders)].ID, at.Add(time.Duration(i)*time.Millisecond))
+		}
+	})
+}
@@ -0,0 +1,12 @@
+package service
+
+import "toDoList/internal/storage"
+
+// Errors returned by the services, they are the same values as the storage errors,
+// so callers only need to check them with errors.Is
+var (
+	ErrNotFound    = storage.ErrNotFound
+	ErrConflict    = storage.ErrConflict
+	ErrValidation  = storage.ErrValidation
+	ErrUnavailable = storage.ErrUnavailable
+)
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+	"toDoList/internal/events"
+	"toDoList/internal/model"
+	"toDoList/internal/notify"
+	"toDoList/internal/storage"
+)
+
+const (
+	// notifications read from the log at once when a client catches up
+	notificationReplayBatch = 500
+	// the log is trimmed once it holds this many notifications more than its size, in one DELETE
+	notificationTrimBatch = 100
+	// timeout of trimming the log in the background
+	notificationTrimTimeout = 10 * time.Second
+)
+
+// NotificationService keeps the notification log and publishes notifications to the clients of the hub
+type NotificationService struct {
+	storage storage.NotificationStorage
+	hub     *notify.Hub
+	logSize int64 // number of latest notifications kept for clients that reconnect
+
+	// notifications are stored concurrently but published in the order of their IDs, so a client that
+	// subscribed before reading the log gets every later notification from the hub. A stored notification
+	// waits until the inserts that were running when it got its ID returned, they may get lower IDs
+	mu        sync.Mutex
+	settled   *sync.Cond            // broadcast when an insert returned
+	tickets   int64                 // inserts started so far, each is numbered by a ticket
+	inserting map[int64]bool        // tickets of the inserts that did not return yet
+	waiting   []waitingNotification // stored but not published yet, ordered by ID
+	published int64                 // notifications up to this ID are published, 0 until the first one is
+	trimmedTo int64                 // notifications up to this ID are deleted
+	trimming  bool                  // a trim runs in the background
+}
+
+// waitingNotification is published once the inserts with a ticket lower than before returned
+type waitingNotification struct {
+	notification model.Notification
+	before       int64
+}
+
+// NewNotificationService creates a service that keeps the latest logSize notifications
+func NewNotificationService(storage storage.NotificationStorage, hub *notify.Hub, logSize int) *NotificationService {
+	ns := &NotificationService{storage: storage, hub: hub, logSize: int64(logSize), inserting: make(map[int64]bool)}
+	ns.settled = sync.NewCond(&ns.mu)
+	return ns
+}
+
+// Notify stores the notification with data marshaled to JSON and publishes it. If it cannot be stored,
+// it is still published, but without an ID, so it cannot be replayed. Failures are only logged
+func (ns *NotificationService) Notify(ctx context.Context, notificationType model.NotificationType, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Could not encode %v notification: %v", notificationType, err)
+		return
+	}
+	notification := model.Notification{
+		Type:      notificationType,
+		Data:      string(payload),
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+
+	ns.mu.Lock()
+	ticket := ns.tickets
+	ns.tickets++
+	ns.inserting[ticket] = true
+	ns.mu.Unlock()
+
+	stored, err := ns.storage.AddNotification(ctx, notification)
+
+	ns.mu.Lock()
+	delete(ns.inserting, ticket)
+	if err != nil {
+		ns.hub.Publish(notification)
+	} else {
+		// the inserts started until now may still get a lower ID, later ones get higher IDs
+		ns.waiting = append(ns.waiting, waitingNotification{notification: stored, before: ns.tickets})
+		sort.Slice(ns.waiting, func(i, j int) bool { return ns.waiting[i].notification.ID < ns.waiting[j].notification.ID })
+	}
+	ns.publishWaiting()
+
+	// the log is bounded, older notifications are not replayed anymore. Trimming runs in the background
+	upTo := stored.ID - ns.logSize
+	trim := err == nil && !ns.trimming && upTo-ns.trimmedTo >= notificationTrimBatch
+	if trim {
+		ns.trimming = true
+	}
+	ns.mu.Unlock()
+	ns.settled.Broadcast()
+
+	if err != nil {
+		log.Printf("Could not store %v notification, it is sent without an ID: %v", notificationType, err)
+		return
+	}
+	if trim {
+		go ns.trim(upTo)
+	}
+}
+
+// publishes the waiting notifications in the order of their IDs, up to the first one
+// an insert still running may get a lower ID than. The caller holds mu
+func (ns *NotificationService) publishWaiting() {
+	running := int64(math.MaxInt64) // the lowest ticket of the running inserts
+	for ticket := range ns.inserting {
+		running = min(running, ticket)
+	}
+	for len(ns.waiting) > 0 && ns.waiting[0].before <= running {
+		next := ns.waiting[0].notification
+		ns.hub.Publish(next)
+		ns.published = next.ID
+		ns.waiting = ns.waiting[1:]
+	}
+}
+
+// deletes the notifications up to and including the ID
+func (ns *NotificationService) trim(upTo int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), notificationTrimTimeout)
+	defer cancel()
+	err := ns.storage.DeleteNotifications(ctx, upTo)
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	ns.trimming = false
+	if err != nil {
+		log.Printf("Could not trim the notification log: %v", err)
+		return
+	}
+	ns.trimmedTo = upTo
+}
+
+// Run notifies the reminders read from source until it is closed
+func (ns *NotificationService) Run(source <-chan model.ReminderNotification) {
+	for reminder := range source {
+		ctx, cancel := context.WithTimeout(context.Background(), reminderStorageTimeout)
+		ns.Notify(ctx, model.NotificationReminder, reminder)
+		cancel()
+	}
+}
+
+// HandleTodoEvent sends the changed todo to the clients as they see it from GET /todos/:id
+func (ns *NotificationService) HandleTodoEvent(ctx context.Context, event events.Event) error {
+	switch event.Type {
+	case events.TodoCreated:
+		ns.Notify(ctx, model.NotificationTodoCreated, event.Todo.Public())
+	case events.TodoUpdated, events.TodoImageUploaded:
+		ns.Notify(ctx, model.NotificationTodoUpdated, event.Todo.Public())
+	case events.TodoDeleted:
+		ns.Notify(ctx, model.NotificationTodoDeleted, map[string]string{"id": event.TodoID})
+	}
+	return nil
+}
+
+// Subscribe registers a client for the notifications published from now on
+func (ns *NotificationService) Subscribe() *notify.Subscription {
+	return ns.hub.Subscribe()
+}
+
+// Replay returns the logged notifications with an ID greater than lastID, oldest first. It stops at the
+// last published one, the later ones reach a client that subscribed before from the hub
+func (ns *NotificationService) Replay(ctx context.Context, lastID int64) ([]model.Notification, error) {
+	for {
+		ns.mu.Lock()
+		for ns.published == 0 && len(ns.inserting) > 0 {
+			ns.settled.Wait()
+		}
+		upTo, tickets := ns.published, ns.tickets
+		ns.mu.Unlock()
+		if upTo > 0 {
+			return ns.read(ctx, lastID, upTo)
+		}
+
+		// nothing is published since the start, the whole log is settled unless an insert ran meanwhile
+		missed, err := ns.read(ctx, lastID, math.MaxInt64)
+		if err != nil {
+			return nil, err
+		}
+		ns.mu.Lock()
+		settled := ns.tickets == tickets
+		if settled && len(missed) > 0 && ns.published == 0 {
+			ns.published = missed[len(missed)-1].ID
+		}
+		ns.mu.Unlock()
+		if settled {
+			return missed, nil
+		}
+	}
+}
+
+// reads the notifications after lastID up to and including upTo
+func (ns *NotificationService) read(ctx context.Context, lastID int64, upTo int64) ([]model.Notification, error) {
+	var missed []model.Notification
+	for {
+		batch, err := ns.storage.GetNotifications(ctx, lastID, notificationReplayBatch)
+		if err != nil {
+			return nil, err
+		}
+		for i, notification := range batch {
+			if notification.ID > upTo {
+				return append(missed, batch[:i]...), nil
+			}
+		}
+		missed = append(missed, batch...)
+		if len(batch) < notificationReplayBatch {
+			return missed, nil
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"toDoList/internal/model"
+	"toDoList/internal/notify"
+	"toDoList/internal/storage"
+)
+
+// slowTrim blocks DeleteNotifications until release is closed
+type slowTrim struct {
+	storage.NotificationStorage
+	deletes chan int64
+	release chan struct{}
+}
+
+func (s *slowTrim) DeleteNotifications(ctx context.Context, upToID int64) error {
+	s.deletes <- upToID
+	<-s.release
+	return s.NotificationStorage.DeleteNotifications(ctx, upToID)
+}
+
+func TestNotifyTrimsLogInBatchesInBackground(t *testing.T) {
+	store := &slowTrim{NotificationStorage: storage.NewMemoryDb(), deletes: make(chan int64, 10), release: make(chan struct{})}
+	hub := notify.NewHub(1000)
+	sub := hub.Subscribe()
+	defer sub.Close()
+	const logSize = 10
+	ns := NewNotificationService(store, hub, logSize)
+	ctx := context.Background()
+
+	// no trim until the log holds a whole batch more than its size
+	for i := 0; i < logSize+notificationTrimBatch-1; i++ {
+		ns.Notify(ctx, model.NotificationTodoUpdated, i)
+	}
+	select {
+	case upTo := <-store.deletes:
+		t.Fatalf("the log was trimmed up to %d before it was a batch over its size", upTo)
+	default:
+	}
+
+	ns.Notify(ctx, model.NotificationTodoUpdated, "trim")
+	var upTo int64
+	select {
+	case upTo = <-store.deletes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the log was not trimmed")
+	}
+	if upTo != notificationTrimBatch {
+		t.Errorf("trimmed up to %d, want %d", upTo, notificationTrimBatch)
+	}
+
+	// the trim is still blocked, notifications are stored and published meanwhile, without a second trim
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < notificationTrimBatch; i++ {
+			ns.Notify(ctx, model.NotificationTodoUpdated, "while trimming")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Notify waited for the trim")
+	}
+	select {
+	case upTo := <-store.deletes:
+		t.Fatalf("a second trim up to %d started while the first one ran", upTo)
+	default:
+	}
+	if got := len(sub.C); got != logSize+2*notificationTrimBatch {
+		t.Errorf("published %d notifications, want %d", got, logSize+2*notificationTrimBatch)
+	}
+
+	close(store.release)
+	// the next notification trims what piled up meanwhile
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ns.Notify(ctx, model.NotificationTodoUpdated, "after")
+		select {
+		case upTo := <-store.deletes:
+			if upTo <= notificationTrimBatch {
+				t.Fatalf("second trim up to %d, want more than %d", upTo, notificationTrimBatch)
+			}
+			return
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the log was not trimmed again")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// heldInserts stores a notification right away, but returns from AddNotification for
+// the IDs in release only once their channel is closed
+type heldInserts struct {
+	storage.NotificationStorage
+	stored  chan model.Notification
+	release map[int64]chan struct{}
+}
+
+func (h *heldInserts) AddNotification(ctx context.Context, notification model.Notification) (model.Notification, error) {
+	stored, err := h.NotificationStorage.AddNotification(ctx, notification)
+	if held, ok := h.release[stored.ID]; ok && err == nil {
+		h.stored <- stored
+		<-held
+	}
+	return stored, err
+}
+
+// returns the IDs published to the subscription so far
+func publishedIDs(sub *notify.Subscription) []int64 {
+	var ids []int64
+	for {
+		select {
+		case notification := <-sub.C:
+			ids = append(ids, notification.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func replayedIDs(t *testing.T, ns *NotificationService) []int64 {
+	t.Helper()
+	missed, err := ns.Replay(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	var ids []int64
+	for _, notification := range missed {
+		ids = append(ids, notification.ID)
+	}
+	return ids
+}
+
+func TestNotifyPublishesInIDOrderWithoutSerializingInserts(t *testing.T) {
+	store := &heldInserts{
+		NotificationStorage: storage.NewMemoryDb(),
+		stored:              make(chan model.Notification, 2),
+		release:             map[int64]chan struct{}{2: make(chan struct{}), 3: make(chan struct{})},
+	}
+	hub := notify.NewHub(10)
+	sub := hub.Subscribe()
+	defer sub.Close()
+	ns := NewNotificationService(store, hub, 100)
+	ctx := context.Background()
+	ns.Notify(ctx, model.NotificationTodoCreated, "first")
+
+	var wg sync.WaitGroup
+	done := map[string]chan struct{}{"a": make(chan struct{}), "b": make(chan struct{})}
+	for data, returned := range done {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(returned)
+			ns.Notify(ctx, model.NotificationTodoUpdated, data)
+		}()
+	}
+	// both are stored before either insert returned, so they did not wait for each other
+	byID := make(map[int64]string)
+	for i := 0; i < 2; i++ {
+		select {
+		case stored := <-store.stored:
+			byID[stored.ID] = strings.Trim(stored.Data, `"`)
+		case <-time.After(5 * time.Second):
+			t.Fatal("an insert waited for the other one to return")
+		}
+	}
+
+	// 3 is stored, but the insert that got 2 may not have returned it yet
+	close(store.release[3])
+	<-done[byID[3]]
+	if ids := publishedIDs(sub); len(ids) != 1 || ids[0] != 1 {
+		t.Fatalf("published %v before 2 returned, want only 1", ids)
+	}
+	if ids := replayedIDs(t, ns); len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("replayed %v before 2 returned, want only 1, a client would skip 2 after 3", ids)
+	}
+
+	close(store.release[2])
+	wg.Wait()
+	if ids := publishedIDs(sub); len(ids) != 2 || ids[0] != 2 || ids[1] != 3 {
+		t.Errorf("published %v after 2 returned, want 2 and 3", ids)
+	}
+	if ids := replayedIDs(t, ns); len(ids) != 3 {
+		t.Errorf("replayed %v, want 1 to 3", ids)
+	}
+}
+
+func TestReplayAfterRestartReturnsWholeLog(t *testing.T) {
+	store := storage.NewMemoryDb()
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := store.AddNotification(ctx, model.Notification{Type: model.NotificationTodoCreated, Data: "{}"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// nothing is published by this service yet
+	ns := NewNotificationService(store, notify.NewHub(10), 100)
+	if ids := replayedIDs(t, ns); len(ids) != 3 {
+		t.Fatalf("replayed %v, want the 3 notifications stored before", ids)
+	}
+	ns.Notify(ctx, model.NotificationTodoUpdated, "next")
+	if ids := replayedIDs(t, ns); len(ids) != 4 || ids[3] != 4 {
+		t.Errorf("replayed %v, want 1 to 4", ids)
+	}
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"toDoList/internal/events"
+	"toDoList/internal/model"
+	"toDoList/internal/storage"
+)
+
+const (
+	// events claimed at once
+	outboxBatchSize = 100
+	// a claimed event is claimed again after it, if its relay stopped before marking it
+	outboxLease = time.Minute
+	// how often the worker looks for events that were not published by their request
+	outboxPollInterval = time.Second
+	// how often published events older than the retention are dropped
+	outboxPruneInterval = time.Hour
+	// timeout of publishing a single event and of storage calls made by the worker
+	outboxTimeout = 10 * time.Second
+	// delay before the first retry, it doubles with every failed attempt up to outboxMaxRetryDelay
+	outboxRetryDelay    = time.Second
+	outboxMaxRetryDelay = 5 * time.Minute
+)
+
+var (
+	outboxPublishedCount = expvar.NewInt("outbox_published") // events every sync subscriber handled
+	outboxFailedCount    = expvar.NewInt("outbox_failed")    // failed attempts, the event is retried
+	outboxDeadCount      = expvar.NewInt("outbox_dead")      // events that failed too often
+)
+
+// OutboxRelay publishes the outbox events written by the storage to the event bus, at least once:
+// an event is marked published only after every sync subscriber handled it, failed events
+// are retried with a growing delay and become dead after maxAttempts failures. Async subscribers get
+// a bus event only once every sync subscriber handled it and a retry skips the bus events that were
+// delivered already, so they get them once, the ones that dropped an event are counted in its Dropped
+type OutboxRelay struct {
+	storage     storage.OutboxStorage
+	bus         *events.Bus
+	maxAttempts int
+	retention   time.Duration // published events are kept this long
+
+	wakeChannel chan struct{}
+	stopChannel chan struct{}
+	stopped     sync.WaitGroup
+}
+
+// NewOutboxRelay creates a relay, the worker is started by StartWorker
+func NewOutboxRelay(storage storage.OutboxStorage, bus *events.Bus, maxAttempts int, retention time.Duration) *OutboxRelay {
+	return &OutboxRelay{
+		storage:     storage,
+		bus:         bus,
+		maxAttempts: maxAttempts,
+		retention:   retention,
+		wakeChannel: make(chan struct{}, 1),
+		stopChannel: make(chan struct{}),
+	}
+}
+
+// Flush publishes the pending events of the todo right away, so the request that changed it
+// returns after its sync subscribers. The change is stored already, so failures are only logged,
+// the worker retries them. A canceled request does not cancel the publishing, it would count as
+// a failed attempt although no subscriber failed
+func (r *OutboxRelay) Flush(ctx context.Context, todoID string) {
+	ctx = context.WithoutCancel(ctx)
+	// a claim returns the oldest pending event of the todo, the next one only after it is done
+	for {
+		claimCtx, cancel := context.WithTimeout(ctx, outboxTimeout)
+		claimed, err := r.storage.ClaimOutboxEvents(claimCtx, todoID, outboxLease, outboxBatchSize)
+		cancel()
+		if err != nil {
+			log.Printf("Could not read outbox events of todo %v, the worker publishes them: %v", todoID, err)
+			return
+		}
+		if len(claimed) == 0 {
+			return
+		}
+		r.publish(ctx, claimed)
+	}
+}
+
+// StartWorker starts the worker that publishes the events not flushed by their request:
+// the ones written before a restart and the ones being retried
+func (r *OutboxRelay) StartWorker() {
+	r.stopped.Add(1)
+	go func() {
+		defer r.stopped.Done()
+		poll := time.NewTicker(outboxPollInterval)
+		defer poll.Stop()
+		prune := time.NewTicker(outboxPruneInterval)
+		defer prune.Stop()
+
+		r.publishDue()
+		for {
+			select {
+			case <-poll.C:
+			case <-r.wakeChannel:
+			case <-prune.C:
+				r.prune()
+				continue
+			case <-r.stopChannel:
+				log.Println("Outbox relay is stopping...")
+				return
+			}
+			r.publishDue()
+		}
+	}()
+}
+
+// StopWorker stops the worker and waits until it is done with the current batch
+func (r *OutboxRelay) StopWorker() {
+	close(r.stopChannel)
+	r.stopped.Wait()
+}
+
+// wakes the worker without blocking, a pending wake-up is enough
+func (r *OutboxRelay) wake() {
+	select {
+	case r.wakeChannel <- struct{}{}:
+	default:
+	}
+}
+
+// publishes batches of due events until there are no more. A batch holds at most one event
+// per todo, its next one is claimed by a later batch once the event is published or dead
+func (r *OutboxRelay) publishDue() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), outboxTimeout)
+		claimed, err := r.storage.ClaimOutboxEvents(ctx, "", outboxLease, outboxBatchSize)
+		cancel()
+		if err != nil {
+			log.Printf("Could not read outbox events: %v", err)
+			return
+		}
+		if len(claimed) == 0 {
+			return
+		}
+		r.publish(context.Background(), claimed)
+	}
+}
+
+// publishes the claimed events. A failed event stays pending, so the later events of its todo
+// are not claimed before its retry and subscribers do not see the changes of a todo out of order
+func (r *OutboxRelay) publish(ctx context.Context, claimed []model.OutboxEvent) {
+	for _, event := range claimed {
+		publishCtx, cancel := context.WithTimeout(ctx, outboxTimeout)
+		err := r.publishEvent(publishCtx, &event)
+		cancel()
+		if err == nil {
+			event.Status = model.OutboxPublished
+			outboxPublishedCount.Add(1)
+			r.save(event)
+			continue
+		}
+
+		event.Attempts++
+		event.LastError = err.Error()
+		if event.Attempts >= r.maxAttempts {
+			event.Status = model.OutboxDead
+			outboxDeadCount.Add(1)
+			log.Printf("Outbox event %v (%v of todo %v) failed %d times and is dead: %v",
+				event.ID, event.Type, event.TodoID, event.Attempts, err)
+		} else {
+			event.NextAttemptAt = time.Now().UTC().Add(outboxBackoff(event.Attempts))
+			outboxFailedCount.Add(1)
+			log.Printf("Could not publish outbox event %v (%v of todo %v), attempt %d, retrying at %v: %v",
+				event.ID, event.Type, event.TodoID, event.Attempts, event.NextAttemptAt.Format(time.RFC3339), err)
+		}
+		r.save(event)
+	}
+}
+
+// publishes the event to the bus, a todo.updated that changed the status is followed by todo.status_changed.
+// The bus events every subscriber got are counted in Delivered, so a retry only publishes the rest
+func (r *OutboxRelay) publishEvent(ctx context.Context, outboxEvent *model.OutboxEvent) error {
+	todo, err := outboxEvent.DecodeTodo()
+	if err != nil {
+		return fmt.Errorf("invalid todo: %v", err)
+	}
+	event := events.Event{
+		ID:         outboxEvent.ID,
+		Type:       events.Type(outboxEvent.Type),
+		TodoID:     outboxEvent.TodoID,
+		Todo:       todo,
+		OccurredAt: outboxEvent.CreatedAt,
+	}
+	busEvents := []events.Event{event}
+	if event.Type == events.TodoUpdated && outboxEvent.OldStatus != "" && outboxEvent.OldStatus != todo.Status {
+		event.Type = events.TodoStatusChanged
+		event.OldStatus = outboxEvent.OldStatus
+		busEvents = append(busEvents, event)
+	}
+
+	for _, busEvent := range busEvents[min(outboxEvent.Delivered, len(busEvents)):] {
+		dropped, err := r.bus.Publish(ctx, busEvent)
+		if err != nil {
+			return err
+		}
+		outboxEvent.Delivered++
+		outboxEvent.Dropped += dropped
+	}
+	return nil
+}
+
+// writes the outcome of an attempt, if it is lost the event is published again once its lease passed
+func (r *OutboxRelay) save(event model.OutboxEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), outboxTimeout)
+	defer cancel()
+	if err := r.storage.UpdateOutboxEvent(ctx, event); err != nil {
+		log.Printf("Could not save outbox event %v as %v: %v", event.ID, event.Status, err)
+	}
+}
+
+// drops the published events older than the retention
+func (r *OutboxRelay) prune() {
+	ctx, cancel := context.WithTimeout(context.Background(), outboxTimeout)
+	defer cancel()
+	if err := r.storage.DeleteOutboxEvents(ctx, time.Now().Add(-r.retention)); err != nil {
+		log.Printf("Could not delete published outbox events: %v", err)
+	}
+}
+
+// returns the delay before the next attempt after the given number of failed ones
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxRetryDelay
+	for i := 1; i < attempts && delay < outboxMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, outboxMaxRetryDelay)
+}
+
+// Events returns up to limit outbox events in the status, oldest first
+func (r *OutboxRelay) Events(ctx context.Context, status model.OutboxStatus, limit int) ([]model.OutboxEvent, error) {
+	if !model.IsValidOutboxStatus(status) {
+		return nil, fmt.Errorf("invalid status %q: %w", status, ErrValidation)
+	}
+	outbox, err := r.storage.GetOutboxEvents(ctx, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	if outbox == nil {
+		outbox = []model.OutboxEvent{}
+	}
+	return outbox, nil
+}
+
+// Requeue makes a dead event pending again with no failed attempts, the worker publishes it right away
+func (r *OutboxRelay) Requeue(ctx context.Context, id string) (model.OutboxEvent, error) {
+	event, err := r.storage.GetOutboxEvent(ctx, id)
+	if err != nil {
+		return model.OutboxEvent{}, err
+	}
+	if event.Status != model.OutboxDead {
+		return model.OutboxEvent{}, fmt.Errorf("outbox event %v is %v, only dead events are requeued: %w", id, event.Status, ErrConflict)
+	}
+	event.Status = model.OutboxPending
+	event.Attempts = 0
+	event.NextAttemptAt = time.Now().UTC().Truncate(time.Millisecond)
+	if err := r.storage.UpdateOutboxEvent(ctx, event); err != nil {
+		return model.OutboxEvent{}, err
+	}
+	r.wake()
+	return event, nil
+}
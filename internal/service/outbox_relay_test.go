@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"toDoList/internal/events"
+	"toDoList/internal/model"
+	"toDoList/internal/storage"
+)
+
+func TestOutboxRelayHoldsBackEventsAfterFailure(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryDb()
+	bus := events.NewBus()
+	defer bus.Close()
+	var seen []string
+	failUpdate := true
+	bus.Subscribe("sync", func(ctx context.Context, event events.Event) error {
+		seen = append(seen, string(event.Type))
+		if event.Type == events.TodoUpdated && failUpdate {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	relay := NewOutboxRelay(store, bus, 10, time.Hour)
+
+	if _, err := store.AddTodo(ctx, model.ToDo{ID: "1", Title: "first", Status: model.Created}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.UpdateTodo(ctx, "1", model.ToDo{Title: "renamed", Status: model.Created}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpdateTodoImage(ctx, "1", "uploads/images/a.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	relay.Flush(ctx, "1")
+	if got := strings.Join(seen, ","); got != "todo.created,todo.updated" {
+		t.Fatalf("subscriber saw %v, the image upload must wait for the failed update", got)
+	}
+	pending, err := store.GetOutboxEvents(ctx, model.OutboxPending, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].Attempts != 1 || pending[0].LastError != "sync: boom" || pending[1].Attempts != 0 {
+		t.Fatalf("pending events after the failure are %+v", pending)
+	}
+
+	// once the retry is due both are published in order
+	failUpdate = false
+	seen = nil
+	retry := pending[0]
+	retry.NextAttemptAt = time.Now().UTC().Truncate(time.Millisecond)
+	if err := store.UpdateOutboxEvent(ctx, retry); err != nil {
+		t.Fatal(err)
+	}
+	relay.Flush(ctx, "1")
+	if got := strings.Join(seen, ","); got != "todo.updated,todo.image_uploaded" {
+		t.Errorf("subscriber saw %v after the retry, want the update and then the image upload", got)
+	}
+}
+
+func TestOutboxRelayCountsDrops(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryDb()
+	bus := events.NewBus()
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	bus.SubscribeAsync("slow", 1, func(ctx context.Context, event events.Event) error {
+		once.Do(func() { close(started) })
+		<-release
+		return nil
+	})
+	relay := NewOutboxRelay(store, bus, 10, time.Hour)
+
+	for _, id := range []string{"1", "2", "3"} {
+		if _, err := store.AddTodo(ctx, model.ToDo{ID: id, Title: id, Status: model.Created}); err != nil {
+			t.Fatal(err)
+		}
+		relay.Flush(ctx, id)
+		if id == "1" {
+			<-started // the subscriber is busy with 1, 2 fills its queue, 3 is dropped
+		}
+	}
+	close(release)
+	bus.Close()
+
+	published, err := store.GetOutboxEvents(ctx, model.OutboxPublished, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 3 {
+		t.Fatalf("published events are %+v, drops must not fail an event", published)
+	}
+	for i, want := range []int{0, 0, 1} {
+		if published[i].Dropped != want {
+			t.Errorf("event of todo %v has %d drops, want %d", published[i].TodoID, published[i].Dropped, want)
+		}
+	}
+}
+
+func TestOutboxRelayDeliversToAsyncOnceDespiteRetries(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryDb()
+	bus := events.NewBus()
+	var mu sync.Mutex
+	var async []string
+	for _, name := range []string{"notifications", "audit"} {
+		bus.SubscribeAsync(name, 10, func(ctx context.Context, event events.Event) error {
+			mu.Lock()
+			defer mu.Unlock()
+			async = append(async, name+" "+string(event.Type))
+			return nil
+		})
+	}
+	var handled []string
+	failures := map[events.Type]int{events.TodoCreated: 2, events.TodoStatusChanged: 1}
+	bus.Subscribe("sync", func(ctx context.Context, event events.Event) error {
+		handled = append(handled, string(event.Type))
+		if failures[event.Type] > 0 {
+			failures[event.Type]--
+			return errors.New("boom")
+		}
+		return nil
+	})
+	relay := NewOutboxRelay(store, bus, 10, time.Hour)
+
+	if _, err := store.AddTodo(ctx, model.ToDo{ID: "1", Title: "first", Status: model.Created}); err != nil {
+		t.Fatal(err)
+	}
+	retryUntilPublished(t, store, relay, "1")
+	if _, err := store.UpdateTodo(ctx, "1", model.ToDo{Title: "first", Status: model.Done}); err != nil {
+		t.Fatal(err)
+	}
+	retryUntilPublished(t, store, relay, "1")
+	bus.Close()
+
+	// the sync subscriber sees every attempt, todo.updated is not published again when only todo.status_changed failed
+	want := "todo.created,todo.created,todo.created,todo.updated,todo.status_changed,todo.status_changed"
+	if got := strings.Join(handled, ","); got != want {
+		t.Errorf("sync subscriber got %v, want %v", got, want)
+	}
+	counts := make(map[string]int)
+	for _, got := range async {
+		counts[got]++
+	}
+	for _, name := range []string{"notifications", "audit"} {
+		for _, eventType := range []events.Type{events.TodoCreated, events.TodoUpdated, events.TodoStatusChanged} {
+			if n := counts[name+" "+string(eventType)]; n != 1 {
+				t.Errorf("%v got %v %d times, want once", name, eventType, n)
+			}
+		}
+	}
+
+	published, err := store.GetOutboxEvents(ctx, model.OutboxPublished, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 2 || published[0].Delivered != 1 || published[1].Delivered != 2 {
+		t.Errorf("published events are %+v, want 1 and 2 delivered bus events", published)
+	}
+}
+
+// flushes the todo and makes its failed event due again until none is pending
+func retryUntilPublished(t *testing.T, store storage.Storage, relay *OutboxRelay, todoID string) {
+	t.Helper()
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		relay.Flush(ctx, todoID)
+		pending, err := store.GetOutboxEvents(ctx, model.OutboxPending, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) == 0 {
+			return
+		}
+		retry := pending[0]
+		retry.NextAttemptAt = time.Now().UTC().Truncate(time.Millisecond)
+		if err := store.UpdateOutboxEvent(ctx, retry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Fatalf("outbox events of todo %v are still pending", todoID)
+}
+
+func TestOutboxRelayFlushIgnoresCanceledRequest(t *testing.T) {
+	store := storage.NewMemoryDb()
+	bus := events.NewBus()
+	defer bus.Close()
+	bus.Subscribe("sync", func(ctx context.Context, event events.Event) error {
+		return ctx.Err()
+	})
+	relay := NewOutboxRelay(store, bus, 10, time.Hour)
+	if _, err := store.AddTodo(context.Background(), model.ToDo{ID: "1", Title: "first", Status: model.Created}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client is gone before its request publishes the change
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	relay.Flush(ctx, "1")
+	published, err := store.GetOutboxEvents(context.Background(), model.OutboxPublished, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 1 || published[0].Attempts != 0 {
+		t.Errorf("published events are %+v, want the event published without a failed attempt", published)
+	}
+}
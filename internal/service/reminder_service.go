@@ -1,62 +1,693 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
+	"toDoList/internal/events"
+	"toDoList/internal/model"
+	"toDoList/internal/schedule"
+	"toDoList/internal/storage"
+	"toDoList/internal/timeparse"
 )
 
-type Reminder struct {
-	ID           string
-	ReminderTime time.Time
-	TaskName     string
-}
+// Reminder is stored by the storage, so it is defined in the model
+type Reminder = model.Reminder
+
+const (
+	// timeout of storage calls made by the worker
+	reminderStorageTimeout = 5 * time.Second
+	// delay before due reminders are sent again when the notification channel is full
+	reminderRetryDelay = time.Second
+	// message of reminders without their own template
+	defaultReminderMessage = "You need to do this task: {{.TaskName}}"
+)
 
 type ReminderService struct {
-	reminderChannel     chan Reminder // Channel for new reminders
-	stopChannel         chan struct{} // Channel for stopping goroutines
-	notificationChannel chan string   // Channel for notifications
-	reminders           []Reminder    // Slice for storing all reminders
+	storage             storage.ReminderStorage         // Keeps reminders over restarts
+	wakeChannel         chan struct{}                   // Wakes the worker when the earliest reminder changed
+	stopChannel         chan struct{}                   // Channel for stopping goroutines
+	notificationChannel chan model.ReminderNotification // Buffered channel for notifications, never blocks the worker
+	clock               timeparse.Clock                 // Tells which reminders are due
+	stopped             sync.WaitGroup                  // Running worker
+
+	mu    sync.Mutex
+	queue *schedule.Queue // Pending reminders ordered by time
 }
 
-// NewReminderService creates a new service for working with reminders
-func NewReminderService(notificationChannel chan string) *ReminderService {
+// NewReminderService creates a new service for working with reminders, reminders are due by the time of clock, nil is time.Now
+func NewReminderService(storage storage.ReminderStorage, notificationChannel chan model.ReminderNotification,
+	clock timeparse.Clock) *ReminderService {
+	if clock == nil {
+		clock = time.Now
+	}
 	return &ReminderService{
-		reminderChannel:     make(chan Reminder),
+		storage:             storage,
+		wakeChannel:         make(chan struct{}, 1),
 		stopChannel:         make(chan struct{}),
 		notificationChannel: notificationChannel,
-		reminders:           []Reminder{},
+		clock:               clock,
+		queue:               schedule.NewQueue(),
 	}
 }
 
-func (rs *ReminderService) StartWorker() {
+// StartWorker loads the pending reminders and starts the worker, reminders
+// that became due while the server was down are fired right away
+func (rs *ReminderService) StartWorker(ctx context.Context) error {
+	stored, err := rs.storage.GetReminders(ctx, model.ReminderPending)
+	if err != nil {
+		return err
+	}
+
+	overdue := 0
+	rs.mu.Lock()
+	for _, reminder := range stored {
+		rs.queue.Push(reminder)
+		if reminder.ReminderTime.Before(rs.clock()) {
+			overdue++
+		}
+	}
+	rs.mu.Unlock()
+	log.Printf("Loaded %d reminders, %d of them are overdue", len(stored), overdue)
+
+	rs.stopped.Add(1)
 	go func() {
-		ticker := time.NewTicker(1 * time.Second) // Check every second
-		defer ticker.Stop()
+		defer rs.stopped.Done()
+		// a single timer waits for the earliest reminder
+		timer := time.NewTimer(0)
+		defer timer.Stop()
 
 		for {
 			select {
-			case reminder := <-rs.reminderChannel:
-				rs.reminders = append(rs.reminders, reminder)
-				log.Printf("Reminder received: %v for task '%s'\n", reminder.ReminderTime, reminder.TaskName)
-			case <-ticker.C:
-				for _, reminder := range rs.reminders {
-					if time.Now().After(reminder.ReminderTime) {
-						rs.notificationChannel <- "You need to do this task: " + reminder.TaskName
-					}
-				}
+			case <-timer.C:
+				rs.fireDue()
+			case <-rs.wakeChannel:
 			case <-rs.stopChannel:
 				log.Println("Reminder worker is stopping...")
 				return
 			}
+			timer.Reset(rs.nextWait())
 		}
 	}()
+	return nil
+}
+
+// returns how long the worker can sleep
+func (rs *ReminderService) nextWait() time.Duration {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	next, ok := rs.queue.Next()
+	if !ok {
+		return time.Hour // woken up earlier by AddReminder
+	}
+	wait := next.ReminderTime.Sub(rs.clock())
+	if wait <= 0 {
+		// still due after fireDue, so the notification channel is full
+		return reminderRetryDelay
+	}
+	return wait
+}
+
+// wakes the worker, so it recalculates the timer
+func (rs *ReminderService) wake() {
+	select {
+	case rs.wakeChannel <- struct{}{}:
+	default: // already woken
+	}
+}
+
+// sends notifications for due reminders and marks them delivered, so every reminder
+// fires once. A reminder is marked after the send, so it is delivered at least once:
+// if the server stops in between, it fires again after the restart.
+// Recurring reminders stay pending and move to their next occurrence instead
+func (rs *ReminderService) fireDue() {
+	var delivered, repeated []Reminder
+	rs.mu.Lock()
+	now := rs.clock()
+	for {
+		reminder, ok := rs.queue.Next()
+		if !ok || reminder.ReminderTime.After(now) {
+			break
+		}
+		if !rs.send(reminder) {
+			// nobody reads notifications fast enough, try again later
+			log.Printf("Notification queue is full, due reminders wait for %v", reminderRetryDelay)
+			break
+		}
+		if next, ok := nextOccurrence(reminder, now); ok {
+			reminder.ReminderTime = next
+			rs.queue.Push(reminder)
+			repeated = append(repeated, reminder)
+			continue
+		}
+		rs.queue.Pop()
+		delivered = append(delivered, reminder)
+	}
+	rs.mu.Unlock()
+
+	for _, reminder := range delivered {
+		rs.markDelivered(reminder)
+	}
+	for _, reminder := range repeated {
+		rs.saveOccurrence(reminder)
+	}
+}
+
+// delivers the message of the reminder to its channel, reports false if the notification channel is full
+func (rs *ReminderService) send(reminder Reminder) bool {
+	message, err := renderMessage(reminder)
+	if err != nil {
+		// checked when the reminder was added, so only stored reminders changed since end up here
+		log.Printf("Reminder %v has invalid message, the default is sent: %v", reminder.ID, err)
+		message, _ = renderMessage(Reminder{TaskName: reminder.TaskName})
+	}
+
+	if reminder.Channel == model.ChannelLog {
+		log.Printf("Reminder for task id '%s': %s\n", reminder.TodoID, message)
+		return true
+	}
+	select {
+	case rs.notificationChannel <- model.ReminderNotification{ReminderID: reminder.ID, TodoID: reminder.TodoID, Message: message}:
+		return true
+	default:
+		return false
+	}
+}
+
+// executes the message template of the reminder with the reminder
+func renderMessage(reminder Reminder) (string, error) {
+	text := reminder.Message
+	if text == "" {
+		text = defaultReminderMessage
+	}
+	tmpl, err := template.New("message").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var message strings.Builder
+	if err := tmpl.Execute(&message, reminder); err != nil {
+		return "", err
+	}
+	return message.String(), nil
+}
+
+// returns the first occurrence of a recurring reminder after now, occurrences missed
+// while the server was down are skipped
+func nextOccurrence(reminder Reminder, now time.Time) (time.Time, bool) {
+	if reminder.Recurrence == "" {
+		return time.Time{}, false
+	}
+	loc, err := time.LoadLocation(reminder.TimeZone)
+	if err != nil {
+		log.Printf("Reminder %v has invalid time zone %q, it does not repeat: %v", reminder.ID, reminder.TimeZone, err)
+		return time.Time{}, false
+	}
+	next, err := timeparse.ParseAt(reminder.Recurrence, now, loc)
+	if err != nil || next.Recurrence == "" {
+		log.Printf("Reminder %v has invalid recurrence %q, it does not repeat: %v", reminder.ID, reminder.Recurrence, err)
+		return time.Time{}, false
+	}
+	// storages keep milliseconds
+	return next.At.UTC().Truncate(time.Millisecond), true
+}
+
+func (rs *ReminderService) saveOccurrence(reminder Reminder) {
+	ctx, cancel := context.WithTimeout(context.Background(), reminderStorageTimeout)
+	defer cancel()
+
+	err := rs.storage.UpdateReminder(ctx, reminder)
+	if err != nil {
+		// the queue has the next occurrence, the stored one fires again only after a restart
+		log.Printf("Could not move reminder %v to its next occurrence: %v", reminder.ID, err)
+		return
+	}
+	rs.record(ctx, model.ReminderEventDelivered, reminder)
+}
+
+func (rs *ReminderService) markDelivered(reminder Reminder) {
+	ctx, cancel := context.WithTimeout(context.Background(), reminderStorageTimeout)
+	defer cancel()
+
+	err := rs.storage.SetReminderState(ctx, reminder.ID, model.ReminderPending, model.ReminderDelivered)
+	if err != nil {
+		// the reminder is still dropped from the queue, it fires again only after a restart
+		log.Printf("Could not mark reminder %v as delivered: %v", reminder.ID, err)
+		return
+	}
+	reminder.State = model.ReminderDelivered
+	rs.record(ctx, model.ReminderEventDelivered, reminder)
+}
+
+// records a transition of the reminder in the history of its todo,
+// a failure is only logged, the transition itself is done already
+func (rs *ReminderService) record(ctx context.Context, eventType model.ReminderEventType, reminder Reminder) {
+	err := rs.storage.AddReminderEvent(ctx, model.ReminderEvent{
+		ReminderID:   reminder.ID,
+		TodoID:       reminder.TodoID,
+		Type:         eventType,
+		State:        reminder.State,
+		ReminderTime: reminder.ReminderTime,
+		OccurredAt:   rs.clock().UTC().Truncate(time.Millisecond),
+	})
+	if err != nil {
+		log.Printf("Could not record %v event of reminder %v: %v", eventType, reminder.ID, err)
+	}
+}
+
+// returns the reminder if it belongs to the todo
+func (rs *ReminderService) todoReminder(ctx context.Context, todoID string, id string) (Reminder, error) {
+	reminder, err := rs.storage.GetReminder(ctx, id)
+	if err != nil {
+		return Reminder{}, err
+	}
+	if reminder.TodoID != todoID {
+		return Reminder{}, fmt.Errorf("reminder with ID %v of todo %v %w", id, todoID, ErrNotFound)
+	}
+	return reminder, nil
+}
+
+// drops the reminder from the queue, so the worker does not fire it while it is changed,
+// the returned function puts it back if the change failed
+func (rs *ReminderService) unqueue(id string) (restore func()) {
+	rs.mu.Lock()
+	queued, ok := rs.queue.Get(id)
+	rs.queue.Remove(id)
+	rs.mu.Unlock()
+
+	return func() {
+		if !ok {
+			return
+		}
+		rs.mu.Lock()
+		rs.queue.Push(queued)
+		rs.mu.Unlock()
+		rs.wake()
+	}
+}
+
+// Acknowledge stops the reminder: it is not sent again, even if it was snoozed or repeats
+func (rs *ReminderService) Acknowledge(ctx context.Context, todoID string, id string) (Reminder, error) {
+	reminder, err := rs.todoReminder(ctx, todoID, id)
+	if err != nil {
+		return Reminder{}, err
+	}
+
+	restore := rs.unqueue(id)
+	// the worker may deliver the reminder meanwhile, so both states are tried
+	err = rs.storage.SetReminderState(ctx, id, model.ReminderPending, model.ReminderAcknowledged)
+	if errors.Is(err, storage.ErrConflict) {
+		err = rs.storage.SetReminderState(ctx, id, model.ReminderDelivered, model.ReminderAcknowledged)
+	}
+	if err != nil {
+		restore()
+		return Reminder{}, err
+	}
+
+	reminder.State = model.ReminderAcknowledged
+	rs.record(ctx, model.ReminderEventAcknowledged, reminder)
+	return reminder, nil
+}
+
+// Snooze tells how long a reminder is put off, either Duration (15m, 2h) or one of SnoozePresets
+type Snooze struct {
+	Duration string `json:"duration"`
+	Preset   string `json:"preset"`
+}
+
+// SnoozePresets are phrases read in the time zone of the reminder
+var SnoozePresets = map[string]string{
+	"short":     "in 10 minutes",
+	"hour":      "in 1 hour",
+	"evening":   "at 18:00",
+	"tomorrow":  "tomorrow 9am",
+	"next_week": "next monday 9am",
+}
+
+// returns the time the reminder is snoozed until
+func snoozeUntil(reminder Reminder, snooze Snooze, now time.Time) (time.Time, error) {
+	switch {
+	case snooze.Duration != "" && snooze.Preset != "":
+		return time.Time{}, fmt.Errorf("set either duration or preset: %w", ErrValidation)
+	case snooze.Duration != "":
+		duration, err := time.ParseDuration(snooze.Duration)
+		if err != nil || duration <= 0 {
+			return time.Time{}, fmt.Errorf("invalid duration %q, use a positive duration like 15m: %w", snooze.Duration, ErrValidation)
+		}
+		return now.Add(duration), nil
+	case snooze.Preset != "":
+		phrase, ok := SnoozePresets[snooze.Preset]
+		if !ok {
+			return time.Time{}, fmt.Errorf("unknown preset %q: %w", snooze.Preset, ErrValidation)
+		}
+		loc, err := time.LoadLocation(reminder.TimeZone)
+		if err != nil {
+			loc = time.UTC
+		}
+		resolved, err := timeparse.ParseAt(phrase, now, loc)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return resolved.At, nil
+	}
+	return time.Time{}, fmt.Errorf("set duration or preset: %w", ErrValidation)
+}
+
+// Snooze puts the reminder off, a delivered reminder becomes pending again
+func (rs *ReminderService) Snooze(ctx context.Context, todoID string, id string, snooze Snooze) (Reminder, error) {
+	reminder, err := rs.todoReminder(ctx, todoID, id)
+	if err != nil {
+		return Reminder{}, err
+	}
+	until, err := snoozeUntil(reminder, snooze, rs.clock())
+	if err != nil {
+		return Reminder{}, err
+	}
+
+	restore := rs.unqueue(id)
+	// storages keep milliseconds
+	reminder, err = rs.storage.SnoozeReminder(ctx, id, until.UTC().Truncate(time.Millisecond))
+	if err != nil {
+		restore()
+		return Reminder{}, err
+	}
+	rs.mu.Lock()
+	rs.queue.Push(reminder)
+	rs.mu.Unlock()
+	rs.wake()
+
+	rs.record(ctx, model.ReminderEventSnoozed, reminder)
+	return reminder, nil
+}
+
+// History returns the transitions of the reminders of the todo, oldest first
+func (rs *ReminderService) History(ctx context.Context, todoID string) ([]model.ReminderEvent, error) {
+	events, err := rs.storage.GetReminderEvents(ctx, todoID)
+	if err != nil {
+		return nil, err
+	}
+	if events == nil {
+		events = []model.ReminderEvent{}
+	}
+	return events, nil
+}
+
+// TodoReminders returns the reminders of the todo in every state, ordered by time
+func (rs *ReminderService) TodoReminders(ctx context.Context, todoID string) ([]Reminder, error) {
+	reminders, err := rs.storage.GetTodoReminders(ctx, todoID)
+	if err != nil {
+		return nil, err
+	}
+	if reminders == nil {
+		reminders = []Reminder{}
+	}
+	return reminders, nil
+}
+
+// AddReminder stores the reminder before scheduling it, so it is not lost on restart.
+// The channel is sse if not set
+func (rs *ReminderService) AddReminder(ctx context.Context, reminder Reminder) (Reminder, error) {
+	if reminder.Channel == "" {
+		reminder.Channel = model.ChannelSSE
+	}
+	if !model.IsValidChannel(reminder.Channel) {
+		return Reminder{}, fmt.Errorf("invalid channel %q: %w", reminder.Channel, ErrValidation)
+	}
+	if _, err := renderMessage(reminder); err != nil {
+		return Reminder{}, fmt.Errorf("invalid message: %v: %w", err, ErrValidation)
+	}
+	// storages keep milliseconds
+	reminder.ReminderTime = reminder.ReminderTime.UTC().Truncate(time.Millisecond)
+	reminder.State = model.ReminderPending
+	reminder, err := rs.storage.AddReminder(ctx, reminder)
+	if err != nil {
+		return Reminder{}, err
+	}
+	log.Printf("Adding reminder for task id '%s' with time '%v'\n", reminder.TodoID, reminder.ReminderTime)
+
+	rs.mu.Lock()
+	rs.queue.Push(reminder)
+	rs.mu.Unlock()
+	rs.wake()
+	rs.record(ctx, model.ReminderEventCreated, reminder)
+	return reminder, nil
+}
+
+// Delete removes a reminder of the todo, its history is kept
+func (rs *ReminderService) Delete(ctx context.Context, todoID string, id string) error {
+	reminder, err := rs.todoReminder(ctx, todoID, id)
+	if err != nil {
+		return err
+	}
+
+	restore := rs.unqueue(id)
+	if err := rs.storage.DeleteReminder(ctx, id); err != nil {
+		restore()
+		return err
+	}
+	rs.wake()
+
+	reminder.State = "" // the reminder does not exist anymore
+	rs.record(ctx, model.ReminderEventCanceled, reminder)
+	return nil
 }
 
-func (rs *ReminderService) AddReminder(reminder Reminder) {
-	log.Printf("Adding reminder for task id '%s' with time '%v'\n", reminder.ID, reminder.ReminderTime)
-	rs.reminderChannel <- reminder
+// Cancel deletes the reminders of the todo, so they do not fire anymore, their history is kept
+func (rs *ReminderService) Cancel(ctx context.Context, todoID string) error {
+	return rs.cancel(ctx, todoID, true)
+}
+
+// Forget deletes the reminders of a deleted todo together with their history
+func (rs *ReminderService) Forget(ctx context.Context, todoID string) error {
+	if err := rs.cancel(ctx, todoID, false); err != nil {
+		return err
+	}
+	return rs.storage.DeleteReminderEvents(ctx, todoID)
+}
+
+func (rs *ReminderService) cancel(ctx context.Context, todoID string, record bool) error {
+	reminders, err := rs.storage.GetTodoReminders(ctx, todoID)
+	if err != nil {
+		return err
+	}
+
+	// dropped from the queue first, so the worker does not fire a reminder being deleted
+	rs.mu.Lock()
+	for _, reminder := range reminders {
+		rs.queue.Remove(reminder.ID)
+	}
+	rs.mu.Unlock()
+	rs.wake()
+
+	for _, reminder := range reminders {
+		err := rs.storage.DeleteReminder(ctx, reminder.ID)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if record {
+			reminder.State = "" // the reminder does not exist anymore
+			rs.record(ctx, model.ReminderEventCanceled, reminder)
+		}
+	}
+	if len(reminders) > 0 {
+		log.Printf("Canceled %d reminders of task id '%s'\n", len(reminders), todoID)
+	}
+	return nil
+}
+
+// FollowDueDate reschedules the reminders with a due offset to the new due date of the todo, each by
+// its own offset; reminders at a fixed time stay. A delivered reminder becomes pending again if its new time is still ahead
+func (rs *ReminderService) FollowDueDate(ctx context.Context, todoID string, due time.Time) error {
+	reminders, err := rs.storage.GetTodoReminders(ctx, todoID)
+	if err != nil {
+		return err
+	}
+
+	now := rs.clock()
+	for _, reminder := range reminders {
+		if reminder.DueOffset == "" || reminder.State == model.ReminderAcknowledged {
+			continue
+		}
+		offset, err := time.ParseDuration(reminder.DueOffset)
+		if err != nil {
+			log.Printf("Reminder %v has invalid due offset %q, it stays at its time: %v", reminder.ID, reminder.DueOffset, err)
+			continue
+		}
+		// storages keep milliseconds
+		at := due.Add(offset).UTC().Truncate(time.Millisecond)
+		if at.Equal(reminder.ReminderTime) || (reminder.State == model.ReminderDelivered && !at.After(now)) {
+			continue
+		}
+
+		if _, _, err := rs.move(ctx, reminder.ID, at); err != nil {
+			return err
+		}
+	}
+	rs.wake()
+	return nil
+}
+
+// Reschedule moves the reminders of the todo at a fixed time to at and returns them, reminders with
+// a due offset follow the due date instead. A delivered reminder becomes pending again, acknowledged ones stay
+func (rs *ReminderService) Reschedule(ctx context.Context, todoID string, at time.Time) ([]Reminder, error) {
+	reminders, err := rs.storage.GetTodoReminders(ctx, todoID)
+	if err != nil {
+		return nil, err
+	}
+
+	// storages keep milliseconds
+	at = at.UTC().Truncate(time.Millisecond)
+	rescheduled := []Reminder{}
+	for _, reminder := range reminders {
+		if reminder.DueOffset != "" || reminder.State == model.ReminderAcknowledged {
+			continue
+		}
+		if reminder.State == model.ReminderPending && reminder.ReminderTime.Equal(at) {
+			rescheduled = append(rescheduled, reminder)
+			continue
+		}
+		moved, ok, err := rs.move(ctx, reminder.ID, at)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rescheduled = append(rescheduled, moved)
+		}
+	}
+	rs.wake()
+	return rescheduled, nil
+}
+
+// moves the reminder to at and queues it again, it reports false if the reminder was deleted or
+// acknowledged in the meantime. The caller wakes the worker
+func (rs *ReminderService) move(ctx context.Context, id string, at time.Time) (Reminder, bool, error) {
+	restore := rs.unqueue(id)
+	moved, err := rs.storage.SnoozeReminder(ctx, id, at)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrConflict) {
+		restore()
+		return Reminder{}, false, nil
+	}
+	if err != nil {
+		restore()
+		return Reminder{}, false, err
+	}
+	rs.mu.Lock()
+	rs.queue.Push(moved)
+	rs.mu.Unlock()
+	rs.record(ctx, model.ReminderEventRescheduled, moved)
+	return moved, true, nil
+}
+
+// Rename changes the task name the pending reminders of the todo are sent with
+func (rs *ReminderService) Rename(ctx context.Context, todoID string, taskName string) error {
+	// the name is not a part of the history
+	return rs.updatePending(ctx, todoID, "", func(reminder *Reminder) bool {
+		if reminder.TaskName == taskName {
+			return false
+		}
+		reminder.TaskName = taskName
+		return true
+	})
+}
+
+// applies change to the pending reminders of the todo, change reports whether it changed the reminder.
+// Changed reminders are recorded as eventType, if it is set. Reminders fired in the meantime are not queued again
+func (rs *ReminderService) updatePending(ctx context.Context, todoID string, eventType model.ReminderEventType,
+	change func(reminder *Reminder) bool) error {
+	reminders, err := rs.storage.GetTodoReminders(ctx, todoID)
+	if err != nil {
+		return err
+	}
+
+	for _, reminder := range reminders {
+		if reminder.State != model.ReminderPending || !change(&reminder) {
+			continue
+		}
+		err := rs.storage.UpdateReminder(ctx, reminder)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue // canceled in the meantime
+		}
+		if err != nil {
+			return err
+		}
+
+		rs.mu.Lock()
+		if _, ok := rs.queue.Get(reminder.ID); ok {
+			rs.queue.Push(reminder)
+		}
+		rs.mu.Unlock()
+		if eventType != "" {
+			rs.record(ctx, eventType, reminder)
+		}
+	}
+	rs.wake()
+	return nil
+}
+
+// HandleTodoEvent keeps the reminders in line with their todo: a new todo with a reminder time gets
+// its reminder, reminders of a done todo are canceled, the others are renamed and follow the due date,
+// reminders of a deleted todo are forgotten. Events may be delivered more than once
+func (rs *ReminderService) HandleTodoEvent(ctx context.Context, event events.Event) error {
+	switch event.Type {
+	case events.TodoCreated:
+		return rs.addTodoReminder(ctx, event)
+	case events.TodoDeleted:
+		return rs.Forget(ctx, event.TodoID)
+	case events.TodoUpdated:
+		todo := event.Todo
+		if todo.Status == model.Done {
+			return rs.Cancel(ctx, todo.ID)
+		}
+		if err := rs.Rename(ctx, todo.ID, todo.Title); err != nil {
+			return err
+		}
+		if todo.DueAt == nil {
+			return nil // reminders with a due offset stay where they are
+		}
+		return rs.FollowDueDate(ctx, todo.ID, *todo.DueAt)
+	}
+	return nil
+}
+
+// adds the reminder of a new todo with a reminder time. The reminder gets the ID of the event,
+// so a repeated delivery finds it instead of adding a second one
+func (rs *ReminderService) addTodoReminder(ctx context.Context, event events.Event) error {
+	todo := event.Todo
+	if todo.ReminderAt == nil {
+		return nil
+	}
+	reminder := Reminder{
+		ID:           event.ID,
+		TodoID:       todo.ID,
+		TaskName:     todo.Title,
+		ReminderTime: *todo.ReminderAt,
+		TimeZone:     todo.TimeZone,
+	}
+	// the todo service resolved the reminder time just before the todo was created,
+	// the recurrence does not depend on the reference time
+	if loc, err := time.LoadLocation(todo.TimeZone); err == nil {
+		if resolved, err := timeparse.ParseAt(todo.ReminderTime, todo.CreatedAt, loc); err == nil {
+			reminder.Recurrence = resolved.Recurrence
+		}
+	}
+
+	_, err := rs.AddReminder(ctx, reminder)
+	if errors.Is(err, ErrConflict) {
+		return nil // added by an earlier delivery
+	}
+	return err
 }
 
+// StopWorker stops the worker and waits until it returned, reminders due meanwhile fire after the next start
 func (rs *ReminderService) StopWorker() {
 	close(rs.stopChannel)
+	rs.stopped.Wait()
 }
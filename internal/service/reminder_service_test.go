@@ -0,0 +1,609 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"toDoList/internal/events"
+	"toDoList/internal/model"
+	"toDoList/internal/storage"
+)
+
+// a clock the test moves forward
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// returns a reminder service on the memory storage with a clock at 2024-03-01 09:00 UTC, a Friday
+func newReminderTest(store storage.ReminderStorage, buffer int) (*ReminderService, chan model.ReminderNotification, *testClock) {
+	clock := &testClock{now: time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)}
+	notifications := make(chan model.ReminderNotification, buffer)
+	return NewReminderService(store, notifications, clock.Now), notifications, clock
+}
+
+func mustAddReminder(t *testing.T, rs *ReminderService, reminder Reminder) Reminder {
+	t.Helper()
+	if reminder.TodoID == "" {
+		reminder.TodoID = "1"
+	}
+	if reminder.TaskName == "" {
+		reminder.TaskName = "task"
+	}
+	added, err := rs.AddReminder(context.Background(), reminder)
+	if err != nil {
+		t.Fatalf("AddReminder failed: %v", err)
+	}
+	return added
+}
+
+// returns the IDs of the notifications sent so far
+func received(notifications chan model.ReminderNotification) []string {
+	var ids []string
+	for {
+		select {
+		case notification := <-notifications:
+			ids = append(ids, notification.ReminderID)
+		default:
+			return ids
+		}
+	}
+}
+
+// returns the types of the recorded transitions of the todo
+func historyTypes(t *testing.T, rs *ReminderService, todoID string) string {
+	t.Helper()
+	history, err := rs.History(context.Background(), todoID)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	var types []string
+	for _, event := range history {
+		types = append(types, string(event.Type))
+	}
+	return strings.Join(types, ",")
+}
+
+func mustGetReminder(t *testing.T, store storage.ReminderStorage, id string) Reminder {
+	t.Helper()
+	reminder, err := store.GetReminder(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetReminder failed: %v", err)
+	}
+	return reminder
+}
+
+func TestFireDueSendsEachReminderOnce(t *testing.T) {
+	store := storage.NewMemoryDb()
+	rs, notifications, clock := newReminderTest(store, 10)
+	first := mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now().Add(time.Minute)})
+	second := mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now().Add(2 * time.Minute)})
+
+	rs.fireDue()
+	if ids := received(notifications); len(ids) != 0 {
+		t.Fatalf("fireDue before the reminders are due sent %v", ids)
+	}
+
+	clock.Add(time.Minute)
+	rs.fireDue()
+	rs.fireDue()
+	if ids := received(notifications); len(ids) != 1 || ids[0] != first.ID {
+		t.Fatalf("fireDue sent %v, want only %v once", ids, first.ID)
+	}
+	if got := mustGetReminder(t, store, first.ID); got.State != model.ReminderDelivered {
+		t.Errorf("fired reminder is %v, want delivered", got.State)
+	}
+	if got := mustGetReminder(t, store, second.ID); got.State != model.ReminderPending {
+		t.Errorf("reminder that is not due is %v, want pending", got.State)
+	}
+
+	clock.Add(time.Hour)
+	rs.fireDue()
+	if ids := received(notifications); len(ids) != 1 || ids[0] != second.ID {
+		t.Errorf("fireDue sent %v, want only %v", ids, second.ID)
+	}
+	if got := historyTypes(t, rs, "1"); got != "created,created,delivered,delivered" {
+		t.Errorf("history is %v", got)
+	}
+}
+
+func TestFireDueDoesNotBlockOnFullChannel(t *testing.T) {
+	store := storage.NewMemoryDb()
+	rs, notifications, clock := newReminderTest(store, 1)
+	var ids []string
+	for i := 1; i <= 3; i++ {
+		ids = append(ids, mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now().Add(time.Duration(i) * time.Second)}).ID)
+	}
+	clock.Add(time.Minute)
+
+	done := make(chan struct{})
+	go func() {
+		rs.fireDue() // nobody reads the channel
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fireDue blocked on a full notification channel")
+	}
+
+	// the reminders that did not fit stay pending and are retried soon
+	if got := mustGetReminder(t, store, ids[1]); got.State != model.ReminderPending {
+		t.Errorf("reminder that did not fit is %v, want pending", got.State)
+	}
+	if wait := rs.nextWait(); wait != reminderRetryDelay {
+		t.Errorf("nextWait with due reminders is %v, want the retry delay %v", wait, reminderRetryDelay)
+	}
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		got = append(got, received(notifications)...)
+		rs.fireDue()
+	}
+	if strings.Join(got, ",") != strings.Join(ids, ",") {
+		t.Errorf("reminders were sent as %v, want %v in order, each once", got, ids)
+	}
+}
+
+func TestFireDueMovesRecurringReminder(t *testing.T) {
+	store := storage.NewMemoryDb()
+	rs, notifications, clock := newReminderTest(store, 10)
+	daily := mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now(), Recurrence: "every day at 09:00", TimeZone: "UTC"})
+
+	rs.fireDue()
+	rs.fireDue()
+	if ids := received(notifications); len(ids) != 1 {
+		t.Fatalf("recurring reminder was sent %d times, want once", len(ids))
+	}
+	got := mustGetReminder(t, store, daily.ID)
+	if want := clock.Now().Add(24 * time.Hour); got.State != model.ReminderPending || !got.ReminderTime.Equal(want) {
+		t.Errorf("recurring reminder after firing is %v at %v, want pending at %v", got.State, got.ReminderTime, want)
+	}
+
+	// occurrences missed while the server was down fire once
+	clock.Add(72 * time.Hour)
+	rs.fireDue()
+	if ids := received(notifications); len(ids) != 1 {
+		t.Errorf("recurring reminder three days late was sent %d times, want once", len(ids))
+	}
+	got = mustGetReminder(t, store, daily.ID)
+	if want := clock.Now().Add(24 * time.Hour); !got.ReminderTime.Equal(want) {
+		t.Errorf("recurring reminder moved to %v, want %v", got.ReminderTime, want)
+	}
+}
+
+func TestReminderWorkerStartsAndStops(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryDb()
+	notifications := make(chan model.ReminderNotification, 10)
+	rs := NewReminderService(store, notifications, nil)
+	// due while the server was down
+	overdue, err := store.AddReminder(ctx, Reminder{TodoID: "1", TaskName: "task", ReminderTime: time.Now().Add(-time.Hour),
+		State: model.ReminderPending})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rs.StartWorker(ctx); err != nil {
+		t.Fatalf("StartWorker failed: %v", err)
+	}
+	select {
+	case notification := <-notifications:
+		if notification.ReminderID != overdue.ID {
+			t.Errorf("worker sent %v, want the overdue reminder %v", notification.ReminderID, overdue.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker did not fire the overdue reminder")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		rs.StopWorker()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("StopWorker did not return")
+	}
+
+	mustAddReminder(t, rs, Reminder{ReminderTime: time.Now()})
+	select {
+	case notification := <-notifications:
+		t.Errorf("stopped worker sent %v", notification.ReminderID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// fails the changes of reminders while fail is set
+type failingReminders struct {
+	storage.ReminderStorage
+	fail bool
+}
+
+func (f *failingReminders) SetReminderState(ctx context.Context, id string, from, to model.ReminderState) error {
+	if f.fail {
+		return storage.ErrUnavailable
+	}
+	return f.ReminderStorage.SetReminderState(ctx, id, from, to)
+}
+
+func (f *failingReminders) DeleteReminder(ctx context.Context, id string) error {
+	if f.fail {
+		return storage.ErrUnavailable
+	}
+	return f.ReminderStorage.DeleteReminder(ctx, id)
+}
+
+func TestChangedRemindersLeaveTheQueue(t *testing.T) {
+	ctx := context.Background()
+	store := &failingReminders{ReminderStorage: storage.NewMemoryDb()}
+	rs, notifications, clock := newReminderTest(store, 10)
+	acked := mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now()})
+	snoozed := mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now()})
+	deleted := mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now()})
+
+	if _, err := rs.Acknowledge(ctx, "1", acked.ID); err != nil {
+		t.Fatalf("Acknowledge failed: %v", err)
+	}
+	if _, err := rs.Snooze(ctx, "1", snoozed.ID, Snooze{Duration: "10m"}); err != nil {
+		t.Fatalf("Snooze failed: %v", err)
+	}
+	if err := rs.Delete(ctx, "1", deleted.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	rs.fireDue()
+	if ids := received(notifications); len(ids) != 0 {
+		t.Errorf("fireDue sent %v, acknowledged, snoozed and deleted reminders must not fire", ids)
+	}
+	clock.Add(10 * time.Minute)
+	rs.fireDue()
+	if ids := received(notifications); len(ids) != 1 || ids[0] != snoozed.ID {
+		t.Errorf("fireDue after the snooze sent %v, want %v", ids, snoozed.ID)
+	}
+}
+
+func TestFailedChangesRestoreTheQueue(t *testing.T) {
+	ctx := context.Background()
+	store := &failingReminders{ReminderStorage: storage.NewMemoryDb()}
+	rs, notifications, clock := newReminderTest(store, 10)
+	acked := mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now()})
+	deleted := mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now()})
+
+	store.fail = true
+	if _, err := rs.Acknowledge(ctx, "1", acked.ID); !errors.Is(err, storage.ErrUnavailable) {
+		t.Errorf("Acknowledge with a failing storage returned %v", err)
+	}
+	if err := rs.Delete(ctx, "1", deleted.ID); !errors.Is(err, storage.ErrUnavailable) {
+		t.Errorf("Delete with a failing storage returned %v", err)
+	}
+	store.fail = false
+
+	// both are still queued
+	rs.fireDue()
+	if ids := received(notifications); len(ids) != 2 {
+		t.Errorf("fireDue sent %v, want both reminders whose change failed", ids)
+	}
+}
+
+func TestWorkerFiresDueRemindersInOrderWithoutPolling(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	clockCalls := 0
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		clockCalls++
+		return time.Now()
+	}
+	calls := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return clockCalls
+	}
+	notifications := make(chan model.ReminderNotification, 10)
+	rs := NewReminderService(storage.NewMemoryDb(), notifications, clock)
+	if err := rs.StartWorker(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer rs.StopWorker()
+
+	start := time.Now()
+	var want []string
+	for _, delay := range []time.Duration{60, 20, 40} {
+		reminder := mustAddReminder(t, rs, Reminder{TaskName: delay.String(), ReminderTime: start.Add(delay * time.Millisecond)})
+		want = append(want, reminder.ID)
+	}
+	want = []string{want[1], want[2], want[0]}
+
+	var got []string
+	for range want {
+		select {
+		case notification := <-notifications:
+			got = append(got, notification.ReminderID)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("worker sent %v, want %v", got, want)
+		}
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("worker sent %v, want the reminders ordered by time %v", got, want)
+	}
+
+	// the timer waits for the earliest reminder, a polling worker would look at the clock all the time
+	if n := calls(); n > 50 {
+		t.Errorf("worker read the clock %d times for 3 reminders", n)
+	}
+	idle := calls()
+	time.Sleep(100 * time.Millisecond)
+	if n := calls() - idle; n != 0 {
+		t.Errorf("idle worker read the clock %d times", n)
+	}
+}
+
+func TestWorkerWakesUpForReminderAddedToEmptyQueue(t *testing.T) {
+	notifications := make(chan model.ReminderNotification, 10)
+	rs := NewReminderService(storage.NewMemoryDb(), notifications, nil)
+	if wait := rs.nextWait(); wait != time.Hour {
+		t.Errorf("nextWait with an empty queue is %v, want an hour", wait)
+	}
+	if err := rs.StartWorker(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer rs.StopWorker()
+	time.Sleep(20 * time.Millisecond) // the worker sleeps for an hour
+
+	added := mustAddReminder(t, rs, Reminder{ReminderTime: time.Now().Add(20 * time.Millisecond)})
+	if wait := rs.nextWait(); wait <= 0 || wait > 20*time.Millisecond {
+		t.Errorf("nextWait is %v, want the time until the new reminder", wait)
+	}
+	select {
+	case notification := <-notifications:
+		if notification.ReminderID != added.ID {
+			t.Errorf("worker sent %v, want %v", notification.ReminderID, added.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker was not woken up for the new reminder")
+	}
+}
+
+func TestRescheduleMovesRemindersAtFixedTime(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryDb()
+	rs, notifications, clock := newReminderTest(store, 10)
+	pending := mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now().Add(time.Hour)})
+	delivered := mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now()})
+	acked := mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now().Add(time.Hour)})
+	offset := mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now().Add(time.Hour), DueOffset: "-1h"})
+	rs.fireDue()
+	received(notifications)
+	if _, err := rs.Acknowledge(ctx, "1", acked.ID); err != nil {
+		t.Fatalf("Acknowledge failed: %v", err)
+	}
+
+	at := clock.Now().Add(2 * time.Hour)
+	rescheduled, err := rs.Reschedule(ctx, "1", at)
+	if err != nil {
+		t.Fatalf("Reschedule failed: %v", err)
+	}
+	if len(rescheduled) != 2 || rescheduled[0].ID != delivered.ID || rescheduled[1].ID != pending.ID {
+		t.Fatalf("Reschedule returned %+v, want the delivered and the pending reminder", rescheduled)
+	}
+	for _, id := range []string{pending.ID, delivered.ID} {
+		if got := mustGetReminder(t, store, id); !got.ReminderTime.Equal(at) || got.State != model.ReminderPending {
+			t.Errorf("rescheduled reminder is %v at %v, want pending at %v", got.State, got.ReminderTime, at)
+		}
+	}
+	for _, id := range []string{acked.ID, offset.ID} {
+		if got := mustGetReminder(t, store, id); !got.ReminderTime.Equal(clock.Now().Add(time.Hour)) {
+			t.Errorf("reminder %v moved to %v, acknowledged reminders and reminders with a due offset stay", id, got.ReminderTime)
+		}
+	}
+
+	// the old time of the pending reminder has passed, it fires at the new one only
+	clock.Add(time.Hour)
+	rs.fireDue()
+	if ids := received(notifications); len(ids) != 1 || ids[0] != offset.ID {
+		t.Errorf("fireDue after an hour sent %v, want only %v", ids, offset.ID)
+	}
+	clock.Add(time.Hour)
+	rs.fireDue()
+	if ids := received(notifications); len(ids) != 2 {
+		t.Errorf("fireDue at the new time sent %v, want both rescheduled reminders", ids)
+	}
+}
+
+// returns an event of the relay for the todo, id is the ID of its outbox event
+func todoEvent(id string, eventType events.Type, todo model.ToDo) events.Event {
+	return events.Event{ID: id, Type: eventType, TodoID: todo.ID, Todo: todo}
+}
+
+func TestHandleTodoEventCancelsAndRenamesReminders(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryDb()
+	rs, _, clock := newReminderTest(store, 10)
+	at := clock.Now().Add(time.Hour)
+	todo := model.ToDo{ID: "1", Title: "task", Status: model.Created, ReminderAt: &at, TimeZone: "UTC"}
+
+	// the relay delivers an event again when a later subscriber failed
+	created := todoEvent("e1", events.TodoCreated, todo)
+	for i := 0; i < 2; i++ {
+		if err := rs.HandleTodoEvent(ctx, created); err != nil {
+			t.Fatalf("HandleTodoEvent(%v) failed: %v", created.Type, err)
+		}
+	}
+	reminders, err := rs.TodoReminders(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reminders) != 1 || reminders[0].ID != "e1" || !reminders[0].ReminderTime.Equal(at) {
+		t.Fatalf("reminders after a repeated todo.created are %+v, want one", reminders)
+	}
+
+	todo.Title = "renamed"
+	updated := todoEvent("e2", events.TodoUpdated, todo)
+	for i := 0; i < 2; i++ {
+		if err := rs.HandleTodoEvent(ctx, updated); err != nil {
+			t.Fatalf("HandleTodoEvent(%v) failed: %v", updated.Type, err)
+		}
+	}
+	if got := mustGetReminder(t, store, "e1"); got.TaskName != "renamed" || got.State != model.ReminderPending {
+		t.Errorf("reminder after the retitle is %v %q, want pending with the new title", got.State, got.TaskName)
+	}
+	if got := historyTypes(t, rs, "1"); got != "created" {
+		t.Errorf("history after repeated events is %v, want only created", got)
+	}
+
+	todo.Status = model.Done
+	done := todoEvent("e3", events.TodoUpdated, todo)
+	for i := 0; i < 2; i++ {
+		if err := rs.HandleTodoEvent(ctx, done); err != nil {
+			t.Fatalf("HandleTodoEvent(%v) failed: %v", done.Type, err)
+		}
+	}
+	if reminders, err := rs.TodoReminders(ctx, "1"); err != nil || len(reminders) != 0 {
+		t.Errorf("reminders of a done todo are %+v (%v), want none", reminders, err)
+	}
+	if got := historyTypes(t, rs, "1"); got != "created,canceled" {
+		t.Errorf("history of a done todo is %v, want created,canceled", got)
+	}
+}
+
+func TestHandleTodoEventForgetsRemindersOfDeletedTodo(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryDb()
+	rs, notifications, clock := newReminderTest(store, 10)
+	mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now().Add(time.Minute)})
+	other := mustAddReminder(t, rs, Reminder{TodoID: "2", ReminderTime: clock.Now().Add(time.Minute)})
+
+	deleted := todoEvent("e1", events.TodoDeleted, model.ToDo{ID: "1"})
+	for i := 0; i < 2; i++ {
+		if err := rs.HandleTodoEvent(ctx, deleted); err != nil {
+			t.Fatalf("HandleTodoEvent(%v) failed: %v", deleted.Type, err)
+		}
+	}
+	if reminders, err := rs.TodoReminders(ctx, "1"); err != nil || len(reminders) != 0 {
+		t.Errorf("reminders of a deleted todo are %+v (%v), want none", reminders, err)
+	}
+	if got := historyTypes(t, rs, "1"); got != "" {
+		t.Errorf("history of a deleted todo is %v, want none", got)
+	}
+
+	clock.Add(time.Minute)
+	rs.fireDue()
+	if ids := received(notifications); len(ids) != 1 || ids[0] != other.ID {
+		t.Errorf("fireDue sent %v, want only the reminder of the other todo", ids)
+	}
+}
+
+func TestHandleTodoEventMovesRemindersWithDueOffset(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryDb()
+	rs, _, clock := newReminderTest(store, 10)
+	due := clock.Now().Add(2 * time.Hour)
+	offset := mustAddReminder(t, rs, Reminder{ReminderTime: due.Add(-30 * time.Minute), DueOffset: "-30m"})
+	fixed := mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now().Add(time.Hour)})
+
+	due = due.Add(24 * time.Hour)
+	updated := todoEvent("e1", events.TodoUpdated, model.ToDo{ID: "1", Title: "task", Status: model.Created, DueAt: &due})
+	for i := 0; i < 2; i++ {
+		if err := rs.HandleTodoEvent(ctx, updated); err != nil {
+			t.Fatalf("HandleTodoEvent(%v) failed: %v", updated.Type, err)
+		}
+	}
+	if got := mustGetReminder(t, store, offset.ID); !got.ReminderTime.Equal(due.Add(-30 * time.Minute)) {
+		t.Errorf("reminder with a due offset is at %v, want 30 minutes before %v", got.ReminderTime, due)
+	}
+	if got := mustGetReminder(t, store, fixed.ID); !got.ReminderTime.Equal(fixed.ReminderTime) {
+		t.Errorf("reminder at a fixed time moved to %v", got.ReminderTime)
+	}
+	if got := historyTypes(t, rs, "1"); got != "created,created,rescheduled" {
+		t.Errorf("history after a repeated due date change is %v, want one rescheduled", got)
+	}
+}
+
+func TestSnoozedReminderFiresAgain(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryDb()
+	rs, notifications, clock := newReminderTest(store, 10)
+	reminder := mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now()})
+	rs.fireDue()
+	received(notifications)
+
+	snoozed, err := rs.Snooze(ctx, "1", reminder.ID, Snooze{Preset: "short"})
+	if err != nil {
+		t.Fatalf("Snooze failed: %v", err)
+	}
+	if want := clock.Now().Add(10 * time.Minute); snoozed.State != model.ReminderPending || !snoozed.ReminderTime.Equal(want) {
+		t.Fatalf("snoozed reminder is %v at %v, want pending at %v", snoozed.State, snoozed.ReminderTime, want)
+	}
+	clock.Add(10 * time.Minute)
+	rs.fireDue()
+	if ids := received(notifications); len(ids) != 1 || ids[0] != reminder.ID {
+		t.Errorf("fireDue after the snooze sent %v, want %v again", ids, reminder.ID)
+	}
+
+	if _, err := rs.Acknowledge(ctx, "1", reminder.ID); err != nil {
+		t.Fatalf("Acknowledge of a delivered reminder failed: %v", err)
+	}
+	if _, err := rs.Snooze(ctx, "1", reminder.ID, Snooze{Duration: "5m"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Snooze of an acknowledged reminder returned %v, want a conflict", err)
+	}
+	if got := historyTypes(t, rs, "1"); got != "created,delivered,snoozed,delivered,acknowledged" {
+		t.Errorf("history is %v", got)
+	}
+}
+
+func TestFireDueRendersMessageAndUsesChannel(t *testing.T) {
+	store := storage.NewMemoryDb()
+	rs, notifications, clock := newReminderTest(store, 10)
+	templated := mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now(), TaskName: "report", Message: "{{.TaskName}} is due soon"})
+	mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now(), Channel: model.ChannelLog})
+	plain := mustAddReminder(t, rs, Reminder{ReminderTime: clock.Now().Add(time.Minute), TaskName: "call"})
+
+	clock.Add(time.Minute)
+	rs.fireDue()
+	var messages []string
+	for len(notifications) > 0 {
+		notification := <-notifications
+		messages = append(messages, notification.ReminderID+": "+notification.Message)
+	}
+	// the log reminder is written to the log only
+	want := templated.ID + ": report is due soon," + plain.ID + ": You need to do this task: call"
+	if got := strings.Join(messages, ","); got != want {
+		t.Errorf("notifications are %v, want %v", got, want)
+	}
+	if got := historyTypes(t, rs, "1"); got != "created,created,created,delivered,delivered,delivered" {
+		t.Errorf("history is %v, the log reminder is delivered too", got)
+	}
+}
+
+func TestAddReminderRejectsInvalidChannelAndMessage(t *testing.T) {
+	rs, _, clock := newReminderTest(storage.NewMemoryDb(), 10)
+	for _, reminder := range []Reminder{
+		{TodoID: "1", ReminderTime: clock.Now(), Channel: "email"},
+		{TodoID: "1", ReminderTime: clock.Now(), Message: "{{.TaskName"},
+		{TodoID: "1", ReminderTime: clock.Now(), Message: "{{.Missing}}"},
+	} {
+		if _, err := rs.AddReminder(context.Background(), reminder); !errors.Is(err, ErrValidation) {
+			t.Errorf("AddReminder(channel %q, message %q) returned %v, want a validation error", reminder.Channel, reminder.Message, err)
+		}
+	}
+	if got := historyTypes(t, rs, "1"); got != "" {
+		t.Errorf("history is %v, rejected reminders must not be recorded", got)
+	}
+}
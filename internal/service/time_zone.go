@@ -0,0 +1,27 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// loadTimeZone returns the IANA time zone, an empty name gives def
+func loadTimeZone(name string, def *time.Location) (*time.Location, error) {
+	if name == "" {
+		return def, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %w", name, ErrValidation)
+	}
+	return loc, nil
+}
+
+// truncateTime returns a copy of the time with the precision every storage keeps (milliseconds)
+func truncateTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	truncated := t.UTC().Truncate(time.Millisecond)
+	return &truncated
+}
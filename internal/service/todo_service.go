@@ -1,59 +1,219 @@
 package service
 
 import (
-	"errors"
+	"context"
+	"fmt"
+	"time"
 	"toDoList/internal/model"
 	"toDoList/internal/storage"
+	"toDoList/internal/timeparse"
 )
 
 type TodoService interface {
-	GetAllTodos() ([]model.ToDo, error)
-	GetTodoById(id string) (model.ToDo, error)
-	GetTodoImageById(id string) (model.ToDo, error)
-	AddTodo(todo model.ToDo) error
-	UpdateTodo(id string, todo model.ToDo) error
-	UpdateTodoImage(id string, imagePath string) error
-	DeleteTodo(id string) error
+	GetAllTodos(ctx context.Context, opts model.ListOptions) (model.TodoPage, error)
+	GetTodoById(ctx context.Context, id string) (model.ToDo, error)
+	GetTodoImageById(ctx context.Context, id string) (model.ToDo, error)
+	AddTodo(ctx context.Context, todo model.ToDo) (model.ToDo, error)
+	UpdateTodo(ctx context.Context, id string, todo model.ToDo) (model.ToDo, error)
+	PatchTodo(ctx context.Context, id string, patch model.ToDoPatch, version int64) (model.ToDo, error)
+	UpdateTodoImage(ctx context.Context, id string, imagePath string) error
+	DeleteTodo(ctx context.Context, id string, version int64) error
+	// AddReminder adds another reminder to the todo
+	AddReminder(ctx context.Context, todoID string, spec model.ReminderSpec) (Reminder, error)
+	// RescheduleReminders moves the reminders of the todo at a fixed time to at, read in the time zone of the todo
+	RescheduleReminders(ctx context.Context, todoID string, at string) ([]Reminder, error)
 }
 
 type todoService struct {
-	storage storage.Storage
+	storage   storage.Storage
+	reminders *ReminderService  // Schedules reminders added to todos
+	outbox    *OutboxRelay      // Publishes the events the storage writes with every change
+	timeZone  *time.Location    // Reminder times of todos without a time zone are read in it
+	parser    *timeparse.Parser // Resolves reminder times against the current time of its clock
 }
 
-func NewTodoService(storage storage.Storage) TodoService {
-	return &todoService{storage: storage}
+// NewTodoService creates the service, reminder times are resolved against the time of clock, nil is time.Now
+func NewTodoService(storage storage.Storage, reminders *ReminderService, outbox *OutboxRelay, timeZone *time.Location,
+	clock timeparse.Clock) TodoService {
+	return &todoService{storage: storage, reminders: reminders, outbox: outbox, timeZone: timeZone, parser: timeparse.NewParser(clock)}
 }
 
-func (s *todoService) GetAllTodos() ([]model.ToDo, error) {
-	return s.storage.GetTodos()
+func (s *todoService) GetAllTodos(ctx context.Context, opts model.ListOptions) (model.TodoPage, error) {
+	return s.storage.GetTodos(ctx, opts)
 }
 
-func (s *todoService) GetTodoById(id string) (model.ToDo, error) {
-	return s.storage.GetTodoById(id)
+func (s *todoService) GetTodoById(ctx context.Context, id string) (model.ToDo, error) {
+	return s.storage.GetTodoById(ctx, id)
 }
 
-func (s *todoService) GetTodoImageById(id string) (model.ToDo, error) {
-	return s.storage.GetTodoImageById(id)
+func (s *todoService) GetTodoImageById(ctx context.Context, id string) (model.ToDo, error) {
+	return s.storage.GetTodoImageById(ctx, id)
 }
 
-func (s *todoService) AddTodo(todo model.ToDo) error {
+func (s *todoService) AddTodo(ctx context.Context, todo model.ToDo) (model.ToDo, error) {
 	if !model.IsValidStatus(todo.Status) {
-		return errors.New("invalid status")
+		return model.ToDo{}, fmt.Errorf("invalid status %q: %w", todo.Status, ErrValidation)
 	}
-	return s.storage.AddTodo(todo)
+	loc, err := loadTimeZone(todo.TimeZone, s.timeZone)
+	if err != nil {
+		return model.ToDo{}, err
+	}
+
+	todo.DueAt = truncateTime(todo.DueAt)
+	todo.ReminderAt = nil // resolved here, never taken from the client
+	if todo.ReminderTime == "" {
+		return s.addTodo(ctx, todo)
+	}
+
+	// the reminder is added by the reminder service when todo.created is published,
+	// so it exists exactly if the todo was stored
+	now := s.parser.Now()
+	resolved, err := timeparse.ParseAt(todo.ReminderTime, now, loc)
+	if err != nil {
+		return model.ToDo{}, fmt.Errorf("invalid reminder time %q: %v: %w", todo.ReminderTime, err, ErrValidation)
+	}
+	if !resolved.At.After(now) {
+		return model.ToDo{}, fmt.Errorf("reminder time %v is in the past: %w", resolved.At.Format(time.RFC3339), ErrValidation)
+	}
+	// storages keep milliseconds
+	at := resolved.At.UTC().Truncate(time.Millisecond)
+	todo.ReminderAt = &at
+	todo.TimeZone = loc.String()
+	return s.addTodo(ctx, todo)
+}
+
+func (s *todoService) addTodo(ctx context.Context, todo model.ToDo) (model.ToDo, error) {
+	created, err := s.storage.AddTodo(ctx, todo)
+	if err != nil {
+		return model.ToDo{}, err
+	}
+	s.outbox.Flush(ctx, created.ID)
+	return created, nil
 }
 
-func (s *todoService) UpdateTodo(id string, todo model.ToDo) error {
+// UpdateTodo fails with ErrConflict when todo.Version is set and the todo was changed since
+func (s *todoService) UpdateTodo(ctx context.Context, id string, todo model.ToDo) (model.ToDo, error) {
 	if !model.IsValidStatus(todo.Status) {
-		return errors.New("invalid status")
+		return model.ToDo{}, fmt.Errorf("invalid status %q: %w", todo.Status, ErrValidation)
 	}
-	return s.storage.UpdateTodo(id, todo)
+	todo.DueAt = truncateTime(todo.DueAt)
+	updated, err := s.storage.UpdateTodo(ctx, id, todo)
+	if err != nil {
+		return model.ToDo{}, err
+	}
+	s.outbox.Flush(ctx, id)
+	return updated, nil
 }
 
-func (s *todoService) UpdateTodoImage(id string, imagePath string) error {
-	return s.storage.UpdateTodoImage(id, imagePath)
+// PatchTodo changes only the fields set in the patch, an empty patch returns the todo unchanged
+func (s *todoService) PatchTodo(ctx context.Context, id string, patch model.ToDoPatch, version int64) (model.ToDo, error) {
+	if patch.Status != nil && !model.IsValidStatus(*patch.Status) {
+		return model.ToDo{}, fmt.Errorf("invalid status %q: %w", *patch.Status, ErrValidation)
+	}
+	if patch.IsEmpty() {
+		return s.storage.GetTodoById(ctx, id)
+	}
+	if patch.DueAt != nil {
+		dueAt := truncateTime(*patch.DueAt)
+		patch.DueAt = &dueAt
+	}
+	updated, err := s.storage.PatchTodo(ctx, id, patch, version)
+	if err != nil {
+		return model.ToDo{}, err
+	}
+	s.outbox.Flush(ctx, id)
+	return updated, nil
 }
 
-func (s *todoService) DeleteTodo(id string) error {
-	return s.storage.DeleteTodo(id)
+func (s *todoService) UpdateTodoImage(ctx context.Context, id string, imagePath string) error {
+	if err := s.storage.UpdateTodoImage(ctx, id, imagePath); err != nil {
+		return err
+	}
+	s.outbox.Flush(ctx, id)
+	return nil
+}
+
+// DeleteTodo fails with ErrConflict when version is set and the todo was changed since
+func (s *todoService) DeleteTodo(ctx context.Context, id string, version int64) error {
+	if err := s.storage.DeleteTodo(ctx, id, version); err != nil {
+		return err
+	}
+	s.outbox.Flush(ctx, id)
+	return nil
+}
+
+// AddReminder resolves the time of the reminder, either At in the time zone of the todo
+// or DueOffset from its due date, and schedules it
+func (s *todoService) AddReminder(ctx context.Context, todoID string, spec model.ReminderSpec) (Reminder, error) {
+	todo, err := s.storage.GetTodoById(ctx, todoID)
+	if err != nil {
+		return Reminder{}, err
+	}
+	if todo.Status == model.Done {
+		return Reminder{}, fmt.Errorf("todo %v is done: %w", todoID, ErrValidation)
+	}
+	loc, err := loadTimeZone(todo.TimeZone, s.timeZone)
+	if err != nil {
+		return Reminder{}, err
+	}
+
+	reminder := Reminder{
+		TodoID:    todo.ID,
+		TaskName:  todo.Title,
+		TimeZone:  loc.String(),
+		DueOffset: spec.DueOffset,
+		Channel:   spec.Channel,
+		Message:   spec.Message,
+	}
+	now := s.parser.Now()
+	switch {
+	case spec.At != "" && spec.DueOffset != "":
+		return Reminder{}, fmt.Errorf("set either at or due_offset: %w", ErrValidation)
+	case spec.At != "":
+		resolved, err := timeparse.ParseAt(spec.At, now, loc)
+		if err != nil {
+			return Reminder{}, fmt.Errorf("invalid reminder time %q: %v: %w", spec.At, err, ErrValidation)
+		}
+		reminder.ReminderTime = resolved.At
+		reminder.Recurrence = resolved.Recurrence
+	case spec.DueOffset != "":
+		offset, err := time.ParseDuration(spec.DueOffset)
+		if err != nil {
+			return Reminder{}, fmt.Errorf("invalid due offset %q, use a duration like -30m: %w", spec.DueOffset, ErrValidation)
+		}
+		if todo.DueAt == nil {
+			return Reminder{}, fmt.Errorf("todo %v has no due date: %w", todoID, ErrValidation)
+		}
+		reminder.ReminderTime = todo.DueAt.Add(offset)
+	default:
+		return Reminder{}, fmt.Errorf("set at or due_offset: %w", ErrValidation)
+	}
+	if !reminder.ReminderTime.After(now) {
+		return Reminder{}, fmt.Errorf("reminder time %v is in the past: %w", reminder.ReminderTime.In(loc).Format(time.RFC3339), ErrValidation)
+	}
+	return s.reminders.AddReminder(ctx, reminder)
+}
+
+func (s *todoService) RescheduleReminders(ctx context.Context, todoID string, at string) ([]Reminder, error) {
+	todo, err := s.storage.GetTodoById(ctx, todoID)
+	if err != nil {
+		return nil, err
+	}
+	if todo.Status == model.Done {
+		return nil, fmt.Errorf("todo %v is done: %w", todoID, ErrValidation)
+	}
+	loc, err := loadTimeZone(todo.TimeZone, s.timeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.parser.Now()
+	resolved, err := timeparse.ParseAt(at, now, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reminder time %q: %v: %w", at, err, ErrValidation)
+	}
+	if !resolved.At.After(now) {
+		return nil, fmt.Errorf("reminder time %v is in the past: %w", resolved.At.In(loc).Format(time.RFC3339), ErrValidation)
+	}
+	return s.reminders.Reschedule(ctx, todoID, resolved.At)
 }
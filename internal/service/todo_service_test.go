@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"toDoList/internal/events"
+	"toDoList/internal/model"
+	"toDoList/internal/storage"
+)
+
+func TestAddTodoResolvesReminderTimeWithClock(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, berlin) // Friday
+	store := storage.NewMemoryDb()
+	relay := NewOutboxRelay(store, events.NewBus(), 10, time.Hour)
+	todos := NewTodoService(store, nil, relay, time.UTC, func() time.Time { return now })
+
+	tests := []struct {
+		reminderTime string
+		want         time.Time
+	}{
+		{"tomorrow 9am", time.Date(2024, 3, 2, 9, 0, 0, 0, berlin)},
+		{"next friday", time.Date(2024, 3, 8, 9, 0, 0, 0, berlin)},
+		{"in 2 hours", now.Add(2 * time.Hour)},
+	}
+	for _, tt := range tests {
+		added, err := todos.AddTodo(context.Background(), model.ToDo{Title: tt.reminderTime, Status: model.Created,
+			ReminderTime: tt.reminderTime, TimeZone: "Europe/Berlin"})
+		if err != nil {
+			t.Fatalf("AddTodo(%q) failed: %v", tt.reminderTime, err)
+		}
+		if added.ReminderAt == nil || !added.ReminderAt.Equal(tt.want) {
+			t.Errorf("AddTodo(%q) set reminder_at %v, want %v", tt.reminderTime, added.ReminderAt, tt.want)
+		}
+	}
+
+	// the clock decides what is in the past
+	_, err = todos.AddTodo(context.Background(), model.ToDo{Title: "past", Status: model.Created,
+		ReminderTime: "2024-03-01T09:00", TimeZone: "Europe/Berlin"})
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("AddTodo with a reminder time before the clock returned %v, want ErrValidation", err)
+	}
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"toDoList/internal/model"
+)
+
+// Errors returned by every Storage implementation, check them with errors.Is
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrValidation  = errors.New("validation failed")
+	ErrUnavailable = errors.New("storage unavailable")
+)
+
+func errTodoNotFound(id string) error {
+	return fmt.Errorf("todo with ID %v %w", id, ErrNotFound)
+}
+
+func errTodoExists(id string) error {
+	return fmt.Errorf("todo with ID %v already exists: %w", id, ErrConflict)
+}
+
+func errReminderNotFound(id string) error {
+	return fmt.Errorf("reminder with ID %v %w", id, ErrNotFound)
+}
+
+func errReminderExists(id string) error {
+	return fmt.Errorf("reminder with ID %v already exists: %w", id, ErrConflict)
+}
+
+func errReminderState(id string, state model.ReminderState) error {
+	return fmt.Errorf("reminder with ID %v is %v: %w", id, state, ErrConflict)
+}
+
+func errOutboxEventNotFound(id string) error {
+	return fmt.Errorf("outbox event with ID %v %w", id, ErrNotFound)
+}
+
+func errStaleVersion(id string, version int64) error {
+	return fmt.Errorf("todo with ID %v was changed by someone else, version %d is stale: %w", id, version, ErrConflict)
+}
+
+// tells why the state of the reminder could not be changed
+func reminderStateError(ctx context.Context, s ReminderStorage, id string) error {
+	reminder, err := s.GetReminder(ctx, id)
+	if err != nil {
+		return err
+	}
+	return errReminderState(id, reminder.State)
+}
+
+// wraps err with the domain error kind, keeping the original message
+func wrapError(kind error, err error) error {
+	return fmt.Errorf("%w: %w", kind, err)
+}
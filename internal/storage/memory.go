@@ -0,0 +1,497 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"toDoList/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type memoryStorage struct {
+	mu        sync.RWMutex
+	todos     map[string]model.ToDo
+	reminders map[string]model.Reminder
+	events    []model.ReminderEvent // in the order they were added
+
+	notifications      []model.Notification // ordered by ID
+	lastNotificationID int64
+
+	outbox []model.OutboxEvent // in the order they were added
+}
+
+// NewMemoryDb creates a storage that keeps all todos in memory (for tests and demo mode)
+func NewMemoryDb() *memoryStorage {
+	return &memoryStorage{
+		todos:     make(map[string]model.ToDo),
+		reminders: make(map[string]model.Reminder),
+	}
+}
+
+func (m *memoryStorage) AddTodo(ctx context.Context, todo model.ToDo) (model.ToDo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if todo.ID == "" {
+		todo.ID = primitive.NewObjectID().Hex()
+	}
+	if _, exists := m.todos[todo.ID]; exists {
+		return model.ToDo{}, errTodoExists(todo.ID)
+	}
+	todo.CreatedAt = now()
+	todo.UpdatedAt = todo.CreatedAt
+	todo.Version = 1
+	event, err := createdEvent(todo)
+	if err != nil {
+		return model.ToDo{}, err
+	}
+	m.todos[todo.ID] = todo
+	m.outbox = append(m.outbox, event)
+	return todo, nil
+}
+
+func (m *memoryStorage) GetTodos(ctx context.Context, opts model.ListOptions) (model.TodoPage, error) {
+	q, err := parseListOptions(opts)
+	if err != nil {
+		return model.TodoPage{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// compares (value, id) pairs the same way as "ORDER BY <field>, id"
+	before := func(aValue, aID, bValue, bID string) bool {
+		if aValue != bValue {
+			return aValue < bValue != q.SortDesc
+		}
+		return aID != bID && aID < bID != q.SortDesc
+	}
+
+	title := strings.ToLower(q.Title)
+	var todos []model.ToDo
+	for _, todo := range m.todos {
+		if q.Status != "" && todo.Status != q.Status {
+			continue
+		}
+		if title != "" && !strings.Contains(strings.ToLower(todo.Title), title) {
+			continue
+		}
+		if q.after != nil && !before(q.after.Value, q.after.ID, sortValue(todo, q.SortBy), todo.ID) {
+			continue
+		}
+		todos = append(todos, todo)
+	}
+	sort.Slice(todos, func(i, j int) bool {
+		return before(sortValue(todos[i], q.SortBy), todos[i].ID, sortValue(todos[j], q.SortBy), todos[j].ID)
+	})
+
+	if len(todos) > q.Limit+1 {
+		todos = todos[:q.Limit+1]
+	}
+	return newPage(todos, q), nil
+}
+
+func (m *memoryStorage) GetTodoById(ctx context.Context, id string) (model.ToDo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	todo, ok := m.todos[id]
+	if !ok {
+		log.Printf("Todo not found for ID: %v", id)
+		return model.ToDo{}, errTodoNotFound(id)
+	}
+	return todo, nil
+}
+
+func (m *memoryStorage) GetTodoImageById(ctx context.Context, id string) (model.ToDo, error) {
+	return m.GetTodoById(ctx, id)
+}
+
+func (m *memoryStorage) UpdateTodo(ctx context.Context, id string, todo model.ToDo) (model.ToDo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.todos[id]
+	if !ok {
+		return model.ToDo{}, errTodoNotFound(id)
+	}
+	if todo.Version > 0 && todo.Version != existing.Version {
+		return model.ToDo{}, errStaleVersion(id, todo.Version)
+	}
+	// only title, status and due date are updated, same as in other storages
+	oldStatus := existing.Status
+	existing.Title = todo.Title
+	existing.Status = todo.Status
+	existing.DueAt = todo.DueAt
+	existing.UpdatedAt = now()
+	existing.Version++
+	return existing, m.saveChange(existing, oldStatus)
+}
+
+func (m *memoryStorage) PatchTodo(ctx context.Context, id string, patch model.ToDoPatch, version int64) (model.ToDo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.todos[id]
+	if !ok {
+		return model.ToDo{}, errTodoNotFound(id)
+	}
+	if version > 0 && version != existing.Version {
+		return model.ToDo{}, errStaleVersion(id, version)
+	}
+	oldStatus := existing.Status
+	if patch.Title != nil {
+		existing.Title = *patch.Title
+	}
+	if patch.Status != nil {
+		existing.Status = *patch.Status
+	}
+	if patch.DueAt != nil {
+		existing.DueAt = *patch.DueAt
+	}
+	existing.UpdatedAt = now()
+	existing.Version++
+	return existing, m.saveChange(existing, oldStatus)
+}
+
+// stores the updated todo together with its outbox event, m.mu must be held
+func (m *memoryStorage) saveChange(todo model.ToDo, oldStatus model.Status) error {
+	event, err := updatedEvent(todo, oldStatus)
+	if err != nil {
+		return err
+	}
+	m.todos[todo.ID] = todo
+	m.outbox = append(m.outbox, event)
+	return nil
+}
+
+func (m *memoryStorage) UpdateTodoImage(ctx context.Context, id string, imagePath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.todos[id]
+	if !ok {
+		return errTodoNotFound(id)
+	}
+	existing.ImagePath = imagePath
+	existing.UpdatedAt = now()
+	existing.Version++
+	event, err := imageUploadedEvent(existing)
+	if err != nil {
+		return err
+	}
+	m.todos[id] = existing
+	m.outbox = append(m.outbox, event)
+	log.Println("File saved at:", imagePath)
+	return nil
+}
+
+func (m *memoryStorage) DeleteTodo(ctx context.Context, id string, version int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.todos[id]
+	if !ok {
+		return errTodoNotFound(id)
+	}
+	if version > 0 && version != existing.Version {
+		return errStaleVersion(id, version)
+	}
+	event, err := deletedEvent(id)
+	if err != nil {
+		return err
+	}
+	delete(m.todos, id)
+	m.outbox = append(m.outbox, event)
+	return nil
+}
+
+func (m *memoryStorage) AddReminder(ctx context.Context, reminder model.Reminder) (model.Reminder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if reminder.ID == "" {
+		reminder.ID = primitive.NewObjectID().Hex()
+	}
+	if _, exists := m.reminders[reminder.ID]; exists {
+		return model.Reminder{}, errReminderExists(reminder.ID)
+	}
+	if reminder.State == "" {
+		reminder.State = model.ReminderPending
+	}
+	if reminder.Channel == "" {
+		reminder.Channel = model.ChannelSSE
+	}
+	m.reminders[reminder.ID] = reminder
+	return reminder, nil
+}
+
+func (m *memoryStorage) GetReminder(ctx context.Context, id string) (model.Reminder, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	reminder, ok := m.reminders[id]
+	if !ok {
+		return model.Reminder{}, errReminderNotFound(id)
+	}
+	return reminder, nil
+}
+
+func (m *memoryStorage) GetReminders(ctx context.Context, state model.ReminderState) ([]model.Reminder, error) {
+	return m.filterReminders(func(reminder model.Reminder) bool {
+		return state == "" || reminder.State == state
+	}), nil
+}
+
+func (m *memoryStorage) GetTodoReminders(ctx context.Context, todoID string) ([]model.Reminder, error) {
+	return m.filterReminders(func(reminder model.Reminder) bool {
+		return reminder.TodoID == todoID
+	}), nil
+}
+
+// returns the reminders matching the filter ordered by time
+func (m *memoryStorage) filterReminders(match func(reminder model.Reminder) bool) []model.Reminder {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	reminders := make([]model.Reminder, 0, len(m.reminders))
+	for _, reminder := range m.reminders {
+		if match(reminder) {
+			reminders = append(reminders, reminder)
+		}
+	}
+	sort.Slice(reminders, func(i, j int) bool {
+		a, b := reminders[i], reminders[j]
+		if !a.ReminderTime.Equal(b.ReminderTime) {
+			return a.ReminderTime.Before(b.ReminderTime)
+		}
+		return a.ID < b.ID
+	})
+	return reminders
+}
+
+func (m *memoryStorage) UpdateReminder(ctx context.Context, reminder model.Reminder) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.reminders[reminder.ID]
+	if !ok {
+		return errReminderNotFound(reminder.ID)
+	}
+	existing.TaskName = reminder.TaskName
+	existing.ReminderTime = reminder.ReminderTime
+	m.reminders[reminder.ID] = existing
+	return nil
+}
+
+func (m *memoryStorage) SetReminderState(ctx context.Context, id string, from, to model.ReminderState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reminder, ok := m.reminders[id]
+	if !ok {
+		return errReminderNotFound(id)
+	}
+	if reminder.State != from {
+		return errReminderState(id, reminder.State)
+	}
+	reminder.State = to
+	m.reminders[id] = reminder
+	return nil
+}
+
+func (m *memoryStorage) SnoozeReminder(ctx context.Context, id string, at time.Time) (model.Reminder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reminder, ok := m.reminders[id]
+	if !ok {
+		return model.Reminder{}, errReminderNotFound(id)
+	}
+	if reminder.State != model.ReminderPending && reminder.State != model.ReminderDelivered {
+		return model.Reminder{}, errReminderState(id, reminder.State)
+	}
+	reminder.ReminderTime = at
+	reminder.State = model.ReminderPending
+	m.reminders[id] = reminder
+	return reminder, nil
+}
+
+func (m *memoryStorage) DeleteReminder(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.reminders[id]; !ok {
+		return errReminderNotFound(id)
+	}
+	delete(m.reminders, id)
+	return nil
+}
+
+func (m *memoryStorage) Close() {}
+
+func (m *memoryStorage) AddReminderEvent(ctx context.Context, event model.ReminderEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if event.ID == "" {
+		event.ID = primitive.NewObjectID().Hex()
+	}
+	m.events = append(m.events, event)
+	return nil
+}
+
+func (m *memoryStorage) GetReminderEvents(ctx context.Context, todoID string) ([]model.ReminderEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	events := []model.ReminderEvent{}
+	for _, event := range m.events {
+		if event.TodoID == todoID {
+			events = append(events, event)
+		}
+	}
+	// the order of adding breaks ties, same as the generated IDs in other storages
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].OccurredAt.Before(events[j].OccurredAt)
+	})
+	return events, nil
+}
+
+func (m *memoryStorage) DeleteReminderEvents(ctx context.Context, todoID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.events[:0]
+	for _, event := range m.events {
+		if event.TodoID != todoID {
+			kept = append(kept, event)
+		}
+	}
+	m.events = kept
+	return nil
+}
+
+func (m *memoryStorage) AddNotification(ctx context.Context, notification model.Notification) (model.Notification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastNotificationID++
+	notification.ID = m.lastNotificationID
+	m.notifications = append(m.notifications, notification)
+	return notification, nil
+}
+
+func (m *memoryStorage) GetNotifications(ctx context.Context, afterID int64, limit int) ([]model.Notification, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	start := sort.Search(len(m.notifications), func(i int) bool { return m.notifications[i].ID > afterID })
+	end := len(m.notifications)
+	if end-start > limit {
+		end = start + limit
+	}
+	if start == end {
+		return nil, nil
+	}
+	return append([]model.Notification(nil), m.notifications[start:end]...), nil
+}
+
+func (m *memoryStorage) DeleteNotifications(ctx context.Context, upToID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start := sort.Search(len(m.notifications), func(i int) bool { return m.notifications[i].ID > upToID })
+	m.notifications = append([]model.Notification(nil), m.notifications[start:]...)
+	return nil
+}
+
+func (m *memoryStorage) ClaimOutboxEvents(ctx context.Context, todoID string, lease time.Duration, limit int) ([]model.OutboxEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	claimedAt := now()
+	var claimed []model.OutboxEvent
+	blocked := make(map[string]bool) // todos with an older pending event
+	for i := range m.outbox {
+		event := &m.outbox[i]
+		if len(claimed) == limit {
+			break
+		}
+		if event.Status != model.OutboxPending {
+			continue
+		}
+		wait := blocked[event.TodoID]
+		blocked[event.TodoID] = true
+		if wait || event.NextAttemptAt.After(claimedAt) || (todoID != "" && event.TodoID != todoID) {
+			continue
+		}
+		event.NextAttemptAt = claimedAt.Add(lease)
+		claimed = append(claimed, *event)
+	}
+	return claimed, nil
+}
+
+func (m *memoryStorage) GetOutboxEvent(ctx context.Context, id string) (model.OutboxEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, event := range m.outbox {
+		if event.ID == id {
+			return event, nil
+		}
+	}
+	return model.OutboxEvent{}, errOutboxEventNotFound(id)
+}
+
+func (m *memoryStorage) GetOutboxEvents(ctx context.Context, status model.OutboxStatus, limit int) ([]model.OutboxEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var outbox []model.OutboxEvent
+	for _, event := range m.outbox {
+		if len(outbox) == limit {
+			break
+		}
+		if event.Status == status {
+			outbox = append(outbox, event)
+		}
+	}
+	return outbox, nil
+}
+
+func (m *memoryStorage) UpdateOutboxEvent(ctx context.Context, event model.OutboxEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.outbox {
+		if m.outbox[i].ID == event.ID {
+			m.outbox[i].Status = event.Status
+			m.outbox[i].Attempts = event.Attempts
+			m.outbox[i].LastError = event.LastError
+			m.outbox[i].NextAttemptAt = event.NextAttemptAt
+			m.outbox[i].Dropped = event.Dropped
+			m.outbox[i].Delivered = event.Delivered
+			return nil
+		}
+	}
+	return errOutboxEventNotFound(event.ID)
+}
+
+func (m *memoryStorage) DeleteOutboxEvents(ctx context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var kept []model.OutboxEvent
+	for _, event := range m.outbox {
+		if event.Status != model.OutboxPublished || !event.CreatedAt.Before(before) {
+			kept = append(kept, event)
+		}
+	}
+	m.outbox = kept
+	return nil
+}
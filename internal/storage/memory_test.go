@@ -0,0 +1,13 @@
+package storage_test
+
+import (
+	"testing"
+	"toDoList/internal/storage"
+	"toDoList/internal/storage/storagetest"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewMemoryDb()
+	})
+}
@@ -0,0 +1,262 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// Migration files are named <version>_<name>.up.sql and <version>_<name>.down.sql,
+// for example 0002_add_due_date.up.sql. Versions start at 1, follow each other without gaps
+// and are applied in ascending order.
+//
+//go:embed postgres/*.sql
+var postgresFiles embed.FS
+
+// lockKey is a random constant used for pg_advisory_xact_lock,
+// so several servers starting at the same time do not apply the same migration twice
+const lockKey = 7345012389
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version BIGINT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+// DB is implemented by *pgx.Conn and by pgxpool.Pool
+type DB interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Load reads all embedded Postgres migrations sorted by version
+func Load() ([]Migration, error) {
+	return load(postgresFiles)
+}
+
+// reads the migrations in the postgres directory of fsys
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "postgres")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file %s", fileName)
+		}
+
+		base := strings.TrimSuffix(fileName, "."+direction+".sql")
+		versionPart, name, found := strings.Cut(base, "_")
+		if !found {
+			return nil, fmt.Errorf("migration file %s must be named <version>_<name>.%s.sql", fileName, direction)
+		}
+		version, err := strconv.ParseInt(versionPart, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version in migration file %s: %v", fileName, err)
+		}
+
+		body, err := fs.ReadFile(fsys, path.Join("postgres", fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration version %d is used by %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	// a gap is usually a file lost in a merge, applying the later ones would skip it for good
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			return nil, fmt.Errorf("migration %d is missing before %d_%s", i+1, m.Version, m.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// Up applies all pending migrations and returns how many were applied
+func Up(ctx context.Context, db DB) (int, error) {
+	migrations, err := Load()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := db.Exec(ctx, createMigrationsTable); err != nil {
+		return 0, fmt.Errorf("could not create schema_migrations: %v", err)
+	}
+
+	count := 0
+	for _, m := range migrations {
+		applied, err := inLockedTx(ctx, db, func(tx pgx.Tx, appliedVersions map[int64]time.Time) (bool, error) {
+			if _, done := appliedVersions[m.Version]; done {
+				return false, nil
+			}
+			if _, err := tx.Exec(ctx, m.Up); err != nil {
+				return false, err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+			return true, err
+		})
+		if err != nil {
+			return count, fmt.Errorf("migration %d_%s failed: %v", m.Version, m.Name, err)
+		}
+		if applied {
+			log.Printf("Applied migration %d_%s", m.Version, m.Name)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Down rolls back the last `steps` applied migrations and returns how many were rolled back
+func Down(ctx context.Context, db DB, steps int) (int, error) {
+	migrations, err := Load()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := db.Exec(ctx, createMigrationsTable); err != nil {
+		return 0, fmt.Errorf("could not create schema_migrations: %v", err)
+	}
+
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		m := migrations[i]
+		rolledBack, err := inLockedTx(ctx, db, func(tx pgx.Tx, appliedVersions map[int64]time.Time) (bool, error) {
+			if _, done := appliedVersions[m.Version]; !done {
+				return false, nil
+			}
+			if _, err := tx.Exec(ctx, m.Down); err != nil {
+				return false, err
+			}
+			_, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+			return true, err
+		})
+		if err != nil {
+			return count, fmt.Errorf("rollback of %d_%s failed: %v", m.Version, m.Name, err)
+		}
+		if rolledBack {
+			log.Printf("Rolled back migration %d_%s", m.Version, m.Name)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetStatus returns every known migration and whether it is applied
+func GetStatus(ctx context.Context, db DB) ([]Status, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(ctx, createMigrationsTable); err != nil {
+		return nil, fmt.Errorf("could not create schema_migrations: %v", err)
+	}
+
+	appliedVersions, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(migrations))
+	for _, m := range migrations {
+		appliedAt, applied := appliedVersions[m.Version]
+		statuses = append(statuses, Status{Migration: m, Applied: applied, AppliedAt: appliedAt})
+	}
+	return statuses, nil
+}
+
+// runs fn in a transaction holding the migrations lock, the list of applied
+// versions is read after the lock is taken so it is always up to date
+func inLockedTx(ctx context.Context, db DB, fn func(tx pgx.Tx, applied map[int64]time.Time) (bool, error)) (bool, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx) // no-op after commit
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", lockKey); err != nil {
+		return false, err
+	}
+	applied, err := appliedMigrations(ctx, tx)
+	if err != nil {
+		return false, err
+	}
+
+	changed, err := fn(tx, applied)
+	if err != nil || !changed {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+func appliedMigrations(ctx context.Context, q querier) (map[int64]time.Time, error) {
+	rows, err := q.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
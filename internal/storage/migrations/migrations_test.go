@@ -0,0 +1,205 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// returns a file system with the files in its postgres directory, each file holds its own name
+func migrationFiles(names ...string) fstest.MapFS {
+	fsys := fstest.MapFS{"postgres": &fstest.MapFile{Mode: fs.ModeDir}}
+	for _, name := range names {
+		fsys["postgres/"+name] = &fstest.MapFile{Data: []byte("-- " + name)}
+	}
+	return fsys
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		want    []string // <version>_<name> of the loaded migrations
+		wantErr string
+	}{
+		{
+			name:  "sorted by version",
+			files: []string{"0002_b.down.sql", "0003_c.up.sql", "0001_a.up.sql", "0002_b.up.sql", "0001_a.down.sql", "0003_c.down.sql"},
+			want:  []string{"1_a", "2_b", "3_c"},
+		},
+		{
+			name: "names with underscores",
+			files: []string{"0001_create_todos.up.sql", "0001_create_todos.down.sql",
+				"0002_add_due_date.down.sql", "0002_add_due_date.up.sql"},
+			want: []string{"1_create_todos", "2_add_due_date"},
+		},
+		{name: "no migrations"},
+		{name: "gap", files: []string{"1_a.up.sql", "1_a.down.sql", "3_c.up.sql", "3_c.down.sql"}, wantErr: "migration 2 is missing before 3_c"},
+		{name: "not starting at 1", files: []string{"2_b.up.sql", "2_b.down.sql"}, wantErr: "migration 1 is missing"},
+		{name: "missing down file", files: []string{"1_a.up.sql", "1_a.down.sql", "2_b.up.sql"}, wantErr: "2_b must have both up and down files"},
+		{name: "missing up file", files: []string{"1_a.down.sql"}, wantErr: "1_a must have both up and down files"},
+		{name: "version used twice", files: []string{"1_a.up.sql", "1_b.down.sql"}, wantErr: "version 1 is used by"},
+		{name: "no name", files: []string{"0001.up.sql"}, wantErr: "must be named <version>_<name>.up.sql"},
+		{name: "invalid version", files: []string{"first_a.up.sql"}, wantErr: "invalid version"},
+		{name: "no direction", files: []string{"0001_a.sql"}, wantErr: "unexpected migration file 0001_a.sql"},
+		{name: "other file", files: []string{"README.md"}, wantErr: "unexpected migration file README.md"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := load(migrationFiles(tt.files...))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("load returned %v, want an error with %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			var got []string
+			for _, m := range migrations {
+				got = append(got, fmt.Sprintf("%d_%s", m.Version, m.Name))
+				if !strings.HasSuffix(m.Up, "_"+m.Name+".up.sql") || !strings.HasSuffix(m.Down, "_"+m.Name+".down.sql") {
+					t.Errorf("migration %d_%s has up %q and down %q", m.Version, m.Name, m.Up, m.Down)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("load returned %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadEmbeddedMigrations(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(migrations) == 0 || migrations[0].Name != "create_todos" {
+		t.Errorf("Load returned %d migrations, want create_todos first", len(migrations))
+	}
+}
+
+// TEST_DB_CONNECTION_STRING points to a database the test may use, the migrations
+// are applied in a schema of their own, so tests of other packages keep their tables
+func TestUpDownUp(t *testing.T) {
+	connString := os.Getenv("TEST_DB_CONNECTION_STRING")
+	if connString == "" {
+		t.Skip("TEST_DB_CONNECTION_STRING is not set")
+	}
+	ctx := context.Background()
+	const schema = "migrations_test"
+
+	admin, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		t.Fatalf("could not connect to db: %v", err)
+	}
+	defer admin.Close(ctx)
+	if _, err := admin.Exec(ctx, "DROP SCHEMA IF EXISTS "+schema+" CASCADE; CREATE SCHEMA "+schema); err != nil {
+		t.Fatalf("could not create schema %v: %v", schema, err)
+	}
+	t.Cleanup(func() { admin.Exec(context.Background(), "DROP SCHEMA IF EXISTS "+schema+" CASCADE") })
+
+	config, err := pgx.ParseConfig(connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.RuntimeParams["search_path"] = schema
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("could not connect to db: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := len(migrations)
+
+	if n, err := Up(ctx, conn); err != nil || n != total {
+		t.Fatalf("Up applied %d migrations (%v), want %d", n, err, total)
+	}
+	checkApplied(t, conn, total)
+	if n, err := Up(ctx, conn); err != nil || n != 0 {
+		t.Fatalf("second Up applied %d migrations (%v), want none", n, err)
+	}
+
+	if n, err := Down(ctx, conn, 2); err != nil || n != 2 {
+		t.Fatalf("Down 2 rolled back %d migrations (%v), want 2", n, err)
+	}
+	checkApplied(t, conn, total-2)
+	if n, err := Down(ctx, conn, total); err != nil || n != total-2 {
+		t.Fatalf("Down rolled back %d migrations (%v), want the %d left", n, err, total-2)
+	}
+	checkApplied(t, conn, 0)
+	var tables []string
+	rows, err := conn.Query(ctx, "SELECT table_name FROM information_schema.tables WHERE table_schema = $1", schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, table)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 1 || tables[0] != "schema_migrations" {
+		t.Errorf("tables after rolling back every migration are %v, want only schema_migrations", tables)
+	}
+
+	// the down files leave nothing behind the up files trip over
+	if n, err := Up(ctx, conn); err != nil || n != total {
+		t.Fatalf("Up after Down applied %d migrations (%v), want %d", n, err, total)
+	}
+	checkApplied(t, conn, total)
+}
+
+// checks that exactly the first n migrations are recorded in schema_migrations and reported as applied
+func checkApplied(t *testing.T, conn *pgx.Conn, n int) {
+	t.Helper()
+	ctx := context.Background()
+	rows, err := conn.Query(ctx, "SELECT version FROM schema_migrations ORDER BY version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int64
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != n {
+		t.Fatalf("schema_migrations has versions %v, want 1 to %d", versions, n)
+	}
+	for i, version := range versions {
+		if version != int64(i+1) {
+			t.Fatalf("schema_migrations has versions %v, want 1 to %d", versions, n)
+		}
+	}
+
+	statuses, err := GetStatus(ctx, conn)
+	if err != nil {
+		t.Fatalf("GetStatus failed: %v", err)
+	}
+	for i, status := range statuses {
+		if want := i < n; status.Applied != want || status.AppliedAt.IsZero() == want {
+			t.Errorf("GetStatus reports %d_%s applied %v at %v, want applied %v", status.Version, status.Name, status.Applied, status.AppliedAt, want)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+-- IF NOT EXISTS keeps databases where the table was created by hand working
+CREATE TABLE IF NOT EXISTS todos (
+    id VARCHAR PRIMARY KEY,
+    title VARCHAR(255) NOT NULL,
+    status VARCHAR(50) NOT NULL CHECK (status IN ('created', 'in progress', 'done')),
+    image_path VARCHAR(255) DEFAULT '',
+    reminder_time VARCHAR(255) DEFAULT ''
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS todos_status_id_idx;
+DROP INDEX IF EXISTS todos_title_id_idx;
@@ -0,0 +1,3 @@
+-- keyset pagination on GET /todos sorts by (<field>, id)
+CREATE INDEX IF NOT EXISTS todos_title_id_idx ON todos (title, id);
+CREATE INDEX IF NOT EXISTS todos_status_id_idx ON todos (status, id);
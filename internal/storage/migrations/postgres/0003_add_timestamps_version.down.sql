@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS todos_updated_at_id_idx;
+DROP INDEX IF EXISTS todos_created_at_id_idx;
+ALTER TABLE todos DROP COLUMN IF EXISTS version;
+ALTER TABLE todos DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE todos DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,6 @@
+-- server-managed timestamps and the version for optimistic concurrency control
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS todos_created_at_id_idx ON todos (created_at, id);
+CREATE INDEX IF NOT EXISTS todos_updated_at_id_idx ON todos (updated_at, id);
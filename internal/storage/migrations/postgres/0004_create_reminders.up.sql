@@ -0,0 +1,8 @@
+-- reminders are kept in the database, so they survive restarts
+CREATE TABLE IF NOT EXISTS reminders (
+    id TEXT PRIMARY KEY,
+    todo_id TEXT NOT NULL,
+    task_name TEXT NOT NULL,
+    reminder_time TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS reminders_reminder_time_idx ON reminders (reminder_time);
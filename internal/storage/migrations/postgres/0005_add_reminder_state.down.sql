@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS reminders_state_reminder_time_idx;
+ALTER TABLE reminders DROP COLUMN IF EXISTS state;
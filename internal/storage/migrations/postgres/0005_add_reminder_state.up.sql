@@ -0,0 +1,4 @@
+-- delivered reminders are kept, but not sent again
+ALTER TABLE reminders ADD COLUMN IF NOT EXISTS state TEXT NOT NULL DEFAULT 'pending'
+    CHECK (state IN ('pending', 'delivered', 'acknowledged'));
+CREATE INDEX IF NOT EXISTS reminders_state_reminder_time_idx ON reminders (state, reminder_time);
@@ -0,0 +1,2 @@
+ALTER TABLE todos DROP COLUMN IF EXISTS reminder_at;
+ALTER TABLE todos DROP COLUMN IF EXISTS time_zone;
@@ -0,0 +1,3 @@
+-- the instant the reminder time resolves to and the time zone it was given in
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS time_zone TEXT NOT NULL DEFAULT '';
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS reminder_at TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+ALTER TABLE reminders DROP COLUMN IF EXISTS time_zone;
+ALTER TABLE reminders DROP COLUMN IF EXISTS recurrence;
@@ -0,0 +1,3 @@
+-- recurring reminders move to their next occurrence after every delivery
+ALTER TABLE reminders ADD COLUMN IF NOT EXISTS recurrence TEXT NOT NULL DEFAULT '';
+ALTER TABLE reminders ADD COLUMN IF NOT EXISTS time_zone TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,11 @@
+-- the reminder history of todos, events are kept after their reminders are deleted
+CREATE TABLE IF NOT EXISTS reminder_events (
+    id TEXT PRIMARY KEY,
+    reminder_id TEXT NOT NULL,
+    todo_id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    state TEXT NOT NULL DEFAULT '',
+    reminder_time TIMESTAMPTZ NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS reminder_events_todo_id_occurred_at_idx ON reminder_events (todo_id, occurred_at, id);
@@ -0,0 +1,4 @@
+ALTER TABLE reminders DROP COLUMN IF EXISTS message;
+ALTER TABLE reminders DROP COLUMN IF EXISTS channel;
+ALTER TABLE reminders DROP COLUMN IF EXISTS due_offset;
+ALTER TABLE todos DROP COLUMN IF EXISTS due_at;
@@ -0,0 +1,5 @@
+-- reminders with a due offset are scheduled relative to the due date
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS due_at TIMESTAMPTZ;
+ALTER TABLE reminders ADD COLUMN IF NOT EXISTS due_offset TEXT NOT NULL DEFAULT '';
+ALTER TABLE reminders ADD COLUMN IF NOT EXISTS channel TEXT NOT NULL DEFAULT 'sse';
+ALTER TABLE reminders ADD COLUMN IF NOT EXISTS message TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+-- the log of notifications sent to clients, IDs are the SSE event IDs
+CREATE TABLE IF NOT EXISTS notifications (
+    id BIGSERIAL PRIMARY KEY,
+    type TEXT NOT NULL,
+    data TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS outbox_todo_id_status_created_at_idx;
@@ -0,0 +1,2 @@
+-- a claim looks for older pending events of the same todo
+CREATE INDEX IF NOT EXISTS outbox_todo_id_status_created_at_idx ON outbox (todo_id, status, created_at, id);
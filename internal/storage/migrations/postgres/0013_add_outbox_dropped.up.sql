@@ -0,0 +1,2 @@
+-- async subscribers that dropped an event, they get events at most once
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS dropped INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- bus events of an outbox event every subscriber got, a retry skips them
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS delivered INTEGER NOT NULL DEFAULT 0;
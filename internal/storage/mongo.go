@@ -2,8 +2,11 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"regexp"
+	"time"
 	"toDoList/internal/model"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -12,13 +15,46 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// retry policy for reads, short enough to fit into a request timeout
+var mongoReadPolicy = RetryPolicy{
+	MaxAttempts:  4,
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     2 * time.Second,
+	MaxElapsed:   5 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.5,
+	IsRetryable:  isMongoRetryable,
+}
+
+// writes are retried only when the server marks the error as safe to retry
+var mongoWritePolicy = RetryPolicy{
+	MaxAttempts:  4,
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     2 * time.Second,
+	MaxElapsed:   5 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.5,
+	IsRetryable:  isMongoWriteRetryable,
+}
+
 type mongoStorage struct {
 	client     *mongo.Client
 	database   *mongo.Database
 	collection *mongo.Collection
+	reminders  *mongo.Collection
+	events     *mongo.Collection // reminder history
+	// notification log, its IDs come from the notifications document of counters
+	notifications *mongo.Collection
+	counters      *mongo.Collection
+	outbox        *mongo.Collection
+	// false on a standalone server, writes that belong together are then made one after the other
+	transactions bool
 }
 
-func NewMongoDb(uri, dbName, collectionName string) (*mongoStorage, error) {
+// NewMongoDb connects to MongoDB. Todo changes and their outbox events are written in one transaction,
+// which needs a replica set or a sharded cluster. On a standalone server they are written one after
+// the other, unless requireReplicaSet is set, then it fails
+func NewMongoDb(uri, dbName, collectionName string, requireReplicaSet bool) (*mongoStorage, error) {
 	clientOptions := options.Client().ApplyURI(uri)
 	client, err := mongo.Connect(context.Background(), clientOptions)
 	if err != nil {
@@ -33,85 +69,755 @@ func NewMongoDb(uri, dbName, collectionName string) (*mongoStorage, error) {
 	database := client.Database(dbName)
 	collection := database.Collection(collectionName)
 
+	// transactions need a replica set or a sharded cluster
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := database.RunCommand(context.Background(), bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return nil, fmt.Errorf("could not check mongo topology: %v", err)
+	}
+	transactions := hello.SetName != "" || hello.Msg == "isdbgrid"
+	if !transactions && requireReplicaSet {
+		return nil, fmt.Errorf("mongo is a standalone server, todo changes and their outbox events need transactions, " +
+			"run a replica set, e.g. a single-node one started with --replSet rs0 and rs.initiate()")
+	}
+	if !transactions {
+		log.Println("MongoDB is a standalone server without transactions: the outbox is not transactional, " +
+			"an event of a todo change is lost if the server stops between writing the change and its event. " +
+			"Run a replica set and set MONGO_REQUIRE_REPLICA_SET=true to write them in one transaction")
+	}
+
+	// keyset pagination on GET /todos sorts by (<field>, _id)
+	_, err = collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "title", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "updated_at", Value: 1}, {Key: "_id", Value: 1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create mongo indexes: %v", err)
+	}
+
+	// todos stored before timestamps and versions were added get them now
+	backfill := now()
+	_, err = collection.UpdateMany(context.Background(),
+		bson.D{{Key: "created_at", Value: bson.D{{Key: "$exists", Value: false}}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "created_at", Value: backfill}, {Key: "updated_at", Value: backfill}, {Key: "version", Value: 1}}}})
+	if err != nil {
+		return nil, fmt.Errorf("could not backfill todo timestamps: %v", err)
+	}
+
+	reminders := database.Collection("reminders")
+	_, err = reminders.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "reminder_time", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "state", Value: 1}, {Key: "reminder_time", Value: 1}, {Key: "_id", Value: 1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create mongo indexes: %v", err)
+	}
+
+	// reminders stored before delivery states were added are pending
+	_, err = reminders.UpdateMany(context.Background(),
+		bson.D{{Key: "state", Value: bson.D{{Key: "$exists", Value: false}}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "state", Value: model.ReminderPending}}}})
+	if err != nil {
+		return nil, fmt.Errorf("could not backfill reminder states: %v", err)
+	}
+
+	events := database.Collection("reminder_events")
+	_, err = events.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "todo_id", Value: 1}, {Key: "occurred_at", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create mongo indexes: %v", err)
+	}
+
+	outbox := database.Collection("outbox")
+	_, err = outbox.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "todo_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create mongo indexes: %v", err)
+	}
+
+	// transactions create missing collections only since MongoDB 4.4
+	for _, name := range []string{"notifications", "counters"} {
+		err := database.CreateCollection(context.Background(), name)
+		var cmdErr mongo.CommandError
+		if err != nil && !(errors.As(err, &cmdErr) && cmdErr.Code == 48) { // NamespaceExists
+			return nil, fmt.Errorf("could not create mongo collection %v: %v", name, err)
+		}
+	}
+
 	return &mongoStorage{
 		client:     client,
 		database:   database,
 		collection: collection,
+		reminders:  reminders,
+		events:     events,
+
+		notifications: database.Collection("notifications"),
+		counters:      database.Collection("counters"),
+		outbox:        outbox,
+		transactions:  transactions,
 	}, nil
 }
 
-func (m *mongoStorage) AddTodo(todo model.ToDo) error {
+// runs fn in a transaction, it is committed if fn returns nil. WithTransaction retries the whole
+// transaction on transient errors and the commit on unknown results, so no retry policy is added.
+// Without transactions fn runs once, its writes are not undone when a later one fails
+func (m *mongoStorage) inTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !m.transactions {
+		return fn(ctx)
+	}
+	return m.client.UseSession(ctx, func(sc mongo.SessionContext) error {
+		_, err := sc.WithTransaction(sc, func(sc mongo.SessionContext) (interface{}, error) {
+			return nil, fn(sc)
+		})
+		return err
+	})
+}
+
+// reports whether the error is temporary and the operation may succeed on the next attempt
+func isMongoRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return mongo.IsNetworkError(err) || isMongoWriteRetryable(err)
+}
+
+func isMongoWriteRetryable(err error) bool {
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) {
+		return serverErr.HasErrorLabel("RetryableWriteError") || serverErr.HasErrorLabel("TransientTransactionError")
+	}
+	return false
+}
+
+// converts mongo errors into storage errors, other errors are returned as is
+func mongoError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return wrapError(ErrConflict, err)
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) || errors.Is(err, mongo.ErrClientDisconnected) {
+		return wrapError(ErrUnavailable, err)
+	}
+
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		for _, we := range writeErr.WriteErrors {
+			if we.Code == 121 { // DocumentValidationFailure
+				return wrapError(ErrValidation, err)
+			}
+		}
+	}
+	return err
+}
+
+func (m *mongoStorage) AddTodo(ctx context.Context, todo model.ToDo) (model.ToDo, error) {
 	if todo.ID == "" {
 		todo.ID = primitive.NewObjectID().Hex()
 	}
-	_, err := m.collection.InsertOne(context.Background(), todo)
-	return err
+	todo.CreatedAt = now()
+	todo.UpdatedAt = todo.CreatedAt
+	todo.Version = 1
+	event, err := createdEvent(todo)
+	if err != nil {
+		return model.ToDo{}, err
+	}
+	err = m.inTransaction(ctx, func(ctx context.Context) error {
+		if _, err := m.collection.InsertOne(ctx, todo); err != nil {
+			return err
+		}
+		_, err := m.outbox.InsertOne(ctx, event)
+		return err
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return model.ToDo{}, errTodoExists(todo.ID)
+	}
+	if err != nil {
+		return model.ToDo{}, mongoError(err)
+	}
+	return todo, nil
 }
 
-func (m *mongoStorage) GetTodos() ([]model.ToDo, error) {
-	cursor, err := m.collection.Find(context.Background(), bson.D{})
+func (m *mongoStorage) GetTodos(ctx context.Context, opts model.ListOptions) (model.TodoPage, error) {
+	q, err := parseListOptions(opts)
 	if err != nil {
-		return nil, err
+		return model.TodoPage{}, err
+	}
+
+	filter := bson.D{}
+	if q.Status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: q.Status})
+	}
+	if q.Title != "" {
+		filter = append(filter, bson.E{Key: "title", Value: primitive.Regex{Pattern: regexp.QuoteMeta(q.Title), Options: "i"}})
 	}
-	defer cursor.Close(context.Background())
+
+	field := q.SortBy
+	if field == model.SortByID {
+		field = "_id"
+	}
+	direction, compare := 1, "$gt"
+	if q.SortDesc {
+		direction, compare = -1, "$lt"
+	}
+	if q.after != nil {
+		if field == "_id" {
+			filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: compare, Value: q.after.ID}}})
+		} else {
+			// (field, _id) > (value, id)
+			value := q.after.typedValue()
+			filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+				bson.D{{Key: field, Value: bson.D{{Key: compare, Value: value}}}},
+				bson.D{{Key: field, Value: value}, {Key: "_id", Value: bson.D{{Key: compare, Value: q.after.ID}}}},
+			}})
+		}
+	}
+
+	sort := bson.D{{Key: "_id", Value: direction}}
+	if field != "_id" {
+		sort = bson.D{{Key: field, Value: direction}, {Key: "_id", Value: direction}}
+	}
+	findOptions := options.Find().SetSort(sort).SetLimit(int64(q.Limit + 1))
 
 	var todos []model.ToDo
-	for cursor.Next(context.Background()) {
-		var todo model.ToDo
-		if err := cursor.Decode(&todo); err != nil {
-			return nil, err
+	err = mongoReadPolicy.Do(ctx, "mongo.GetTodos", func() error {
+		todos = nil // drop documents read by a failed attempt
+		cursor, err := m.collection.Find(ctx, filter, findOptions)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		for cursor.Next(ctx) {
+			var todo model.ToDo
+			if err := cursor.Decode(&todo); err != nil {
+				return err
+			}
+			todos = append(todos, todo)
 		}
-		todos = append(todos, todo)
+		return cursor.Err()
+	})
+	if err != nil {
+		return model.TodoPage{}, mongoError(err)
 	}
-	if err := cursor.Err(); err != nil {
-		return nil, err
+	return newPage(todos, q), nil
+}
+
+func (m *mongoStorage) GetTodoById(ctx context.Context, id string) (model.ToDo, error) {
+	todo, err := m.findTodo(ctx, "mongo.GetTodoById", id)
+	if errors.Is(err, ErrNotFound) {
+		log.Printf("Todo not found for ID: %v", id)
 	}
-	return todos, nil
+	return todo, err
 }
 
-func (m *mongoStorage) GetTodoById(id string) (model.ToDo, error) {
+func (m *mongoStorage) GetTodoImageById(ctx context.Context, id string) (model.ToDo, error) {
+	return m.findTodo(ctx, "mongo.GetTodoImageById", id)
+}
+
+func (m *mongoStorage) findTodo(ctx context.Context, op string, id string) (model.ToDo, error) {
 	var todo model.ToDo
-	err := m.collection.FindOne(context.Background(), bson.D{{Key: "_id", Value: id}}).Decode(&todo)
+	err := mongoReadPolicy.Do(ctx, op, func() error {
+		return m.collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&todo)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return model.ToDo{}, errTodoNotFound(id)
+	}
 	if err != nil {
-		log.Printf("Todo not found for ID: %v", id)
-		return model.ToDo{}, fmt.Errorf("todo with ID %v not found", id)
+		return model.ToDo{}, mongoError(err)
 	}
 	return todo, nil
 }
 
-func (m *mongoStorage) GetTodoImageById(id string) (model.ToDo, error) {
-	var todo model.ToDo
-	err := m.collection.FindOne(context.Background(), bson.D{{Key: "_id", Value: id}}).Decode(&todo)
-	if err != nil {
-		return model.ToDo{}, err
+func (m *mongoStorage) UpdateTodo(ctx context.Context, id string, todo model.ToDo) (model.ToDo, error) {
+	filter := bson.D{{Key: "_id", Value: id}}
+	if todo.Version > 0 {
+		filter = append(filter, bson.E{Key: "version", Value: todo.Version})
+	}
+	updatedAt := now()
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "title", Value: todo.Title}, {Key: "status", Value: todo.Status},
+			{Key: "due_at", Value: todo.DueAt}, {Key: "updated_at", Value: updatedAt}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
 	}
 
-	return todo, nil
+	return m.updateTodo(ctx, "mongo.UpdateTodo", id, todo.Version, filter, update, func(updated *model.ToDo) {
+		updated.Title = todo.Title
+		updated.Status = todo.Status
+		updated.DueAt = utcTime(todo.DueAt)
+		updated.UpdatedAt = updatedAt
+	})
 }
 
-func (m *mongoStorage) UpdateTodo(id string, todo model.ToDo) error {
-	_, err := m.collection.UpdateOne(
-		context.Background(),
-		bson.D{{Key: "_id", Value: id}},
-		bson.D{{Key: "$set", Value: bson.D{{Key: "title", Value: todo.Title}, {Key: "status", Value: todo.Status}}}},
-	)
-	return err
+func (m *mongoStorage) PatchTodo(ctx context.Context, id string, patch model.ToDoPatch, version int64) (model.ToDo, error) {
+	filter := bson.D{{Key: "_id", Value: id}}
+	if version > 0 {
+		filter = append(filter, bson.E{Key: "version", Value: version})
+	}
+	updatedAt := now()
+	set := bson.D{{Key: "updated_at", Value: updatedAt}}
+	if patch.Title != nil {
+		set = append(set, bson.E{Key: "title", Value: *patch.Title})
+	}
+	if patch.Status != nil {
+		set = append(set, bson.E{Key: "status", Value: *patch.Status})
+	}
+	if patch.DueAt != nil {
+		set = append(set, bson.E{Key: "due_at", Value: *patch.DueAt})
+	}
+	update := bson.D{{Key: "$set", Value: set}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
+
+	return m.updateTodo(ctx, "mongo.PatchTodo", id, version, filter, update, func(patched *model.ToDo) {
+		if patch.Title != nil {
+			patched.Title = *patch.Title
+		}
+		if patch.Status != nil {
+			patched.Status = *patch.Status
+		}
+		if patch.DueAt != nil {
+			patched.DueAt = utcTime(*patch.DueAt)
+		}
+		patched.UpdatedAt = updatedAt
+	})
+}
+
+// applies the update and writes the outbox event of the change. The todo is read as it was before
+// the update, so its old status comes from the same atomic write; apply makes it the updated todo
+func (m *mongoStorage) updateTodo(ctx context.Context, op string, id string, version int64, filter, update bson.D,
+	apply func(todo *model.ToDo)) (model.ToDo, error) {
+	var updated model.ToDo
+	err := m.inTransaction(ctx, func(ctx context.Context) error {
+		var before model.ToDo
+		if err := m.collection.FindOneAndUpdate(ctx, filter, update).Decode(&before); err != nil {
+			return err
+		}
+		updated = before
+		apply(&updated)
+		updated.Version++
+		event, err := updatedEvent(updated, before.Status)
+		if err != nil {
+			return err
+		}
+		_, err = m.outbox.InsertOne(ctx, event)
+		return err
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return model.ToDo{}, m.missingOrStale(ctx, op, id, version)
+	}
+	if err != nil {
+		return model.ToDo{}, mongoError(err)
+	}
+	return updated, nil
 }
 
-func (m *mongoStorage) UpdateTodoImage(id string, imagePath string) error {
-	_, err := m.collection.UpdateOne(
-		context.Background(),
-		bson.D{{Key: "_id", Value: id}},
-		bson.D{{Key: "$set", Value: bson.D{{Key: "image_path", Value: imagePath}}}},
-	)
+// returns a copy of the time in UTC, the way the driver decodes stored times, nil stays nil
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+func (m *mongoStorage) UpdateTodoImage(ctx context.Context, id string, imagePath string) error {
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "image_path", Value: imagePath}, {Key: "updated_at", Value: now()}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+	err := m.inTransaction(ctx, func(ctx context.Context) error {
+		var updated model.ToDo
+		err := m.collection.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: id}}, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+		if err != nil {
+			return err
+		}
+		event, err := imageUploadedEvent(updated)
+		if err != nil {
+			return err
+		}
+		_, err = m.outbox.InsertOne(ctx, event)
+		return err
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return errTodoNotFound(id)
+	}
+	if err != nil {
+		return mongoError(err)
+	}
 	log.Println("File saved at:", imagePath)
+	return nil
+}
 
-	return err
+func (m *mongoStorage) DeleteTodo(ctx context.Context, id string, version int64) error {
+	filter := bson.D{{Key: "_id", Value: id}}
+	if version > 0 {
+		filter = append(filter, bson.E{Key: "version", Value: version})
+	}
+	event, err := deletedEvent(id)
+	if err != nil {
+		return err
+	}
+	err = m.inTransaction(ctx, func(ctx context.Context) error {
+		res, err := m.collection.DeleteOne(ctx, filter)
+		if err != nil {
+			return err
+		}
+		if res.DeletedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		_, err = m.outbox.InsertOne(ctx, event)
+		return err
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return m.missingOrStale(ctx, "mongo.DeleteTodo", id, version)
+	}
+	return mongoError(err)
 }
 
-func (m *mongoStorage) DeleteTodo(id string) error {
-	_, err := m.collection.DeleteOne(context.Background(), bson.D{{Key: "_id", Value: id}})
-	return err
+// tells why a write with the version matched no document
+func (m *mongoStorage) missingOrStale(ctx context.Context, op string, id string, version int64) error {
+	if version == 0 {
+		return errTodoNotFound(id)
+	}
+	if _, err := m.findTodo(ctx, op, id); err != nil {
+		return err
+	}
+	return errStaleVersion(id, version)
+}
+
+func (m *mongoStorage) AddReminder(ctx context.Context, reminder model.Reminder) (model.Reminder, error) {
+	if reminder.ID == "" {
+		reminder.ID = primitive.NewObjectID().Hex()
+	}
+	if reminder.State == "" {
+		reminder.State = model.ReminderPending
+	}
+	if reminder.Channel == "" {
+		reminder.Channel = model.ChannelSSE
+	}
+	err := mongoWritePolicy.Do(ctx, "mongo.AddReminder", func() error {
+		_, err := m.reminders.InsertOne(ctx, reminder)
+		return err
+	})
+	if err != nil {
+		return model.Reminder{}, mongoError(err)
+	}
+	return reminder, nil
+}
+
+func (m *mongoStorage) GetReminder(ctx context.Context, id string) (model.Reminder, error) {
+	var reminder model.Reminder
+	err := mongoReadPolicy.Do(ctx, "mongo.GetReminder", func() error {
+		return m.reminders.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&reminder)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return model.Reminder{}, errReminderNotFound(id)
+	}
+	if err != nil {
+		return model.Reminder{}, mongoError(err)
+	}
+	return reminder, nil
+}
+
+func (m *mongoStorage) GetReminders(ctx context.Context, state model.ReminderState) ([]model.Reminder, error) {
+	filter := bson.D{}
+	if state != "" {
+		filter = append(filter, bson.E{Key: "state", Value: state})
+	}
+	return m.findReminders(ctx, "mongo.GetReminders", filter)
+}
+
+func (m *mongoStorage) GetTodoReminders(ctx context.Context, todoID string) ([]model.Reminder, error) {
+	return m.findReminders(ctx, "mongo.GetTodoReminders", bson.D{{Key: "todo_id", Value: todoID}})
+}
+
+// returns the reminders matching the filter ordered by time
+func (m *mongoStorage) findReminders(ctx context.Context, op string, filter bson.D) ([]model.Reminder, error) {
+	findOptions := options.Find().SetSort(bson.D{{Key: "reminder_time", Value: 1}, {Key: "_id", Value: 1}})
+
+	var reminders []model.Reminder
+	err := mongoReadPolicy.Do(ctx, op, func() error {
+		reminders = nil // drop documents read by a failed attempt
+		cursor, err := m.reminders.Find(ctx, filter, findOptions)
+		if err != nil {
+			return err
+		}
+		return cursor.All(ctx, &reminders)
+	})
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return reminders, nil
+}
+
+func (m *mongoStorage) UpdateReminder(ctx context.Context, reminder model.Reminder) error {
+	var res *mongo.UpdateResult
+	err := mongoWritePolicy.Do(ctx, "mongo.UpdateReminder", func() error {
+		var err error
+		res, err = m.reminders.UpdateOne(ctx, bson.D{{Key: "_id", Value: reminder.ID}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "task_name", Value: reminder.TaskName}, {Key: "reminder_time", Value: reminder.ReminderTime}}}})
+		return err
+	})
+	if err != nil {
+		return mongoError(err)
+	}
+	if res.MatchedCount == 0 {
+		return errReminderNotFound(reminder.ID)
+	}
+	return nil
+}
+
+func (m *mongoStorage) SetReminderState(ctx context.Context, id string, from, to model.ReminderState) error {
+	var res *mongo.UpdateResult
+	err := mongoWritePolicy.Do(ctx, "mongo.SetReminderState", func() error {
+		var err error
+		res, err = m.reminders.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}, {Key: "state", Value: from}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "state", Value: to}}}})
+		return err
+	})
+	if err != nil {
+		return mongoError(err)
+	}
+	if res.MatchedCount == 0 {
+		return reminderStateError(ctx, m, id)
+	}
+	return nil
+}
+
+func (m *mongoStorage) SnoozeReminder(ctx context.Context, id string, at time.Time) (model.Reminder, error) {
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "state", Value: bson.D{{Key: "$in", Value: bson.A{model.ReminderPending, model.ReminderDelivered}}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "reminder_time", Value: at}, {Key: "state", Value: model.ReminderPending}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var reminder model.Reminder
+	err := mongoWritePolicy.Do(ctx, "mongo.SnoozeReminder", func() error {
+		return m.reminders.FindOneAndUpdate(ctx, filter, update, opts).Decode(&reminder)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return model.Reminder{}, reminderStateError(ctx, m, id)
+	}
+	if err != nil {
+		return model.Reminder{}, mongoError(err)
+	}
+	return reminder, nil
+}
+
+func (m *mongoStorage) DeleteReminder(ctx context.Context, id string) error {
+	var res *mongo.DeleteResult
+	err := mongoWritePolicy.Do(ctx, "mongo.DeleteReminder", func() error {
+		var err error
+		res, err = m.reminders.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+		return err
+	})
+	if err != nil {
+		return mongoError(err)
+	}
+	if res.DeletedCount == 0 {
+		return errReminderNotFound(id)
+	}
+	return nil
+}
+
+func (m *mongoStorage) AddReminderEvent(ctx context.Context, event model.ReminderEvent) error {
+	if event.ID == "" {
+		event.ID = primitive.NewObjectID().Hex()
+	}
+	err := mongoWritePolicy.Do(ctx, "mongo.AddReminderEvent", func() error {
+		_, err := m.events.InsertOne(ctx, event)
+		return err
+	})
+	return mongoError(err)
+}
+
+func (m *mongoStorage) GetReminderEvents(ctx context.Context, todoID string) ([]model.ReminderEvent, error) {
+	findOptions := options.Find().SetSort(bson.D{{Key: "occurred_at", Value: 1}, {Key: "_id", Value: 1}})
+
+	var events []model.ReminderEvent
+	err := mongoReadPolicy.Do(ctx, "mongo.GetReminderEvents", func() error {
+		cursor, err := m.events.Find(ctx, bson.D{{Key: "todo_id", Value: todoID}}, findOptions)
+		if err != nil {
+			return err
+		}
+		events = nil // drop documents read by a failed attempt
+		return cursor.All(ctx, &events)
+	})
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return events, nil
+}
+
+func (m *mongoStorage) DeleteReminderEvents(ctx context.Context, todoID string) error {
+	err := mongoWritePolicy.Do(ctx, "mongo.DeleteReminderEvents", func() error {
+		_, err := m.events.DeleteMany(ctx, bson.D{{Key: "todo_id", Value: todoID}})
+		return err
+	})
+	return mongoError(err)
+}
+
+func (m *mongoStorage) AddNotification(ctx context.Context, notification model.Notification) (model.Notification, error) {
+	// the counter only grows, so IDs are not reused after notifications are deleted. It is increased
+	// in the transaction of the insert, so a failed insert does not use up an ID
+	err := m.inTransaction(ctx, func(ctx context.Context) error {
+		var counter struct {
+			Seq int64 `bson:"seq"`
+		}
+		err := m.counters.FindOneAndUpdate(ctx,
+			bson.D{{Key: "_id", Value: "notifications"}},
+			bson.D{{Key: "$inc", Value: bson.D{{Key: "seq", Value: int64(1)}}}},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&counter)
+		if err != nil {
+			return err
+		}
+		notification.ID = counter.Seq
+		_, err = m.notifications.InsertOne(ctx, notification)
+		return err
+	})
+	if err != nil {
+		return model.Notification{}, mongoError(err)
+	}
+	return notification, nil
+}
+
+func (m *mongoStorage) GetNotifications(ctx context.Context, afterID int64, limit int) ([]model.Notification, error) {
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	var notifications []model.Notification
+	err := mongoReadPolicy.Do(ctx, "mongo.GetNotifications", func() error {
+		cursor, err := m.notifications.Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$gt", Value: afterID}}}}, findOptions)
+		if err != nil {
+			return err
+		}
+		notifications = nil // drop documents read by a failed attempt
+		return cursor.All(ctx, &notifications)
+	})
+	return notifications, mongoError(err)
+}
+
+func (m *mongoStorage) DeleteNotifications(ctx context.Context, upToID int64) error {
+	err := mongoWritePolicy.Do(ctx, "mongo.DeleteNotifications", func() error {
+		_, err := m.notifications.DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$lte", Value: upToID}}}})
+		return err
+	})
+	return mongoError(err)
+}
+
+func (m *mongoStorage) ClaimOutboxEvents(ctx context.Context, todoID string, lease time.Duration, limit int) ([]model.OutboxEvent, error) {
+	claimedAt := now()
+	match := bson.D{{Key: "status", Value: model.OutboxPending}}
+	if todoID != "" {
+		match = append(match, bson.E{Key: "todo_id", Value: todoID})
+	}
+	order := bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}
+	// the oldest pending event of every todo, if it is due, a later one waits while it is leased or retried
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: order}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$todo_id"}, {Key: "oldest", Value: bson.D{{Key: "$first", Value: "$$ROOT"}}}}}},
+		{{Key: "$replaceWith", Value: "$oldest"}},
+		{{Key: "$match", Value: bson.D{{Key: "next_attempt_at", Value: bson.D{{Key: "$lte", Value: claimedAt}}}}}},
+		{{Key: "$sort", Value: order}},
+		{{Key: "$limit", Value: limit}},
+	}
+	var due []model.OutboxEvent
+	err := mongoReadPolicy.Do(ctx, "mongo.ClaimOutboxEvents", func() error {
+		cursor, err := m.outbox.Aggregate(ctx, pipeline)
+		if err != nil {
+			return err
+		}
+		due = nil // drop documents read by a failed attempt
+		return cursor.All(ctx, &due)
+	})
+	if err != nil {
+		return nil, mongoError(err)
+	}
+
+	// every claim is atomic and only succeeds if the event is still due, so two servers never get the same event
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "next_attempt_at", Value: claimedAt.Add(lease)}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var claimed []model.OutboxEvent
+	for _, candidate := range due {
+		filter := bson.D{
+			{Key: "_id", Value: candidate.ID},
+			{Key: "status", Value: model.OutboxPending},
+			{Key: "next_attempt_at", Value: bson.D{{Key: "$lte", Value: claimedAt}}},
+		}
+		var event model.OutboxEvent
+		err := mongoWritePolicy.Do(ctx, "mongo.ClaimOutboxEvents", func() error {
+			return m.outbox.FindOneAndUpdate(ctx, filter, update, opts).Decode(&event)
+		})
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue // claimed by someone else meanwhile
+		}
+		if err != nil {
+			return nil, mongoError(err)
+		}
+		claimed = append(claimed, event)
+	}
+	return claimed, nil
+}
+
+func (m *mongoStorage) GetOutboxEvent(ctx context.Context, id string) (model.OutboxEvent, error) {
+	var event model.OutboxEvent
+	err := mongoReadPolicy.Do(ctx, "mongo.GetOutboxEvent", func() error {
+		return m.outbox.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&event)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return model.OutboxEvent{}, errOutboxEventNotFound(id)
+	}
+	if err != nil {
+		return model.OutboxEvent{}, mongoError(err)
+	}
+	return event, nil
+}
+
+func (m *mongoStorage) GetOutboxEvents(ctx context.Context, status model.OutboxStatus, limit int) ([]model.OutboxEvent, error) {
+	findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	var outbox []model.OutboxEvent
+	err := mongoReadPolicy.Do(ctx, "mongo.GetOutboxEvents", func() error {
+		cursor, err := m.outbox.Find(ctx, bson.D{{Key: "status", Value: status}}, findOptions)
+		if err != nil {
+			return err
+		}
+		outbox = nil // drop documents read by a failed attempt
+		return cursor.All(ctx, &outbox)
+	})
+	return outbox, mongoError(err)
+}
+
+func (m *mongoStorage) UpdateOutboxEvent(ctx context.Context, event model.OutboxEvent) error {
+	var res *mongo.UpdateResult
+	err := mongoWritePolicy.Do(ctx, "mongo.UpdateOutboxEvent", func() error {
+		var err error
+		res, err = m.outbox.UpdateOne(ctx, bson.D{{Key: "_id", Value: event.ID}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: event.Status}, {Key: "attempts", Value: event.Attempts},
+				{Key: "last_error", Value: event.LastError}, {Key: "next_attempt_at", Value: event.NextAttemptAt},
+				{Key: "dropped", Value: event.Dropped}, {Key: "delivered", Value: event.Delivered}}}})
+		return err
+	})
+	if err != nil {
+		return mongoError(err)
+	}
+	if res.MatchedCount == 0 {
+		return errOutboxEventNotFound(event.ID)
+	}
+	return nil
+}
+
+func (m *mongoStorage) DeleteOutboxEvents(ctx context.Context, before time.Time) error {
+	err := mongoWritePolicy.Do(ctx, "mongo.DeleteOutboxEvents", func() error {
+		_, err := m.outbox.DeleteMany(ctx, bson.D{{Key: "status", Value: model.OutboxPublished},
+			{Key: "created_at", Value: bson.D{{Key: "$lt", Value: before}}}})
+		return err
+	})
+	return mongoError(err)
 }
 
 func (m *mongoStorage) Close() {
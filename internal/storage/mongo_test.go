@@ -0,0 +1,40 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"toDoList/internal/storage"
+	"toDoList/internal/storage/storagetest"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TEST_MONGO_URI points to a server the test may drop the todolist_test database on,
+// e.g. mongodb://localhost:27017/?replicaSet=rs0
+func TestMongoConformance(t *testing.T) {
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI is not set")
+	}
+	const dbName = "todolist_test"
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		// every check starts with an empty database
+		client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+		if err != nil {
+			t.Fatalf("could not connect to mongo: %v", err)
+		}
+		defer client.Disconnect(context.Background())
+		if err := client.Database(dbName).Drop(context.Background()); err != nil {
+			t.Fatalf("could not drop %v: %v", dbName, err)
+		}
+
+		s, err := storage.NewMongoDb(uri, dbName, "todos", false)
+		if err != nil {
+			t.Fatalf("NewMongoDb failed: %v", err)
+		}
+		return s
+	})
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"toDoList/internal/events"
+	"toDoList/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// returns the pending outbox event of a change of the todo, due right away
+func newOutboxEvent(eventType events.Type, todo model.ToDo, oldStatus model.Status) (model.OutboxEvent, error) {
+	event, err := model.NewOutboxEvent(string(eventType), todo, oldStatus)
+	if err != nil {
+		return model.OutboxEvent{}, fmt.Errorf("could not encode %v event of todo %v: %v", eventType, todo.ID, err)
+	}
+	event.ID = primitive.NewObjectID().Hex()
+	event.CreatedAt = now()
+	event.NextAttemptAt = event.CreatedAt
+	return event, nil
+}
+
+// the outbox event of a new todo
+func createdEvent(todo model.ToDo) (model.OutboxEvent, error) {
+	return newOutboxEvent(events.TodoCreated, todo, "")
+}
+
+// the outbox event of a change of the status or other fields, oldStatus is the status before it
+func updatedEvent(todo model.ToDo, oldStatus model.Status) (model.OutboxEvent, error) {
+	return newOutboxEvent(events.TodoUpdated, todo, oldStatus)
+}
+
+// the outbox event of a new image of the todo
+func imageUploadedEvent(todo model.ToDo) (model.OutboxEvent, error) {
+	return newOutboxEvent(events.TodoImageUploaded, todo, "")
+}
+
+// the outbox event of a deleted todo, only its ID is kept
+func deletedEvent(id string) (model.OutboxEvent, error) {
+	return newOutboxEvent(events.TodoDeleted, model.ToDo{ID: id}, "")
+}
+
+// orders events the way they are published: oldest first, ObjectIDs of the same millisecond by their counter
+func sortOutboxEvents(outbox []model.OutboxEvent) {
+	sort.Slice(outbox, func(i, j int) bool {
+		if !outbox[i].CreatedAt.Equal(outbox[j].CreatedAt) {
+			return outbox[i].CreatedAt.Before(outbox[j].CreatedAt)
+		}
+		return outbox[i].ID < outbox[j].ID
+	})
+}
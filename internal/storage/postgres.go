@@ -2,74 +2,319 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net"
+	"strings"
 	"time"
 	"toDoList/internal/model"
+	"toDoList/internal/storage/migrations"
 
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-const maxRetries = 5               // for db
-const retryDelay = 2 * time.Second // between retries
+// retry policy for reads, short enough to fit into a request timeout
+var postgresReadPolicy = RetryPolicy{
+	MaxAttempts:  4,
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     2 * time.Second,
+	MaxElapsed:   5 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.5,
+	IsRetryable:  isPostgresRetryable,
+}
+
+// writes are retried only when the statement surely was not executed
+var postgresWritePolicy = RetryPolicy{
+	MaxAttempts:  4,
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     2 * time.Second,
+	MaxElapsed:   5 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.5,
+	IsRetryable:  isPostgresWriteRetryable,
+}
+
+// the database may still be starting when the server starts, so wait longer
+var postgresConnectPolicy = RetryPolicy{
+	MaxAttempts:  5,
+	InitialDelay: 2 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.2,
+}
 
 type Storage interface {
-	GetTodos() ([]model.ToDo, error)
-	GetTodoById(id string) (model.ToDo, error)
-	GetTodoImageById(id string) (model.ToDo, error)
-	AddTodo(todo model.ToDo) error
-	UpdateTodo(id string, todo model.ToDo) error
-	UpdateTodoImage(id string, imagePath string) error
-	DeleteTodo(id string) error
+	GetTodos(ctx context.Context, opts model.ListOptions) (model.TodoPage, error)
+	GetTodoById(ctx context.Context, id string) (model.ToDo, error)
+	GetTodoImageById(ctx context.Context, id string) (model.ToDo, error)
+	// AddTodo stores a new todo and returns it with the fields set by the storage.
+	// Every method that changes a todo writes its outbox event in the same transaction
+	AddTodo(ctx context.Context, todo model.ToDo) (model.ToDo, error)
+	// UpdateTodo changes title, status and due date and returns the updated todo. A non-zero
+	// todo.Version must match the stored version, otherwise ErrConflict is returned
+	UpdateTodo(ctx context.Context, id string, todo model.ToDo) (model.ToDo, error)
+	// PatchTodo writes only the fields set in the patch and returns the updated todo,
+	// a non-zero version must match the stored version
+	PatchTodo(ctx context.Context, id string, patch model.ToDoPatch, version int64) (model.ToDo, error)
+	UpdateTodoImage(ctx context.Context, id string, imagePath string) error
+	// DeleteTodo removes the todo, a non-zero version must match the stored version
+	DeleteTodo(ctx context.Context, id string, version int64) error
+	ReminderStorage
+	NotificationStorage
+	OutboxStorage
 	Close()
 }
 
+// ReminderStorage keeps reminders with their delivery state
+type ReminderStorage interface {
+	// AddReminder stores the reminder and returns it with the generated ID, the state is pending if not set
+	AddReminder(ctx context.Context, reminder model.Reminder) (model.Reminder, error)
+	GetReminder(ctx context.Context, id string) (model.Reminder, error)
+	// GetReminders returns the reminders in the state ordered by time, all reminders if state is empty
+	GetReminders(ctx context.Context, state model.ReminderState) ([]model.Reminder, error)
+	// GetTodoReminders returns all reminders of the todo ordered by time
+	GetTodoReminders(ctx context.Context, todoID string) ([]model.Reminder, error)
+	// UpdateReminder changes the task name and time of the reminder
+	UpdateReminder(ctx context.Context, reminder model.Reminder) error
+	// SetReminderState moves the reminder from one state to another,
+	// ErrConflict is returned if the reminder is not in the from state
+	SetReminderState(ctx context.Context, id string, from, to model.ReminderState) error
+	// SnoozeReminder moves a pending or delivered reminder to the time and makes it pending again,
+	// ErrConflict is returned if the reminder is acknowledged
+	SnoozeReminder(ctx context.Context, id string, at time.Time) (model.Reminder, error)
+	DeleteReminder(ctx context.Context, id string) error
+
+	// AddReminderEvent records a transition of a reminder, the ID is generated if empty
+	AddReminderEvent(ctx context.Context, event model.ReminderEvent) error
+	// GetReminderEvents returns the reminder history of the todo ordered by time
+	GetReminderEvents(ctx context.Context, todoID string) ([]model.ReminderEvent, error)
+	// DeleteReminderEvents drops the reminder history of the todo
+	DeleteReminderEvents(ctx context.Context, todoID string) error
+}
+
+// NotificationStorage keeps the log of notifications, so clients that reconnect can catch up
+type NotificationStorage interface {
+	// AddNotification stores the notification with the next ID, IDs only grow and are never reused
+	AddNotification(ctx context.Context, notification model.Notification) (model.Notification, error)
+	// GetNotifications returns up to limit notifications with an ID greater than afterID, ordered by ID
+	GetNotifications(ctx context.Context, afterID int64, limit int) ([]model.Notification, error)
+	// DeleteNotifications drops the notifications with an ID up to and including upToID
+	DeleteNotifications(ctx context.Context, upToID int64) error
+}
+
+// OutboxStorage keeps the events of todo changes until the relay published them
+type OutboxStorage interface {
+	// ClaimOutboxEvents returns up to limit pending events that are due, oldest first, only the ones
+	// of todoID if it is set. They are not due again before lease passed, so no one else publishes them meanwhile.
+	// An event is only claimed if no older event of its todo is pending, so the changes of a todo are published in order
+	ClaimOutboxEvents(ctx context.Context, todoID string, lease time.Duration, limit int) ([]model.OutboxEvent, error)
+	GetOutboxEvent(ctx context.Context, id string) (model.OutboxEvent, error)
+	// GetOutboxEvents returns up to limit events in the status, oldest first
+	GetOutboxEvents(ctx context.Context, status model.OutboxStatus, limit int) ([]model.OutboxEvent, error)
+	// UpdateOutboxEvent writes status, attempts, last error, next attempt, drops and delivered bus events of the event
+	UpdateOutboxEvent(ctx context.Context, event model.OutboxEvent) error
+	// DeleteOutboxEvents drops the published events created before the time
+	DeleteOutboxEvents(ctx context.Context, before time.Time) error
+}
+
 type postgresStorage struct {
-	conn *pgx.Conn
+	pool *pgxpool.Pool
+}
+
+// PostgresPoolConfig overrides the pool settings, zero values keep pgxpool defaults
+// (or the pool_* parameters of the connection string)
+type PostgresPoolConfig struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+}
+
+// PoolStats is a snapshot of the connection pool state
+type PoolStats struct {
+	MaxConns             int32         `json:"max_conns"`
+	TotalConns           int32         `json:"total_conns"`
+	AcquiredConns        int32         `json:"acquired_conns"`
+	IdleConns            int32         `json:"idle_conns"`
+	ConstructingConns    int32         `json:"constructing_conns"`
+	AcquireCount         int64         `json:"acquire_count"`
+	EmptyAcquireCount    int64         `json:"empty_acquire_count"`
+	CanceledAcquireCount int64         `json:"canceled_acquire_count"`
+	AcquireDuration      time.Duration `json:"acquire_duration_ns"`
 }
 
-// executes a function with retries on error
-func retryWrapper(retries int, retryDelay time.Duration, operation func() error) error {
-	var err error
-	for retries > 0 {
-		err = operation()
-		if err == nil {
-			return nil
+// StatsProvider is implemented by storages that use a connection pool
+type StatsProvider interface {
+	PoolStats() PoolStats
+}
+
+// reports whether the error is temporary and the query may succeed on the next attempt
+func isPostgresRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "40001", pgErr.Code == "40P01": // serialization failure, deadlock
+			return true
+		case strings.HasPrefix(pgErr.Code, "08"), strings.HasPrefix(pgErr.Code, "53"), strings.HasPrefix(pgErr.Code, "57P"): // connection, resources, shutdown
+			return true
+		}
+		// constraint violations, syntax errors, missing rows and so on will fail again
+		return false
+	}
+
+	var netErr net.Error
+	return pgconn.SafeToRetry(err) || errors.As(err, &netErr)
+}
+
+// like isPostgresRetryable, but a broken connection is retried only if the statement was not sent,
+// otherwise a repeated insert or delete could report a conflict or not found for a successful write
+func isPostgresWriteRetryable(err error) bool {
+	if !isPostgresRetryable(err) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) || pgconn.SafeToRetry(err) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// converts postgres errors into storage errors, other errors are returned as is
+func postgresError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "23505": // unique_violation
+			return wrapError(ErrConflict, err)
+		case strings.HasPrefix(pgErr.Code, "22"), strings.HasPrefix(pgErr.Code, "23"): // data exception, integrity constraint violation
+			return wrapError(ErrValidation, err)
+		case strings.HasPrefix(pgErr.Code, "08"), strings.HasPrefix(pgErr.Code, "53"), strings.HasPrefix(pgErr.Code, "57P"): // connection, resources, shutdown
+			return wrapError(ErrUnavailable, err)
 		}
-		retries--
-		time.Sleep(retryDelay)
-		retryDelay *= 2 // delay for each subsequent attempt
+		return err
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || pgconn.Timeout(err) || pgconn.SafeToRetry(err) {
+		return wrapError(ErrUnavailable, err)
 	}
-	return fmt.Errorf("operation failed after multiple retries: %v", err)
+	return err
 }
 
-func NewPostgresDb(connString string) (*postgresStorage, error) {
-	var conn *pgx.Conn
-	err := retryWrapper(maxRetries, retryDelay, func() error {
+var postgresDialect = sqlDialect{
+	placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+	likeOp:      "ILIKE",
+	timeValues:  true,
+}
+
+func NewPostgresDb(connString string, poolConfig PostgresPoolConfig) (*postgresStorage, error) {
+	config, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection string: %v", err)
+	}
+	if poolConfig.MaxConns > 0 {
+		config.MaxConns = poolConfig.MaxConns
+	}
+	if poolConfig.MinConns > 0 {
+		config.MinConns = poolConfig.MinConns
+	}
+	if poolConfig.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = poolConfig.MaxConnLifetime
+	}
+	if poolConfig.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = poolConfig.MaxConnIdleTime
+	}
+	if poolConfig.HealthCheckPeriod > 0 {
+		config.HealthCheckPeriod = poolConfig.HealthCheckPeriod
+	}
+
+	var pool *pgxpool.Pool
+	err = postgresConnectPolicy.Do(context.Background(), "postgres.Connect", func() error {
 		var err error
-		conn, err = pgx.Connect(context.Background(), connString)
+		pool, err = pgxpool.ConnectConfig(context.Background(), config)
 		return err
 	})
 	if err != nil {
-		return nil, fmt.Errorf("could not connect to db after multiple retries: %v", err)
+		return nil, fmt.Errorf("could not connect to db: %v", err)
+	}
+	log.Printf("Postgres pool is ready, max connections: %d, min connections: %d", config.MaxConns, config.MinConns)
+
+	// bring the schema up to date before serving any requests
+	applied, err := migrations.Up(context.Background(), pool)
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("could not apply migrations: %v", err)
 	}
-	return &postgresStorage{conn: conn}, nil
+	log.Printf("Database schema is up to date, applied %d new migrations", applied)
+
+	return &postgresStorage{pool: pool}, nil
 }
 
-func (s *postgresStorage) AddTodo(todo model.ToDo) error {
-	// repeat attempts to execute the SQL query
-	return retryWrapper(maxRetries, retryDelay, func() error {
-		_, err := s.conn.Exec(context.Background(),
-			"INSERT INTO todos (id, title, status, image_path, reminder_time) VALUES ($1, $2, $3, $4, $5)",
-			todo.ID, todo.Title, todo.Status, todo.ImagePath, todo.ReminderTime)
-		return err
+// columns scanned by postgresTodoFields
+const postgresTodoColumns = "id, title, status, image_path, reminder_time, time_zone, reminder_at, due_at, created_at, updated_at, version"
+
+// returns the scan destinations for postgresTodoColumns
+func postgresTodoFields(todo *model.ToDo) []interface{} {
+	return []interface{}{&todo.ID, &todo.Title, &todo.Status, &todo.ImagePath, &todo.ReminderTime, &todo.TimeZone, &todo.ReminderAt, &todo.DueAt,
+		&todo.CreatedAt, &todo.UpdatedAt, &todo.Version}
+}
+
+func (s *postgresStorage) AddTodo(ctx context.Context, todo model.ToDo) (model.ToDo, error) {
+	if todo.ID == "" {
+		todo.ID = primitive.NewObjectID().Hex()
+	}
+	todo.CreatedAt = now()
+	todo.UpdatedAt = todo.CreatedAt
+	todo.Version = 1
+	event, err := createdEvent(todo)
+	if err != nil {
+		return model.ToDo{}, err
+	}
+	err = s.inTx(ctx, "postgres.AddTodo", func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx,
+			"INSERT INTO todos ("+postgresTodoColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)",
+			todo.ID, todo.Title, todo.Status, todo.ImagePath, todo.ReminderTime, todo.TimeZone, todo.ReminderAt, todo.DueAt,
+			todo.CreatedAt, todo.UpdatedAt, todo.Version)
+		if err != nil {
+			return err
+		}
+		return addPostgresOutboxEvent(ctx, tx, event)
 	})
+	if errors.Is(postgresError(err), ErrConflict) {
+		return model.ToDo{}, errTodoExists(todo.ID)
+	}
+	if err != nil {
+		return model.ToDo{}, postgresError(err)
+	}
+	return todo, nil
 }
 
-func (s *postgresStorage) GetTodos() ([]model.ToDo, error) {
+func (s *postgresStorage) GetTodos(ctx context.Context, opts model.ListOptions) (model.TodoPage, error) {
+	q, err := parseListOptions(opts)
+	if err != nil {
+		return model.TodoPage{}, err
+	}
+	sql, args := buildListSQL(postgresTodoColumns, q, postgresDialect)
+
 	var todos []model.ToDo
-	err := retryWrapper(maxRetries, retryDelay, func() error {
-		rows, err := s.conn.Query(context.Background(),
-			"SELECT id, title, status, image_path, reminder_time FROM todos")
+	err = postgresReadPolicy.Do(ctx, "postgres.GetTodos", func() error {
+		todos = nil // drop rows read by a failed attempt
+		rows, err := s.pool.Query(ctx, sql, args...)
 		if err != nil {
 			return err
 		}
@@ -77,56 +322,502 @@ func (s *postgresStorage) GetTodos() ([]model.ToDo, error) {
 
 		for rows.Next() {
 			var todo model.ToDo
-			if err := rows.Scan(&todo.ID, &todo.Title, &todo.Status, &todo.ImagePath, &todo.ReminderTime); err != nil {
+			if err := rows.Scan(postgresTodoFields(&todo)...); err != nil {
 				return err
 			}
 			todos = append(todos, todo)
 		}
-		return nil
+		return rows.Err()
 	})
-	return todos, err
+	if err != nil {
+		return model.TodoPage{}, postgresError(err)
+	}
+	return newPage(todos, q), nil
 }
 
-func (s *postgresStorage) GetTodoById(id string) (model.ToDo, error) {
+func (s *postgresStorage) GetTodoById(ctx context.Context, id string) (model.ToDo, error) {
 	var todo model.ToDo
-	err := retryWrapper(maxRetries, retryDelay, func() error {
-		return s.conn.QueryRow(context.Background(),
-			"SELECT id, title, status, image_path, reminder_time FROM todos WHERE id = $1", id).
-			Scan(&todo.ID, &todo.Title, &todo.Status, &todo.ImagePath, &todo.ReminderTime)
+	err := postgresReadPolicy.Do(ctx, "postgres.GetTodoById", func() error {
+		return s.pool.QueryRow(ctx, "SELECT "+postgresTodoColumns+" FROM todos WHERE id = $1", id).
+			Scan(postgresTodoFields(&todo)...)
 	})
-	return todo, err
+	if errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Todo not found for ID: %v", id)
+		return model.ToDo{}, errTodoNotFound(id)
+	}
+	return todo, postgresError(err)
 }
 
-func (s *postgresStorage) GetTodoImageById(id string) (model.ToDo, error) {
+func (s *postgresStorage) GetTodoImageById(ctx context.Context, id string) (model.ToDo, error) {
 	var todo model.ToDo
-	err := retryWrapper(maxRetries, retryDelay, func() error {
-		return s.conn.QueryRow(context.Background(), "SELECT image_path FROM todos WHERE id = $1", id).
+	err := postgresReadPolicy.Do(ctx, "postgres.GetTodoImageById", func() error {
+		return s.pool.QueryRow(ctx, "SELECT image_path FROM todos WHERE id = $1", id).
 			Scan(&todo.ImagePath)
 	})
-	return todo, err
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.ToDo{}, errTodoNotFound(id)
+	}
+	return todo, postgresError(err)
+}
+
+func (s *postgresStorage) UpdateTodo(ctx context.Context, id string, todo model.ToDo) (model.ToDo, error) {
+	return s.updateTodo(ctx, "postgres.UpdateTodo", id, todo.Version,
+		"UPDATE todos SET title = $1, status = $2, due_at = $3, updated_at = $4, version = version + 1 "+
+			"WHERE id = $5 AND ($6::bigint = 0 OR version = $6) RETURNING "+postgresTodoColumns,
+		todo.Title, todo.Status, todo.DueAt, now(), id, todo.Version)
+}
+
+func (s *postgresStorage) PatchTodo(ctx context.Context, id string, patch model.ToDoPatch, version int64) (model.ToDo, error) {
+	sql, args := buildPatchSQL(postgresTodoColumns, id, patch, version, now(), postgresDialect)
+	return s.updateTodo(ctx, "postgres.PatchTodo", id, version, sql, args...)
+}
+
+// runs an UPDATE of the todo returning postgresTodoColumns and writes its outbox event in the same transaction,
+// the row is locked first, so the old status is the one the update changed
+func (s *postgresStorage) updateTodo(ctx context.Context, op string, id string, version int64, sql string, args ...interface{}) (model.ToDo, error) {
+	var updated model.ToDo
+	err := s.inTx(ctx, op, func(tx pgx.Tx) error {
+		var oldStatus model.Status
+		if err := tx.QueryRow(ctx, "SELECT status FROM todos WHERE id = $1 FOR UPDATE", id).Scan(&oldStatus); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(ctx, sql, args...).Scan(postgresTodoFields(&updated)...); err != nil {
+			return err
+		}
+		event, err := updatedEvent(updated, oldStatus)
+		if err != nil {
+			return err
+		}
+		return addPostgresOutboxEvent(ctx, tx, event)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.ToDo{}, s.missingOrStale(ctx, id, version)
+	}
+	if err != nil {
+		return model.ToDo{}, postgresError(err)
+	}
+	return updated, nil
+}
+
+// tells why an update with the version touched no row
+func (s *postgresStorage) missingOrStale(ctx context.Context, id string, version int64) error {
+	if version == 0 {
+		return errTodoNotFound(id)
+	}
+	if _, err := s.GetTodoById(ctx, id); err != nil {
+		return err
+	}
+	return errStaleVersion(id, version)
+}
+
+func (s *postgresStorage) UpdateTodoImage(ctx context.Context, id string, imagePath string) error {
+	err := s.inTx(ctx, "postgres.UpdateTodoImage", func(tx pgx.Tx) error {
+		var updated model.ToDo
+		err := tx.QueryRow(ctx,
+			"UPDATE todos SET image_path = $1, updated_at = $2, version = version + 1 WHERE id = $3 RETURNING "+postgresTodoColumns,
+			imagePath, now(), id).
+			Scan(postgresTodoFields(&updated)...)
+		if err != nil {
+			return err
+		}
+		event, err := imageUploadedEvent(updated)
+		if err != nil {
+			return err
+		}
+		return addPostgresOutboxEvent(ctx, tx, event)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errTodoNotFound(id)
+	}
+	return postgresError(err)
+}
+
+func (s *postgresStorage) DeleteTodo(ctx context.Context, id string, version int64) error {
+	event, err := deletedEvent(id)
+	if err != nil {
+		return err
+	}
+	err = s.inTx(ctx, "postgres.DeleteTodo", func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, "DELETE FROM todos WHERE id = $1 AND ($2::bigint = 0 OR version = $2)", id, version)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return errTodoNotFound(id)
+		}
+		return addPostgresOutboxEvent(ctx, tx, event)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return s.missingOrStale(ctx, id, version)
+	}
+	return postgresError(err)
+}
+
+// runs fn in a transaction, it is committed if fn returns nil. A failed transaction
+// is rolled back, so it is retried as a whole
+func (s *postgresStorage) inTx(ctx context.Context, op string, fn func(tx pgx.Tx) error) error {
+	return postgresWritePolicy.Do(ctx, op, func() error {
+		return s.pool.BeginFunc(ctx, fn)
+	})
+}
+
+// columns scanned by postgresReminderFields
+const postgresReminderColumns = "id, todo_id, task_name, reminder_time, state, recurrence, time_zone, due_offset, channel, message"
+
+// returns the scan destinations for postgresReminderColumns
+func postgresReminderFields(reminder *model.Reminder) []interface{} {
+	return []interface{}{&reminder.ID, &reminder.TodoID, &reminder.TaskName, &reminder.ReminderTime, &reminder.State,
+		&reminder.Recurrence, &reminder.TimeZone, &reminder.DueOffset, &reminder.Channel, &reminder.Message}
+}
+
+func (s *postgresStorage) AddReminder(ctx context.Context, reminder model.Reminder) (model.Reminder, error) {
+	if reminder.ID == "" {
+		reminder.ID = primitive.NewObjectID().Hex()
+	}
+	if reminder.State == "" {
+		reminder.State = model.ReminderPending
+	}
+	if reminder.Channel == "" {
+		reminder.Channel = model.ChannelSSE
+	}
+	err := postgresWritePolicy.Do(ctx, "postgres.AddReminder", func() error {
+		_, err := s.pool.Exec(ctx,
+			"INSERT INTO reminders ("+postgresReminderColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
+			reminder.ID, reminder.TodoID, reminder.TaskName, reminder.ReminderTime, reminder.State,
+			reminder.Recurrence, reminder.TimeZone, reminder.DueOffset, reminder.Channel, reminder.Message)
+		return err
+	})
+	if err != nil {
+		return model.Reminder{}, postgresError(err)
+	}
+	return reminder, nil
+}
+
+func (s *postgresStorage) GetReminder(ctx context.Context, id string) (model.Reminder, error) {
+	var reminder model.Reminder
+	err := postgresReadPolicy.Do(ctx, "postgres.GetReminder", func() error {
+		return s.pool.QueryRow(ctx,
+			"SELECT "+postgresReminderColumns+" FROM reminders WHERE id = $1", id).
+			Scan(postgresReminderFields(&reminder)...)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.Reminder{}, errReminderNotFound(id)
+	}
+	return reminder, postgresError(err)
+}
+
+func (s *postgresStorage) GetReminders(ctx context.Context, state model.ReminderState) ([]model.Reminder, error) {
+	return s.queryReminders(ctx, "postgres.GetReminders",
+		"SELECT "+postgresReminderColumns+" FROM reminders "+
+			"WHERE ($1 = '' OR state = $1) ORDER BY reminder_time, id", string(state))
+}
+
+func (s *postgresStorage) GetTodoReminders(ctx context.Context, todoID string) ([]model.Reminder, error) {
+	return s.queryReminders(ctx, "postgres.GetTodoReminders",
+		"SELECT "+postgresReminderColumns+" FROM reminders "+
+			"WHERE todo_id = $1 ORDER BY reminder_time, id", todoID)
+}
+
+// returns the reminders read by the query
+func (s *postgresStorage) queryReminders(ctx context.Context, op string, sql string, args ...interface{}) ([]model.Reminder, error) {
+	var reminders []model.Reminder
+	err := postgresReadPolicy.Do(ctx, op, func() error {
+		reminders = nil // drop rows read by a failed attempt
+		rows, err := s.pool.Query(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var reminder model.Reminder
+			if err := rows.Scan(postgresReminderFields(&reminder)...); err != nil {
+				return err
+			}
+			reminders = append(reminders, reminder)
+		}
+		return rows.Err()
+	})
+	return reminders, postgresError(err)
+}
+
+func (s *postgresStorage) UpdateReminder(ctx context.Context, reminder model.Reminder) error {
+	var tag pgconn.CommandTag
+	err := postgresWritePolicy.Do(ctx, "postgres.UpdateReminder", func() error {
+		var err error
+		tag, err = s.pool.Exec(ctx, "UPDATE reminders SET task_name = $1, reminder_time = $2 WHERE id = $3",
+			reminder.TaskName, reminder.ReminderTime, reminder.ID)
+		return err
+	})
+	if err != nil {
+		return postgresError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errReminderNotFound(reminder.ID)
+	}
+	return nil
+}
+
+func (s *postgresStorage) SetReminderState(ctx context.Context, id string, from, to model.ReminderState) error {
+	var tag pgconn.CommandTag
+	err := postgresWritePolicy.Do(ctx, "postgres.SetReminderState", func() error {
+		var err error
+		tag, err = s.pool.Exec(ctx, "UPDATE reminders SET state = $1 WHERE id = $2 AND state = $3", to, id, from)
+		return err
+	})
+	if err != nil {
+		return postgresError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return reminderStateError(ctx, s, id)
+	}
+	return nil
 }
 
-func (s *postgresStorage) UpdateTodo(id string, todo model.ToDo) error {
-	return retryWrapper(maxRetries, retryDelay, func() error {
-		_, err := s.conn.Exec(context.Background(), "UPDATE todos SET title = $1, status = $2 WHERE id = $3", todo.Title, todo.Status, id)
+func (s *postgresStorage) DeleteReminder(ctx context.Context, id string) error {
+	var tag pgconn.CommandTag
+	err := postgresWritePolicy.Do(ctx, "postgres.DeleteReminder", func() error {
+		var err error
+		tag, err = s.pool.Exec(ctx, "DELETE FROM reminders WHERE id = $1", id)
 		return err
 	})
+	if err != nil {
+		return postgresError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errReminderNotFound(id)
+	}
+	return nil
+}
+
+func (s *postgresStorage) SnoozeReminder(ctx context.Context, id string, at time.Time) (model.Reminder, error) {
+	var reminder model.Reminder
+	err := postgresWritePolicy.Do(ctx, "postgres.SnoozeReminder", func() error {
+		return s.pool.QueryRow(ctx,
+			"UPDATE reminders SET reminder_time = $1, state = $2 WHERE id = $3 AND state IN ($2, $4) RETURNING "+postgresReminderColumns,
+			at, model.ReminderPending, id, model.ReminderDelivered).
+			Scan(postgresReminderFields(&reminder)...)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.Reminder{}, reminderStateError(ctx, s, id)
+	}
+	if err != nil {
+		return model.Reminder{}, postgresError(err)
+	}
+	return reminder, nil
 }
 
-func (s *postgresStorage) UpdateTodoImage(id string, imagePath string) error {
-	return retryWrapper(maxRetries, retryDelay, func() error {
-		_, err := s.conn.Exec(context.Background(), "UPDATE todos SET image_path = $1 WHERE id = $2", imagePath, id)
+// columns of reminder_events in the order of model.ReminderEvent fields
+const postgresReminderEventColumns = "id, reminder_id, todo_id, type, state, reminder_time, occurred_at"
+
+func (s *postgresStorage) AddReminderEvent(ctx context.Context, event model.ReminderEvent) error {
+	if event.ID == "" {
+		event.ID = primitive.NewObjectID().Hex()
+	}
+	err := postgresWritePolicy.Do(ctx, "postgres.AddReminderEvent", func() error {
+		_, err := s.pool.Exec(ctx,
+			"INSERT INTO reminder_events ("+postgresReminderEventColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			event.ID, event.ReminderID, event.TodoID, event.Type, event.State, event.ReminderTime, event.OccurredAt)
 		return err
 	})
+	return postgresError(err)
+}
+
+func (s *postgresStorage) GetReminderEvents(ctx context.Context, todoID string) ([]model.ReminderEvent, error) {
+	var events []model.ReminderEvent
+	err := postgresReadPolicy.Do(ctx, "postgres.GetReminderEvents", func() error {
+		events = nil // drop rows read by a failed attempt
+		rows, err := s.pool.Query(ctx,
+			"SELECT "+postgresReminderEventColumns+" FROM reminder_events WHERE todo_id = $1 ORDER BY occurred_at, id", todoID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var event model.ReminderEvent
+			err := rows.Scan(&event.ID, &event.ReminderID, &event.TodoID, &event.Type, &event.State,
+				&event.ReminderTime, &event.OccurredAt)
+			if err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, postgresError(err)
+	}
+	return events, nil
 }
 
-func (s *postgresStorage) DeleteTodo(id string) error {
-	return retryWrapper(maxRetries, retryDelay, func() error {
-		_, err := s.conn.Exec(context.Background(), "DELETE FROM todos WHERE id = $1", id)
+func (s *postgresStorage) DeleteReminderEvents(ctx context.Context, todoID string) error {
+	err := postgresWritePolicy.Do(ctx, "postgres.DeleteReminderEvents", func() error {
+		_, err := s.pool.Exec(ctx, "DELETE FROM reminder_events WHERE todo_id = $1", todoID)
 		return err
 	})
+	return postgresError(err)
+}
+
+func (s *postgresStorage) AddNotification(ctx context.Context, notification model.Notification) (model.Notification, error) {
+	err := postgresWritePolicy.Do(ctx, "postgres.AddNotification", func() error {
+		return s.pool.QueryRow(ctx,
+			"INSERT INTO notifications (type, data, created_at) VALUES ($1, $2, $3) RETURNING id",
+			notification.Type, notification.Data, notification.CreatedAt).
+			Scan(&notification.ID)
+	})
+	if err != nil {
+		return model.Notification{}, postgresError(err)
+	}
+	return notification, nil
+}
+
+func (s *postgresStorage) GetNotifications(ctx context.Context, afterID int64, limit int) ([]model.Notification, error) {
+	var notifications []model.Notification
+	err := postgresReadPolicy.Do(ctx, "postgres.GetNotifications", func() error {
+		notifications = nil // drop rows read by a failed attempt
+		rows, err := s.pool.Query(ctx,
+			"SELECT id, type, data, created_at FROM notifications WHERE id > $1 ORDER BY id LIMIT $2", afterID, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var notification model.Notification
+			if err := rows.Scan(&notification.ID, &notification.Type, &notification.Data, &notification.CreatedAt); err != nil {
+				return err
+			}
+			notifications = append(notifications, notification)
+		}
+		return rows.Err()
+	})
+	return notifications, postgresError(err)
+}
+
+func (s *postgresStorage) DeleteNotifications(ctx context.Context, upToID int64) error {
+	err := postgresWritePolicy.Do(ctx, "postgres.DeleteNotifications", func() error {
+		_, err := s.pool.Exec(ctx, "DELETE FROM notifications WHERE id <= $1", upToID)
+		return err
+	})
+	return postgresError(err)
+}
+
+// columns scanned by postgresOutboxFields
+const postgresOutboxColumns = "id, type, todo_id, todo, old_status, status, attempts, last_error, created_at, next_attempt_at, dropped, delivered"
+
+// returns the scan destinations for postgresOutboxColumns
+func postgresOutboxFields(event *model.OutboxEvent) []interface{} {
+	return []interface{}{&event.ID, &event.Type, &event.TodoID, &event.Todo, &event.OldStatus, &event.Status, &event.Attempts,
+		&event.LastError, &event.CreatedAt, &event.NextAttemptAt, &event.Dropped, &event.Delivered}
+}
+
+func addPostgresOutboxEvent(ctx context.Context, tx pgx.Tx, event model.OutboxEvent) error {
+	_, err := tx.Exec(ctx,
+		"INSERT INTO outbox ("+postgresOutboxColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)",
+		event.ID, event.Type, event.TodoID, event.Todo, event.OldStatus, event.Status, event.Attempts, event.LastError,
+		event.CreatedAt, event.NextAttemptAt, event.Dropped, event.Delivered)
+	return err
+}
+
+func (s *postgresStorage) ClaimOutboxEvents(ctx context.Context, todoID string, lease time.Duration, limit int) ([]model.OutboxEvent, error) {
+	claimedAt := now()
+	// only the oldest pending event of a todo is claimed, a later one waits while it is leased or retried.
+	// SKIP LOCKED: events claimed by another server at the same time are left to it
+	return s.queryOutboxEvents(ctx, "postgres.ClaimOutboxEvents", postgresWritePolicy,
+		"UPDATE outbox SET next_attempt_at = $1 WHERE id IN (SELECT id FROM outbox AS due "+
+			"WHERE status = $2 AND next_attempt_at <= $3 AND ($4 = '' OR todo_id = $4) "+
+			"AND NOT EXISTS (SELECT 1 FROM outbox AS older WHERE older.todo_id = due.todo_id AND older.status = $2 "+
+			"AND (older.created_at, older.id) < (due.created_at, due.id)) "+
+			"ORDER BY created_at, id LIMIT $5 FOR UPDATE SKIP LOCKED) RETURNING "+postgresOutboxColumns,
+		claimedAt.Add(lease), model.OutboxPending, claimedAt, todoID, limit)
+}
+
+func (s *postgresStorage) GetOutboxEvent(ctx context.Context, id string) (model.OutboxEvent, error) {
+	var event model.OutboxEvent
+	err := postgresReadPolicy.Do(ctx, "postgres.GetOutboxEvent", func() error {
+		return s.pool.QueryRow(ctx, "SELECT "+postgresOutboxColumns+" FROM outbox WHERE id = $1", id).
+			Scan(postgresOutboxFields(&event)...)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.OutboxEvent{}, errOutboxEventNotFound(id)
+	}
+	return event, postgresError(err)
+}
+
+func (s *postgresStorage) GetOutboxEvents(ctx context.Context, status model.OutboxStatus, limit int) ([]model.OutboxEvent, error) {
+	return s.queryOutboxEvents(ctx, "postgres.GetOutboxEvents", postgresReadPolicy,
+		"SELECT "+postgresOutboxColumns+" FROM outbox WHERE status = $1 ORDER BY created_at, id LIMIT $2", status, limit)
+}
+
+// returns the events ordered by created_at and id, RETURNING does not keep the order of the subquery
+func (s *postgresStorage) queryOutboxEvents(ctx context.Context, op string, policy RetryPolicy, sql string, args ...interface{}) ([]model.OutboxEvent, error) {
+	var outbox []model.OutboxEvent
+	err := policy.Do(ctx, op, func() error {
+		outbox = nil // drop rows read by a failed attempt
+		rows, err := s.pool.Query(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var event model.OutboxEvent
+			if err := rows.Scan(postgresOutboxFields(&event)...); err != nil {
+				return err
+			}
+			outbox = append(outbox, event)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, postgresError(err)
+	}
+	sortOutboxEvents(outbox)
+	return outbox, nil
+}
+
+func (s *postgresStorage) UpdateOutboxEvent(ctx context.Context, event model.OutboxEvent) error {
+	var tag pgconn.CommandTag
+	err := postgresWritePolicy.Do(ctx, "postgres.UpdateOutboxEvent", func() error {
+		var err error
+		tag, err = s.pool.Exec(ctx,
+			"UPDATE outbox SET status = $1, attempts = $2, last_error = $3, next_attempt_at = $4, dropped = $5, delivered = $6 WHERE id = $7",
+			event.Status, event.Attempts, event.LastError, event.NextAttemptAt, event.Dropped, event.Delivered, event.ID)
+		return err
+	})
+	if err != nil {
+		return postgresError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errOutboxEventNotFound(event.ID)
+	}
+	return nil
+}
+
+func (s *postgresStorage) DeleteOutboxEvents(ctx context.Context, before time.Time) error {
+	err := postgresWritePolicy.Do(ctx, "postgres.DeleteOutboxEvents", func() error {
+		_, err := s.pool.Exec(ctx, "DELETE FROM outbox WHERE status = $1 AND created_at < $2", model.OutboxPublished, before)
+		return err
+	})
+	return postgresError(err)
+}
+
+func (s *postgresStorage) PoolStats() PoolStats {
+	stat := s.pool.Stat()
+	return PoolStats{
+		MaxConns:             stat.MaxConns(),
+		TotalConns:           stat.TotalConns(),
+		AcquiredConns:        stat.AcquiredConns(),
+		IdleConns:            stat.IdleConns(),
+		ConstructingConns:    stat.ConstructingConns(),
+		AcquireCount:         stat.AcquireCount(),
+		EmptyAcquireCount:    stat.EmptyAcquireCount(),
+		CanceledAcquireCount: stat.CanceledAcquireCount(),
+		AcquireDuration:      stat.AcquireDuration(),
+	}
 }
 
 func (s *postgresStorage) Close() {
-	s.conn.Close(context.Background())
+	s.pool.Close()
 }
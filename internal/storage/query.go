@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"toDoList/internal/model"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// timeFormat has a fixed width, so formatted times sort like the times themselves
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// returns the current time with the precision every storage can keep (mongo keeps milliseconds)
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+func isTimeField(field string) bool {
+	return field == model.SortByCreatedAt || field == model.SortByUpdatedAt
+}
+
+// listQuery is validated ListOptions with the decoded cursor
+type listQuery struct {
+	model.ListOptions
+	after *pageCursor // nil for the first page
+}
+
+// pageCursor points to the last todo of the previous page, keyset pagination
+// continues right after it, so inserts and deletes do not shift pages
+type pageCursor struct {
+	SortBy string `json:"s"`
+	Desc   bool   `json:"d,omitempty"`
+	Value  string `json:"v"`
+	ID     string `json:"id"`
+}
+
+func (c *pageCursor) timeValue() (time.Time, error) {
+	return time.Parse(timeFormat, c.Value)
+}
+
+// returns the cursor value with the type of the sort column
+func (c *pageCursor) typedValue() interface{} {
+	if isTimeField(c.SortBy) {
+		t, _ := c.timeValue() // checked in parseListOptions
+		return t
+	}
+	return c.Value
+}
+
+// validates the options and fills in the defaults
+func parseListOptions(opts model.ListOptions) (listQuery, error) {
+	if opts.SortBy == "" {
+		opts.SortBy = model.SortByID
+	}
+	if !model.IsValidSortField(opts.SortBy) {
+		return listQuery{}, fmt.Errorf("invalid sort field %q: %w", opts.SortBy, ErrValidation)
+	}
+	if opts.Status != "" && !model.IsValidStatus(opts.Status) {
+		return listQuery{}, fmt.Errorf("invalid status %q: %w", opts.Status, ErrValidation)
+	}
+	if opts.Limit == 0 {
+		opts.Limit = DefaultPageSize
+	}
+	if opts.Limit < 0 || opts.Limit > MaxPageSize {
+		return listQuery{}, fmt.Errorf("limit must be between 1 and %d: %w", MaxPageSize, ErrValidation)
+	}
+
+	q := listQuery{ListOptions: opts}
+	if opts.Cursor != "" {
+		data, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil {
+			return listQuery{}, fmt.Errorf("invalid cursor: %w", ErrValidation)
+		}
+		var cursor pageCursor
+		if err := json.Unmarshal(data, &cursor); err != nil {
+			return listQuery{}, fmt.Errorf("invalid cursor: %w", ErrValidation)
+		}
+		if cursor.SortBy != opts.SortBy || cursor.Desc != opts.SortDesc {
+			return listQuery{}, fmt.Errorf("cursor was issued for a different sort order: %w", ErrValidation)
+		}
+		if _, err := cursor.timeValue(); isTimeField(cursor.SortBy) && err != nil {
+			return listQuery{}, fmt.Errorf("invalid cursor: %w", ErrValidation)
+		}
+		q.after = &cursor
+	}
+	return q, nil
+}
+
+// returns the value todos are sorted by
+func sortValue(todo model.ToDo, field string) string {
+	switch field {
+	case model.SortByTitle:
+		return todo.Title
+	case model.SortByStatus:
+		return string(todo.Status)
+	case model.SortByCreatedAt:
+		return todo.CreatedAt.UTC().Format(timeFormat)
+	case model.SortByUpdatedAt:
+		return todo.UpdatedAt.UTC().Format(timeFormat)
+	default:
+		return todo.ID
+	}
+}
+
+// builds the page from up to Limit+1 todos read from the storage,
+// the extra todo only shows that there is a next page
+func newPage(todos []model.ToDo, q listQuery) model.TodoPage {
+	if todos == nil {
+		todos = []model.ToDo{}
+	}
+	if len(todos) <= q.Limit {
+		return model.TodoPage{Todos: todos}
+	}
+
+	todos = todos[:q.Limit]
+	last := todos[len(todos)-1]
+	data, _ := json.Marshal(pageCursor{
+		SortBy: q.SortBy,
+		Desc:   q.SortDesc,
+		Value:  sortValue(last, q.SortBy),
+		ID:     last.ID,
+	})
+	return model.TodoPage{Todos: todos, NextCursor: base64.RawURLEncoding.EncodeToString(data)}
+}
+
+// sqlDialect holds the differences of SQL storages
+type sqlDialect struct {
+	placeholder func(n int) string // returns the n-th (1-based) parameter placeholder
+	likeOp      string             // case-insensitive LIKE
+	timeValues  bool               // times are stored as timestamps, not as text in timeFormat
+}
+
+// builds the SELECT for a page of todos
+func buildListSQL(columns string, q listQuery, dialect sqlDialect) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	param := func(value interface{}) string {
+		args = append(args, value)
+		return dialect.placeholder(len(args))
+	}
+
+	if q.Status != "" {
+		where = append(where, "status = "+param(q.Status))
+	}
+	if q.Title != "" {
+		where = append(where, fmt.Sprintf("title %s %s ESCAPE '\\'", dialect.likeOp, param("%"+escapeLike(q.Title)+"%")))
+	}
+
+	// columns are taken from model constants, never from user input
+	column := q.SortBy
+	direction, compare := "ASC", ">"
+	if q.SortDesc {
+		direction, compare = "DESC", "<"
+	}
+	if q.after != nil {
+		if column == model.SortByID {
+			where = append(where, fmt.Sprintf("id %s %s", compare, param(q.after.ID)))
+		} else {
+			var value interface{} = q.after.Value
+			if dialect.timeValues {
+				value = q.after.typedValue()
+			}
+			where = append(where, fmt.Sprintf("(%s, id) %s (%s, %s)", column, compare, param(value), param(q.after.ID)))
+		}
+	}
+
+	sql := "SELECT " + columns + " FROM todos"
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	if column == model.SortByID {
+		sql += fmt.Sprintf(" ORDER BY id %s", direction)
+	} else {
+		sql += fmt.Sprintf(" ORDER BY %s %s, id %s", column, direction, direction)
+	}
+	sql += " LIMIT " + param(q.Limit+1)
+	return sql, args
+}
+
+// builds the UPDATE that writes only the fields set in the patch and returns the todo,
+// a non-zero version must match the stored version
+func buildPatchSQL(columns string, id string, patch model.ToDoPatch, version int64, updatedAt interface{}, dialect sqlDialect) (string, []interface{}) {
+	var args []interface{}
+	param := func(value interface{}) string {
+		args = append(args, value)
+		return dialect.placeholder(len(args))
+	}
+
+	var set []string
+	if patch.Title != nil {
+		set = append(set, "title = "+param(*patch.Title))
+	}
+	if patch.Status != nil {
+		set = append(set, "status = "+param(*patch.Status))
+	}
+	if patch.DueAt != nil {
+		var dueAt interface{} = *patch.DueAt
+		if !dialect.timeValues {
+			dueAt = formatSqliteTime(*patch.DueAt)
+		}
+		set = append(set, "due_at = "+param(dueAt))
+	}
+	set = append(set, "updated_at = "+param(updatedAt), "version = version + 1")
+
+	sql := "UPDATE todos SET " + strings.Join(set, ", ") + " WHERE id = " + param(id)
+	if version > 0 {
+		sql += " AND version = " + param(version)
+	}
+	return sql + " RETURNING " + columns, args
+}
+
+// escapes LIKE wildcards, so the title is matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
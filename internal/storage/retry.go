@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// retry counters, published on /debug/vars
+var (
+	retryCount     = expvar.NewMap("storage_retries")           // retries per operation
+	retryExhausted = expvar.NewMap("storage_retries_exhausted") // operations that failed after all attempts
+	retryRecovered = expvar.NewMap("storage_retries_recovered") // operations that succeeded after a retry
+)
+
+// RetryPolicy describes when and how often a failed operation is repeated
+type RetryPolicy struct {
+	MaxAttempts  int           // attempts including the first one
+	InitialDelay time.Duration // delay before the first retry
+	MaxDelay     time.Duration // upper limit for a single delay, zero means no limit
+	MaxElapsed   time.Duration // no new attempt is started after this time, zero means no limit
+	Multiplier   float64       // delay growth for each subsequent attempt
+	Jitter       float64       // part of the delay (0..1) that is randomized, spreads retries of parallel requests
+	IsRetryable  func(err error) bool
+}
+
+// Do runs operation until it succeeds, returns a permanent error, runs out of attempts
+// or ctx is done. op names the operation in logs and metrics.
+func (p RetryPolicy) Do(ctx context.Context, op string, operation func() error) error {
+	start := time.Now()
+	delay := p.InitialDelay
+
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if err == nil {
+			if attempt > 1 {
+				retryRecovered.Add(op, 1)
+				log.Printf("%s succeeded after %d retries", op, attempt-1)
+			}
+			return nil
+		}
+		if ctx.Err() != nil || (p.IsRetryable != nil && !p.IsRetryable(err)) {
+			return err
+		}
+		if attempt >= p.MaxAttempts {
+			retryExhausted.Add(op, 1)
+			return fmt.Errorf("%s failed after %d attempts: %w", op, attempt, err)
+		}
+
+		wait := p.jittered(delay)
+		if p.MaxElapsed > 0 && time.Since(start)+wait > p.MaxElapsed {
+			retryExhausted.Add(op, 1)
+			return fmt.Errorf("%s failed after %d attempts in %v: %w", op, attempt, time.Since(start).Round(time.Millisecond), err)
+		}
+
+		retryCount.Add(op, 1)
+		log.Printf("%s failed (attempt %d of %d), retrying in %v: %v", op, attempt, p.MaxAttempts, wait, err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%s cancelled: %w (last error: %v)", op, ctx.Err(), err)
+		case <-timer.C:
+		}
+
+		delay = time.Duration(float64(delay) * p.Multiplier)
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+}
+
+// randomly shortens the delay by up to Jitter of its length
+func (p RetryPolicy) jittered(delay time.Duration) time.Duration {
+	if p.Jitter <= 0 {
+		return delay
+	}
+	return delay - time.Duration(p.Jitter*rand.Float64()*float64(delay))
+}
@@ -0,0 +1,717 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"toDoList/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"modernc.org/sqlite" // also registers the "sqlite" driver
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteSchema holds schema changes in the order they are applied,
+// the index of the last applied statement is kept in PRAGMA user_version.
+// New changes must be appended to the end, never edit the existing ones.
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS todos (
+		id TEXT PRIMARY KEY,
+		title TEXT NOT NULL,
+		status TEXT NOT NULL CHECK (status IN ('created', 'in progress', 'done')),
+		image_path TEXT NOT NULL DEFAULT '',
+		reminder_time TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX IF NOT EXISTS todos_title_id_idx ON todos (title, id)`,
+	`CREATE INDEX IF NOT EXISTS todos_status_id_idx ON todos (status, id)`,
+	// times are stored as text in timeFormat, so they compare and sort as strings
+	`ALTER TABLE todos ADD COLUMN created_at TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE todos ADD COLUMN updated_at TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE todos ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+	`UPDATE todos SET created_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now'), updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now')
+		WHERE created_at = ''`,
+	`CREATE INDEX IF NOT EXISTS todos_created_at_id_idx ON todos (created_at, id)`,
+	`CREATE INDEX IF NOT EXISTS todos_updated_at_id_idx ON todos (updated_at, id)`,
+	`CREATE TABLE IF NOT EXISTS reminders (
+		id TEXT PRIMARY KEY,
+		todo_id TEXT NOT NULL,
+		task_name TEXT NOT NULL,
+		reminder_time TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS reminders_reminder_time_idx ON reminders (reminder_time)`,
+	`ALTER TABLE reminders ADD COLUMN state TEXT NOT NULL DEFAULT 'pending'
+		CHECK (state IN ('pending', 'delivered', 'acknowledged'))`,
+	`CREATE INDEX IF NOT EXISTS reminders_state_reminder_time_idx ON reminders (state, reminder_time)`,
+	// reminder_at is empty for todos without a reminder
+	`ALTER TABLE todos ADD COLUMN time_zone TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE todos ADD COLUMN reminder_at TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE reminders ADD COLUMN recurrence TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE reminders ADD COLUMN time_zone TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS reminder_events (
+		id TEXT PRIMARY KEY,
+		reminder_id TEXT NOT NULL,
+		todo_id TEXT NOT NULL,
+		type TEXT NOT NULL,
+		state TEXT NOT NULL DEFAULT '',
+		reminder_time TEXT NOT NULL,
+		occurred_at TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS reminder_events_todo_id_occurred_at_idx ON reminder_events (todo_id, occurred_at, id)`,
+	// due_at is empty for todos without a due date
+	`ALTER TABLE todos ADD COLUMN due_at TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE reminders ADD COLUMN due_offset TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE reminders ADD COLUMN channel TEXT NOT NULL DEFAULT 'sse'`,
+	`ALTER TABLE reminders ADD COLUMN message TEXT NOT NULL DEFAULT ''`,
+	// AUTOINCREMENT, so IDs of deleted notifications are not reused
+	`CREATE TABLE IF NOT EXISTS notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		type TEXT NOT NULL,
+		data TEXT NOT NULL,
+		created_at TEXT NOT NULL
+	)`,
+	// events of todo changes, written in the same transaction as the change and published by the relay
+	`CREATE TABLE IF NOT EXISTS outbox (
+		id TEXT PRIMARY KEY,
+		type TEXT NOT NULL,
+		todo_id TEXT NOT NULL,
+		todo TEXT NOT NULL,
+		old_status TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'published', 'dead')),
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL,
+		next_attempt_at TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS outbox_status_next_attempt_at_idx ON outbox (status, next_attempt_at, created_at, id)`,
+	// a claim looks for older pending events of the same todo
+	`CREATE INDEX IF NOT EXISTS outbox_todo_id_status_created_at_idx ON outbox (todo_id, status, created_at, id)`,
+	// async subscribers that dropped an event, they get events at most once
+	`ALTER TABLE outbox ADD COLUMN dropped INTEGER NOT NULL DEFAULT 0`,
+	// bus events of an outbox event every subscriber got, a retry skips them
+	`ALTER TABLE outbox ADD COLUMN delivered INTEGER NOT NULL DEFAULT 0`,
+}
+
+// columns scanned by scanSqliteTodo
+const sqliteTodoColumns = "id, title, status, image_path, reminder_time, time_zone, reminder_at, due_at, created_at, updated_at, version"
+
+// LIKE ignores case of ASCII letters in sqlite
+var sqliteDialect = sqlDialect{
+	placeholder: func(int) string { return "?" },
+	likeOp:      "LIKE",
+}
+
+type sqliteStorage struct {
+	db *sql.DB
+}
+
+func NewSqliteDb(path string) (*sqliteStorage, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("could not open sqlite: %v", err)
+	}
+	// sqlite allows only one writer at a time, so a single connection avoids "database is locked" errors
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not open sqlite: %v", err)
+	}
+
+	if err := migrateSqlite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create sqlite schema: %v", err)
+	}
+	return &sqliteStorage{db: db}, nil
+}
+
+// applies schema statements that were not applied yet
+func migrateSqlite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(sqliteSchema); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteSchema[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("schema version %d: %v", i+1, err)
+		}
+		// PRAGMA does not accept parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("SQLite schema upgraded to version %d", i+1)
+	}
+	return nil
+}
+
+// formats an optional time for a text column, nil is empty
+func formatSqliteTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(timeFormat)
+}
+
+// parses an optional time of a text column, empty is nil
+func parseSqliteTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(timeFormat, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// reads a row of sqliteTodoColumns, scan is Scan of sql.Row or sql.Rows
+func scanSqliteTodo(scan func(dest ...interface{}) error) (model.ToDo, error) {
+	var todo model.ToDo
+	var reminderAt, dueAt, createdAt, updatedAt string
+	err := scan(&todo.ID, &todo.Title, &todo.Status, &todo.ImagePath, &todo.ReminderTime, &todo.TimeZone, &reminderAt, &dueAt,
+		&createdAt, &updatedAt, &todo.Version)
+	if err != nil {
+		return model.ToDo{}, err
+	}
+	if todo.ReminderAt, err = parseSqliteTime(reminderAt); err != nil {
+		return model.ToDo{}, fmt.Errorf("invalid reminder_at of todo %v: %v", todo.ID, err)
+	}
+	if todo.DueAt, err = parseSqliteTime(dueAt); err != nil {
+		return model.ToDo{}, fmt.Errorf("invalid due_at of todo %v: %v", todo.ID, err)
+	}
+	if todo.CreatedAt, err = time.Parse(timeFormat, createdAt); err != nil {
+		return model.ToDo{}, fmt.Errorf("invalid created_at of todo %v: %v", todo.ID, err)
+	}
+	if todo.UpdatedAt, err = time.Parse(timeFormat, updatedAt); err != nil {
+		return model.ToDo{}, fmt.Errorf("invalid updated_at of todo %v: %v", todo.ID, err)
+	}
+	return todo, nil
+}
+
+func (s *sqliteStorage) AddTodo(ctx context.Context, todo model.ToDo) (model.ToDo, error) {
+	if todo.ID == "" {
+		todo.ID = primitive.NewObjectID().Hex()
+	}
+	todo.CreatedAt = now()
+	todo.UpdatedAt = todo.CreatedAt
+	todo.Version = 1
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO todos ("+sqliteTodoColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			todo.ID, todo.Title, todo.Status, todo.ImagePath, todo.ReminderTime, todo.TimeZone,
+			formatSqliteTime(todo.ReminderAt), formatSqliteTime(todo.DueAt),
+			todo.CreatedAt.Format(timeFormat), todo.UpdatedAt.Format(timeFormat), todo.Version)
+		if err != nil {
+			return err
+		}
+		event, err := createdEvent(todo)
+		if err != nil {
+			return err
+		}
+		return addSqliteOutboxEvent(ctx, tx, event)
+	})
+	if errors.Is(sqliteError(err), ErrConflict) {
+		return model.ToDo{}, errTodoExists(todo.ID)
+	}
+	if err != nil {
+		return model.ToDo{}, sqliteError(err)
+	}
+	return todo, nil
+}
+
+func (s *sqliteStorage) GetTodos(ctx context.Context, opts model.ListOptions) (model.TodoPage, error) {
+	q, err := parseListOptions(opts)
+	if err != nil {
+		return model.TodoPage{}, err
+	}
+	query, args := buildListSQL(sqliteTodoColumns, q, sqliteDialect)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return model.TodoPage{}, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var todos []model.ToDo
+	for rows.Next() {
+		todo, err := scanSqliteTodo(rows.Scan)
+		if err != nil {
+			return model.TodoPage{}, err
+		}
+		todos = append(todos, todo)
+	}
+	if err := rows.Err(); err != nil {
+		return model.TodoPage{}, sqliteError(err)
+	}
+	return newPage(todos, q), nil
+}
+
+func (s *sqliteStorage) GetTodoById(ctx context.Context, id string) (model.ToDo, error) {
+	todo, err := scanSqliteTodo(s.db.QueryRowContext(ctx,
+		"SELECT "+sqliteTodoColumns+" FROM todos WHERE id = ?", id).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Todo not found for ID: %v", id)
+		return model.ToDo{}, errTodoNotFound(id)
+	}
+	return todo, sqliteError(err)
+}
+
+func (s *sqliteStorage) GetTodoImageById(ctx context.Context, id string) (model.ToDo, error) {
+	var todo model.ToDo
+	err := s.db.QueryRowContext(ctx, "SELECT image_path FROM todos WHERE id = ?", id).
+		Scan(&todo.ImagePath)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.ToDo{}, errTodoNotFound(id)
+	}
+	return todo, sqliteError(err)
+}
+
+func (s *sqliteStorage) UpdateTodo(ctx context.Context, id string, todo model.ToDo) (model.ToDo, error) {
+	return s.updateTodo(ctx, id, todo.Version,
+		"UPDATE todos SET title = ?, status = ?, due_at = ?, updated_at = ?, version = version + 1 "+
+			"WHERE id = ? AND (? = 0 OR version = ?) RETURNING "+sqliteTodoColumns,
+		todo.Title, todo.Status, formatSqliteTime(todo.DueAt), now().Format(timeFormat), id, todo.Version, todo.Version)
+}
+
+func (s *sqliteStorage) PatchTodo(ctx context.Context, id string, patch model.ToDoPatch, version int64) (model.ToDo, error) {
+	query, args := buildPatchSQL(sqliteTodoColumns, id, patch, version, now().Format(timeFormat), sqliteDialect)
+	return s.updateTodo(ctx, id, version, query, args...)
+}
+
+// runs an UPDATE of the todo returning sqliteTodoColumns and writes its outbox event in the same transaction
+func (s *sqliteStorage) updateTodo(ctx context.Context, id string, version int64, query string, args ...interface{}) (model.ToDo, error) {
+	var updated model.ToDo
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var oldStatus model.Status
+		if err := tx.QueryRowContext(ctx, "SELECT status FROM todos WHERE id = ?", id).Scan(&oldStatus); err != nil {
+			return err
+		}
+		var err error
+		if updated, err = scanSqliteTodo(tx.QueryRowContext(ctx, query, args...).Scan); err != nil {
+			return err
+		}
+		event, err := updatedEvent(updated, oldStatus)
+		if err != nil {
+			return err
+		}
+		return addSqliteOutboxEvent(ctx, tx, event)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.ToDo{}, s.missingOrStale(ctx, id, version)
+	}
+	if err != nil {
+		return model.ToDo{}, sqliteError(err)
+	}
+	return updated, nil
+}
+
+// tells why a statement with the version touched no row
+func (s *sqliteStorage) missingOrStale(ctx context.Context, id string, version int64) error {
+	if version == 0 {
+		return errTodoNotFound(id)
+	}
+	if _, err := s.GetTodoById(ctx, id); err != nil {
+		return err
+	}
+	return errStaleVersion(id, version)
+}
+
+func (s *sqliteStorage) UpdateTodoImage(ctx context.Context, id string, imagePath string) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		updated, err := scanSqliteTodo(tx.QueryRowContext(ctx,
+			"UPDATE todos SET image_path = ?, updated_at = ?, version = version + 1 WHERE id = ? RETURNING "+sqliteTodoColumns,
+			imagePath, now().Format(timeFormat), id).Scan)
+		if err != nil {
+			return err
+		}
+		event, err := imageUploadedEvent(updated)
+		if err != nil {
+			return err
+		}
+		return addSqliteOutboxEvent(ctx, tx, event)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return errTodoNotFound(id)
+	}
+	return sqliteError(err)
+}
+
+func (s *sqliteStorage) DeleteTodo(ctx context.Context, id string, version int64) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM todos WHERE id = ? AND (? = 0 OR version = ?)", id, version, version)
+		if err := checkAffected(res, err, id); err != nil {
+			return err
+		}
+		event, err := deletedEvent(id)
+		if err != nil {
+			return err
+		}
+		return addSqliteOutboxEvent(ctx, tx, event)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return s.missingOrStale(ctx, id, version)
+	}
+	return sqliteError(err)
+}
+
+// runs fn in a transaction, it is committed if fn returns nil. The only connection is taken
+// until it ends, so fn must use tx and not s.db
+func (s *sqliteStorage) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStorage) AddReminder(ctx context.Context, reminder model.Reminder) (model.Reminder, error) {
+	if reminder.ID == "" {
+		reminder.ID = primitive.NewObjectID().Hex()
+	}
+	if reminder.State == "" {
+		reminder.State = model.ReminderPending
+	}
+	if reminder.Channel == "" {
+		reminder.Channel = model.ChannelSSE
+	}
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO reminders ("+sqliteReminderColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		reminder.ID, reminder.TodoID, reminder.TaskName, reminder.ReminderTime.UTC().Format(timeFormat), reminder.State,
+		reminder.Recurrence, reminder.TimeZone, reminder.DueOffset, reminder.Channel, reminder.Message)
+	if err != nil {
+		return model.Reminder{}, sqliteError(err)
+	}
+	return reminder, nil
+}
+
+// columns scanned by scanSqliteReminder
+const sqliteReminderColumns = "id, todo_id, task_name, reminder_time, state, recurrence, time_zone, due_offset, channel, message"
+
+// reads a row of sqliteReminderColumns, scan is Scan of sql.Row or sql.Rows
+func scanSqliteReminder(scan func(dest ...interface{}) error) (model.Reminder, error) {
+	var reminder model.Reminder
+	var reminderTime string
+	err := scan(&reminder.ID, &reminder.TodoID, &reminder.TaskName, &reminderTime, &reminder.State,
+		&reminder.Recurrence, &reminder.TimeZone, &reminder.DueOffset, &reminder.Channel, &reminder.Message)
+	if err != nil {
+		return model.Reminder{}, err
+	}
+	if reminder.ReminderTime, err = time.Parse(timeFormat, reminderTime); err != nil {
+		return model.Reminder{}, fmt.Errorf("invalid reminder_time of reminder %v: %v", reminder.ID, err)
+	}
+	return reminder, nil
+}
+
+func (s *sqliteStorage) GetReminder(ctx context.Context, id string) (model.Reminder, error) {
+	reminder, err := scanSqliteReminder(s.db.QueryRowContext(ctx,
+		"SELECT "+sqliteReminderColumns+" FROM reminders WHERE id = ?", id).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.Reminder{}, errReminderNotFound(id)
+	}
+	return reminder, sqliteError(err)
+}
+
+func (s *sqliteStorage) GetReminders(ctx context.Context, state model.ReminderState) ([]model.Reminder, error) {
+	return s.queryReminders(ctx,
+		"SELECT "+sqliteReminderColumns+" FROM reminders WHERE (? = '' OR state = ?) ORDER BY reminder_time, id",
+		state, state)
+}
+
+func (s *sqliteStorage) GetTodoReminders(ctx context.Context, todoID string) ([]model.Reminder, error) {
+	return s.queryReminders(ctx,
+		"SELECT "+sqliteReminderColumns+" FROM reminders WHERE todo_id = ? ORDER BY reminder_time, id", todoID)
+}
+
+// returns the reminders read by the query
+func (s *sqliteStorage) queryReminders(ctx context.Context, query string, args ...interface{}) ([]model.Reminder, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var reminders []model.Reminder
+	for rows.Next() {
+		reminder, err := scanSqliteReminder(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, reminder)
+	}
+	return reminders, sqliteError(rows.Err())
+}
+
+func (s *sqliteStorage) UpdateReminder(ctx context.Context, reminder model.Reminder) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE reminders SET task_name = ?, reminder_time = ? WHERE id = ?",
+		reminder.TaskName, reminder.ReminderTime.UTC().Format(timeFormat), reminder.ID)
+	err = checkAffected(res, err, reminder.ID)
+	if errors.Is(err, ErrNotFound) {
+		return errReminderNotFound(reminder.ID)
+	}
+	return err
+}
+
+func (s *sqliteStorage) SetReminderState(ctx context.Context, id string, from, to model.ReminderState) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE reminders SET state = ? WHERE id = ? AND state = ?", to, id, from)
+	err = checkAffected(res, err, id)
+	if errors.Is(err, ErrNotFound) {
+		return reminderStateError(ctx, s, id)
+	}
+	return err
+}
+
+func (s *sqliteStorage) SnoozeReminder(ctx context.Context, id string, at time.Time) (model.Reminder, error) {
+	reminder, err := scanSqliteReminder(s.db.QueryRowContext(ctx,
+		"UPDATE reminders SET reminder_time = ?, state = ? WHERE id = ? AND state IN (?, ?) RETURNING "+sqliteReminderColumns,
+		at.UTC().Format(timeFormat), model.ReminderPending, id, model.ReminderPending, model.ReminderDelivered).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.Reminder{}, reminderStateError(ctx, s, id)
+	}
+	if err != nil {
+		return model.Reminder{}, sqliteError(err)
+	}
+	return reminder, nil
+}
+
+func (s *sqliteStorage) DeleteReminder(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM reminders WHERE id = ?", id)
+	err = checkAffected(res, err, id)
+	if errors.Is(err, ErrNotFound) {
+		return errReminderNotFound(id)
+	}
+	return err
+}
+
+// columns of reminder_events in the order of model.ReminderEvent fields
+const sqliteReminderEventColumns = "id, reminder_id, todo_id, type, state, reminder_time, occurred_at"
+
+func (s *sqliteStorage) AddReminderEvent(ctx context.Context, event model.ReminderEvent) error {
+	if event.ID == "" {
+		event.ID = primitive.NewObjectID().Hex()
+	}
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO reminder_events ("+sqliteReminderEventColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)",
+		event.ID, event.ReminderID, event.TodoID, event.Type, event.State,
+		event.ReminderTime.UTC().Format(timeFormat), event.OccurredAt.UTC().Format(timeFormat))
+	return sqliteError(err)
+}
+
+func (s *sqliteStorage) GetReminderEvents(ctx context.Context, todoID string) ([]model.ReminderEvent, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+sqliteReminderEventColumns+" FROM reminder_events WHERE todo_id = ? ORDER BY occurred_at, id", todoID)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var events []model.ReminderEvent
+	for rows.Next() {
+		var event model.ReminderEvent
+		var reminderTime, occurredAt string
+		err := rows.Scan(&event.ID, &event.ReminderID, &event.TodoID, &event.Type, &event.State, &reminderTime, &occurredAt)
+		if err != nil {
+			return nil, sqliteError(err)
+		}
+		if event.ReminderTime, err = time.Parse(timeFormat, reminderTime); err != nil {
+			return nil, fmt.Errorf("invalid reminder_time of reminder event %v: %v", event.ID, err)
+		}
+		if event.OccurredAt, err = time.Parse(timeFormat, occurredAt); err != nil {
+			return nil, fmt.Errorf("invalid occurred_at of reminder event %v: %v", event.ID, err)
+		}
+		events = append(events, event)
+	}
+	return events, sqliteError(rows.Err())
+}
+
+func (s *sqliteStorage) DeleteReminderEvents(ctx context.Context, todoID string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM reminder_events WHERE todo_id = ?", todoID)
+	return sqliteError(err)
+}
+
+func (s *sqliteStorage) AddNotification(ctx context.Context, notification model.Notification) (model.Notification, error) {
+	res, err := s.db.ExecContext(ctx, "INSERT INTO notifications (type, data, created_at) VALUES (?, ?, ?)",
+		notification.Type, notification.Data, notification.CreatedAt.UTC().Format(timeFormat))
+	if err != nil {
+		return model.Notification{}, sqliteError(err)
+	}
+	if notification.ID, err = res.LastInsertId(); err != nil {
+		return model.Notification{}, sqliteError(err)
+	}
+	return notification, nil
+}
+
+func (s *sqliteStorage) GetNotifications(ctx context.Context, afterID int64, limit int) ([]model.Notification, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, type, data, created_at FROM notifications WHERE id > ? ORDER BY id LIMIT ?", afterID, limit)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var notifications []model.Notification
+	for rows.Next() {
+		var notification model.Notification
+		var createdAt string
+		if err := rows.Scan(&notification.ID, &notification.Type, &notification.Data, &createdAt); err != nil {
+			return nil, sqliteError(err)
+		}
+		if notification.CreatedAt, err = time.Parse(timeFormat, createdAt); err != nil {
+			return nil, fmt.Errorf("invalid created_at of notification %v: %v", notification.ID, err)
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, sqliteError(rows.Err())
+}
+
+func (s *sqliteStorage) DeleteNotifications(ctx context.Context, upToID int64) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM notifications WHERE id <= ?", upToID)
+	return sqliteError(err)
+}
+
+// columns scanned by scanSqliteOutboxEvent
+const sqliteOutboxColumns = "id, type, todo_id, todo, old_status, status, attempts, last_error, created_at, next_attempt_at, dropped, delivered"
+
+func addSqliteOutboxEvent(ctx context.Context, tx *sql.Tx, event model.OutboxEvent) error {
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO outbox ("+sqliteOutboxColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		event.ID, event.Type, event.TodoID, event.Todo, event.OldStatus, event.Status, event.Attempts, event.LastError,
+		event.CreatedAt.UTC().Format(timeFormat), event.NextAttemptAt.UTC().Format(timeFormat), event.Dropped, event.Delivered)
+	return err
+}
+
+// reads a row of sqliteOutboxColumns, scan is Scan of sql.Row or sql.Rows
+func scanSqliteOutboxEvent(scan func(dest ...interface{}) error) (model.OutboxEvent, error) {
+	var event model.OutboxEvent
+	var createdAt, nextAttemptAt string
+	err := scan(&event.ID, &event.Type, &event.TodoID, &event.Todo, &event.OldStatus, &event.Status, &event.Attempts,
+		&event.LastError, &createdAt, &nextAttemptAt, &event.Dropped, &event.Delivered)
+	if err != nil {
+		return model.OutboxEvent{}, err
+	}
+	if event.CreatedAt, err = time.Parse(timeFormat, createdAt); err != nil {
+		return model.OutboxEvent{}, fmt.Errorf("invalid created_at of outbox event %v: %v", event.ID, err)
+	}
+	if event.NextAttemptAt, err = time.Parse(timeFormat, nextAttemptAt); err != nil {
+		return model.OutboxEvent{}, fmt.Errorf("invalid next_attempt_at of outbox event %v: %v", event.ID, err)
+	}
+	return event, nil
+}
+
+func (s *sqliteStorage) ClaimOutboxEvents(ctx context.Context, todoID string, lease time.Duration, limit int) ([]model.OutboxEvent, error) {
+	claimedAt := now()
+	return s.queryOutboxEvents(ctx,
+		"UPDATE outbox SET next_attempt_at = ? WHERE id IN (SELECT id FROM outbox AS due "+
+			"WHERE status = ? AND next_attempt_at <= ? AND (? = '' OR todo_id = ?) "+
+			"AND NOT EXISTS (SELECT 1 FROM outbox AS older WHERE older.todo_id = due.todo_id AND older.status = due.status "+
+			"AND (older.created_at, older.id) < (due.created_at, due.id)) "+
+			"ORDER BY created_at, id LIMIT ?) RETURNING "+sqliteOutboxColumns,
+		claimedAt.Add(lease).Format(timeFormat), model.OutboxPending, claimedAt.Format(timeFormat), todoID, todoID, limit)
+}
+
+func (s *sqliteStorage) GetOutboxEvent(ctx context.Context, id string) (model.OutboxEvent, error) {
+	event, err := scanSqliteOutboxEvent(s.db.QueryRowContext(ctx,
+		"SELECT "+sqliteOutboxColumns+" FROM outbox WHERE id = ?", id).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.OutboxEvent{}, errOutboxEventNotFound(id)
+	}
+	return event, sqliteError(err)
+}
+
+func (s *sqliteStorage) GetOutboxEvents(ctx context.Context, status model.OutboxStatus, limit int) ([]model.OutboxEvent, error) {
+	return s.queryOutboxEvents(ctx,
+		"SELECT "+sqliteOutboxColumns+" FROM outbox WHERE status = ? ORDER BY created_at, id LIMIT ?", status, limit)
+}
+
+// returns the events ordered by created_at and id, RETURNING does not keep the order of the subquery
+func (s *sqliteStorage) queryOutboxEvents(ctx context.Context, query string, args ...interface{}) ([]model.OutboxEvent, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var outbox []model.OutboxEvent
+	for rows.Next() {
+		event, err := scanSqliteOutboxEvent(rows.Scan)
+		if err != nil {
+			return nil, sqliteError(err)
+		}
+		outbox = append(outbox, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+	sortOutboxEvents(outbox)
+	return outbox, nil
+}
+
+func (s *sqliteStorage) UpdateOutboxEvent(ctx context.Context, event model.OutboxEvent) error {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE outbox SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ?, dropped = ?, delivered = ? WHERE id = ?",
+		event.Status, event.Attempts, event.LastError, event.NextAttemptAt.UTC().Format(timeFormat), event.Dropped,
+		event.Delivered, event.ID)
+	err = checkAffected(res, err, event.ID)
+	if errors.Is(err, ErrNotFound) {
+		return errOutboxEventNotFound(event.ID)
+	}
+	return err
+}
+
+func (s *sqliteStorage) DeleteOutboxEvents(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM outbox WHERE status = ? AND created_at < ?",
+		model.OutboxPublished, before.UTC().Format(timeFormat))
+	return sqliteError(err)
+}
+
+func (s *sqliteStorage) Close() {
+	s.db.Close()
+}
+
+// returns an error if the statement did not touch any row
+func checkAffected(res sql.Result, err error, id string) error {
+	if err != nil {
+		return sqliteError(err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errTodoNotFound(id)
+	}
+	return nil
+}
+
+// converts sqlite errors into storage errors, other errors are returned as is
+func sqliteError(err error) error {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+
+	switch code := sqliteErr.Code(); {
+	case code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY, code == sqlite3.SQLITE_CONSTRAINT_UNIQUE:
+		return wrapError(ErrConflict, err)
+	case code&0xff == sqlite3.SQLITE_CONSTRAINT:
+		return wrapError(ErrValidation, err)
+	case code&0xff == sqlite3.SQLITE_BUSY, code&0xff == sqlite3.SQLITE_LOCKED:
+		return wrapError(ErrUnavailable, err)
+	}
+	return err
+}
@@ -0,0 +1,18 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+	"toDoList/internal/storage"
+	"toDoList/internal/storage/storagetest"
+)
+
+func TestSqliteConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, err := storage.NewSqliteDb(filepath.Join(t.TempDir(), "todos.db"))
+		if err != nil {
+			t.Fatalf("NewSqliteDb failed: %v", err)
+		}
+		return s
+	})
+}
@@ -0,0 +1,1058 @@
+// Package storagetest contains a conformance suite for storage.Storage implementations.
+//
+// A backend runs it from its own test with a constructor that returns an empty storage:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) storage.Storage {
+//			return storage.NewMemoryDb()
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"toDoList/internal/model"
+	"toDoList/internal/storage"
+)
+
+var ctx = context.Background()
+
+// Run executes every conformance check, newStorage must return an empty storage for each call
+func Run(t *testing.T, newStorage func(t *testing.T) storage.Storage) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.Storage)
+	}{
+		{"AddAndGet", testAddAndGet},
+		{"AddReminderAt", testAddReminderAt},
+		{"DueDate", testDueDate},
+		{"AddGeneratesID", testAddGeneratesID},
+		{"AddDuplicateID", testAddDuplicateID},
+		{"GetMissing", testGetMissing},
+		{"GetTodosEmpty", testGetTodosEmpty},
+		{"GetTodosOrderedByID", testGetTodosOrderedByID},
+		{"GetTodosFilter", testGetTodosFilter},
+		{"GetTodosSort", testGetTodosSort},
+		{"GetTodosSortByTime", testGetTodosSortByTime},
+		{"GetTodosPagination", testGetTodosPagination},
+		{"GetTodosInvalidOptions", testGetTodosInvalidOptions},
+		{"Update", testUpdate},
+		{"UpdateMissing", testUpdateMissing},
+		{"UpdateVersion", testUpdateVersion},
+		{"UpdateStaleVersion", testUpdateStaleVersion},
+		{"Patch", testPatch},
+		{"PatchMissing", testPatchMissing},
+		{"PatchStaleVersion", testPatchStaleVersion},
+		{"UpdateImage", testUpdateImage},
+		{"UpdateImageMissing", testUpdateImageMissing},
+		{"Delete", testDelete},
+		{"DeleteMissing", testDeleteMissing},
+		{"DeleteVersion", testDeleteVersion},
+		{"Reminders", testReminders},
+		{"ReminderStates", testReminderStates},
+		{"ReminderDelivery", testReminderDelivery},
+		{"DeleteReminder", testDeleteReminder},
+		{"TodoReminders", testTodoReminders},
+		{"UpdateReminder", testUpdateReminder},
+		{"SnoozeReminder", testSnoozeReminder},
+		{"ReminderEvents", testReminderEvents},
+		{"Notifications", testNotifications},
+		{"Outbox", testOutbox},
+		{"OutboxEvents", testOutboxEvents},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStorage(t)
+			t.Cleanup(s.Close)
+			tt.fn(t, s)
+		})
+	}
+}
+
+func mustAdd(t *testing.T, s storage.Storage, todo model.ToDo) model.ToDo {
+	t.Helper()
+	added, err := s.AddTodo(ctx, todo)
+	if err != nil {
+		t.Fatalf("AddTodo(%q) failed: %v", todo.ID, err)
+	}
+	return added
+}
+
+func mustGet(t *testing.T, s storage.Storage, id string) model.ToDo {
+	t.Helper()
+	todo, err := s.GetTodoById(ctx, id)
+	if err != nil {
+		t.Fatalf("GetTodoById(%q) failed: %v", id, err)
+	}
+	return todo
+}
+
+func mustList(t *testing.T, s storage.Storage, opts model.ListOptions) model.TodoPage {
+	t.Helper()
+	page, err := s.GetTodos(ctx, opts)
+	if err != nil {
+		t.Fatalf("GetTodos(%+v) failed: %v", opts, err)
+	}
+	return page
+}
+
+// compares todos field by field, time.Time values must be compared with Equal
+func sameTodo(a, b model.ToDo) bool {
+	return a.ID == b.ID && a.Title == b.Title && a.Status == b.Status && a.ImagePath == b.ImagePath &&
+		a.ReminderTime == b.ReminderTime && a.TimeZone == b.TimeZone && sameInstant(a.ReminderAt, b.ReminderAt) &&
+		sameInstant(a.DueAt, b.DueAt) && a.CreatedAt.Equal(b.CreatedAt) && a.UpdatedAt.Equal(b.UpdatedAt) &&
+		a.Version == b.Version
+}
+
+func todoIDs(todos []model.ToDo) []string {
+	ids := []string{}
+	for _, todo := range todos {
+		ids = append(ids, todo.ID)
+	}
+	return ids
+}
+
+func expectIDs(t *testing.T, what string, todos []model.ToDo, want ...string) {
+	t.Helper()
+	got := todoIDs(todos)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("%s returned IDs %v, want %v", what, got, want)
+	}
+}
+
+func testAddAndGet(t *testing.T, s storage.Storage) {
+	before := time.Now().Add(-time.Second)
+	// timestamps and version of the argument are ignored
+	added := mustAdd(t, s, model.ToDo{ID: "1", Title: "Buy milk", Status: model.Created, ReminderTime: "1h",
+		CreatedAt: time.Unix(0, 0), Version: 7})
+
+	if added.ID != "1" || added.Title != "Buy milk" || added.Status != model.Created || added.ReminderTime != "1h" {
+		t.Errorf("AddTodo returned %+v", added)
+	}
+	if added.Version != 1 {
+		t.Errorf("AddTodo returned version %d, want 1", added.Version)
+	}
+	if added.CreatedAt.Before(before) || added.CreatedAt.After(time.Now()) || !added.UpdatedAt.Equal(added.CreatedAt) {
+		t.Errorf("AddTodo returned created_at %v and updated_at %v, want the current time", added.CreatedAt, added.UpdatedAt)
+	}
+
+	if got := mustGet(t, s, "1"); !sameTodo(got, added) {
+		t.Errorf("GetTodoById returned %+v, want %+v", got, added)
+	}
+}
+
+// reports whether both times are unset or are the same instant
+func sameInstant(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func testAddReminderAt(t *testing.T, s storage.Storage) {
+	// storages keep milliseconds
+	reminderAt := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond)
+	added := mustAdd(t, s, model.ToDo{ID: "1", Title: "call", Status: model.Created,
+		ReminderTime: "2026-10-18T09:00", TimeZone: "Europe/Berlin", ReminderAt: &reminderAt})
+	if got := mustGet(t, s, "1"); !sameTodo(got, added) {
+		t.Errorf("GetTodoById returned %+v, want %+v", got, added)
+	}
+
+	// reminder fields are not changed by updates
+	updated, err := s.UpdateTodo(ctx, "1", model.ToDo{Title: "call back", Status: model.InProgress})
+	if err != nil {
+		t.Fatalf("UpdateTodo failed: %v", err)
+	}
+	if updated.TimeZone != "Europe/Berlin" || !sameInstant(updated.ReminderAt, &reminderAt) {
+		t.Errorf("UpdateTodo returned time zone %q and reminder_at %v, want them unchanged", updated.TimeZone, updated.ReminderAt)
+	}
+
+	// todos without a reminder have no reminder_at
+	mustAdd(t, s, model.ToDo{ID: "2", Title: "read", Status: model.Created})
+	if got := mustGet(t, s, "2"); got.ReminderAt != nil || got.TimeZone != "" {
+		t.Errorf("GetTodoById returned reminder_at %v and time zone %q, want none", got.ReminderAt, got.TimeZone)
+	}
+}
+
+func testDueDate(t *testing.T, s storage.Storage) {
+	// storages keep milliseconds
+	due := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Millisecond)
+	added := mustAdd(t, s, model.ToDo{ID: "1", Title: "report", Status: model.Created, DueAt: &due})
+	if got := mustGet(t, s, "1"); !sameTodo(got, added) {
+		t.Errorf("GetTodoById returned %+v, want %+v", got, added)
+	}
+
+	later := due.Add(time.Hour)
+	updated, err := s.UpdateTodo(ctx, "1", model.ToDo{Title: "report", Status: model.InProgress, DueAt: &later})
+	if err != nil {
+		t.Fatalf("UpdateTodo failed: %v", err)
+	}
+	if !sameInstant(updated.DueAt, &later) {
+		t.Errorf("UpdateTodo returned due date %v, want %v", updated.DueAt, later)
+	}
+
+	// a patch without a due date keeps it, a patch to nil clears it
+	title := "final report"
+	patched, err := s.PatchTodo(ctx, "1", model.ToDoPatch{Title: &title}, 0)
+	if err != nil {
+		t.Fatalf("PatchTodo failed: %v", err)
+	}
+	if !sameInstant(patched.DueAt, &later) {
+		t.Errorf("PatchTodo returned due date %v, want %v", patched.DueAt, later)
+	}
+	var cleared *time.Time
+	patched, err = s.PatchTodo(ctx, "1", model.ToDoPatch{DueAt: &cleared}, 0)
+	if err != nil {
+		t.Fatalf("PatchTodo failed: %v", err)
+	}
+	if patched.DueAt != nil {
+		t.Errorf("PatchTodo returned due date %v, want none", patched.DueAt)
+	}
+	if got := mustGet(t, s, "1"); !sameTodo(got, patched) {
+		t.Errorf("GetTodoById returned %+v, want %+v", got, patched)
+	}
+}
+
+func testAddGeneratesID(t *testing.T, s storage.Storage) {
+	mustAdd(t, s, model.ToDo{Title: "first", Status: model.Created})
+	mustAdd(t, s, model.ToDo{Title: "second", Status: model.Created})
+
+	todos := mustList(t, s, model.ListOptions{}).Todos
+	if len(todos) != 2 {
+		t.Fatalf("GetTodos returned %d todos, want 2", len(todos))
+	}
+	if todos[0].ID == "" || todos[1].ID == "" {
+		t.Fatalf("AddTodo did not generate IDs: %+v", todos)
+	}
+	if todos[0].ID == todos[1].ID {
+		t.Errorf("AddTodo generated the same ID %q twice", todos[0].ID)
+	}
+	mustGet(t, s, todos[0].ID)
+}
+
+func testAddDuplicateID(t *testing.T, s storage.Storage) {
+	mustAdd(t, s, model.ToDo{ID: "1", Title: "original", Status: model.Created})
+
+	if _, err := s.AddTodo(ctx, model.ToDo{ID: "1", Title: "duplicate", Status: model.Created}); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("AddTodo with an existing ID returned %v, want ErrConflict", err)
+	}
+	if got := mustGet(t, s, "1"); got.Title != "original" {
+		t.Errorf("duplicate AddTodo changed the title to %q", got.Title)
+	}
+}
+
+func testGetMissing(t *testing.T, s storage.Storage) {
+	if _, err := s.GetTodoById(ctx, "missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetTodoById of a missing todo returned %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetTodoImageById(ctx, "missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetTodoImageById of a missing todo returned %v, want ErrNotFound", err)
+	}
+}
+
+func testGetTodosEmpty(t *testing.T, s storage.Storage) {
+	page := mustList(t, s, model.ListOptions{})
+	if page.Todos == nil || len(page.Todos) != 0 || page.NextCursor != "" {
+		t.Errorf("GetTodos on empty storage returned %+v, want an empty list", page)
+	}
+}
+
+func testGetTodosOrderedByID(t *testing.T, s storage.Storage) {
+	for _, id := range []string{"c", "a", "b"} {
+		mustAdd(t, s, model.ToDo{ID: id, Title: "task " + id, Status: model.Created})
+	}
+
+	expectIDs(t, "GetTodos", mustList(t, s, model.ListOptions{}).Todos, "a", "b", "c")
+}
+
+func addListFixture(t *testing.T, s storage.Storage) {
+	for _, todo := range []model.ToDo{
+		{ID: "1", Title: "Buy milk", Status: model.Created},
+		{ID: "2", Title: "walk the dog", Status: model.Done},
+		{ID: "3", Title: "Buy bread", Status: model.InProgress},
+		{ID: "4", Title: "call mom", Status: model.Created},
+		{ID: "5", Title: "100% done_ish", Status: model.Done},
+	} {
+		mustAdd(t, s, todo)
+	}
+}
+
+func testGetTodosFilter(t *testing.T, s storage.Storage) {
+	addListFixture(t, s)
+
+	expectIDs(t, "status filter", mustList(t, s, model.ListOptions{Status: model.Created}).Todos, "1", "4")
+	expectIDs(t, "title filter", mustList(t, s, model.ListOptions{Title: "buy"}).Todos, "1", "3")
+	expectIDs(t, "status and title filter", mustList(t, s, model.ListOptions{Title: "BUY", Status: model.InProgress}).Todos, "3")
+	// wildcards are matched literally
+	expectIDs(t, "title with %", mustList(t, s, model.ListOptions{Title: "0% d"}).Todos, "5")
+	expectIDs(t, "title with _", mustList(t, s, model.ListOptions{Title: "e_i"}).Todos, "5")
+	expectIDs(t, "title without matches", mustList(t, s, model.ListOptions{Title: "%%"}).Todos)
+}
+
+func testGetTodosSort(t *testing.T, s storage.Storage) {
+	addListFixture(t, s)
+
+	expectIDs(t, "sort by id desc", mustList(t, s, model.ListOptions{SortDesc: true}).Todos, "5", "4", "3", "2", "1")
+	// ties are ordered by id
+	expectIDs(t, "sort by status", mustList(t, s, model.ListOptions{SortBy: model.SortByStatus}).Todos, "1", "4", "2", "5", "3")
+	expectIDs(t, "sort by status desc", mustList(t, s, model.ListOptions{SortBy: model.SortByStatus, SortDesc: true}).Todos, "3", "5", "2", "4", "1")
+}
+
+func testGetTodosSortByTime(t *testing.T, s storage.Storage) {
+	// storages keep milliseconds, so every todo gets its own created_at
+	for _, id := range []string{"c", "a", "b"} {
+		mustAdd(t, s, model.ToDo{ID: id, Title: "task " + id, Status: model.Created})
+		time.Sleep(2 * time.Millisecond)
+	}
+	if _, err := s.UpdateTodo(ctx, "c", model.ToDo{Title: "changed", Status: model.Done}); err != nil {
+		t.Fatalf("UpdateTodo failed: %v", err)
+	}
+
+	expectIDs(t, "sort by created_at", mustList(t, s, model.ListOptions{SortBy: model.SortByCreatedAt}).Todos, "c", "a", "b")
+	expectIDs(t, "sort by created_at desc", mustList(t, s, model.ListOptions{SortBy: model.SortByCreatedAt, SortDesc: true}).Todos, "b", "a", "c")
+	expectIDs(t, "sort by updated_at", mustList(t, s, model.ListOptions{SortBy: model.SortByUpdatedAt}).Todos, "a", "b", "c")
+
+	page := mustList(t, s, model.ListOptions{SortBy: model.SortByCreatedAt, Limit: 2})
+	expectIDs(t, "first page by created_at", page.Todos, "c", "a")
+	page = mustList(t, s, model.ListOptions{SortBy: model.SortByCreatedAt, Limit: 2, Cursor: page.NextCursor})
+	expectIDs(t, "second page by created_at", page.Todos, "b")
+}
+
+func testGetTodosPagination(t *testing.T, s storage.Storage) {
+	addListFixture(t, s)
+
+	for _, opts := range []model.ListOptions{
+		{Limit: 2},
+		{Limit: 2, SortDesc: true},
+		{Limit: 2, SortBy: model.SortByStatus},
+		{Limit: 3, SortBy: model.SortByStatus, SortDesc: true},
+		{Limit: 1, Status: model.Done},
+		{Limit: 2, SortBy: model.SortByCreatedAt},
+		{Limit: 2, SortBy: model.SortByUpdatedAt, SortDesc: true},
+	} {
+		all := mustList(t, s, model.ListOptions{Status: opts.Status, SortBy: opts.SortBy, SortDesc: opts.SortDesc})
+
+		var paged []model.ToDo
+		for pages := 0; ; pages++ {
+			if pages > len(all.Todos) {
+				t.Fatalf("pagination with %+v does not end", opts)
+			}
+			page := mustList(t, s, opts)
+			if len(page.Todos) > opts.Limit {
+				t.Fatalf("page with %+v has %d todos", opts, len(page.Todos))
+			}
+			paged = append(paged, page.Todos...)
+			if page.NextCursor == "" {
+				break
+			}
+			opts.Cursor = page.NextCursor
+		}
+		expectIDs(t, "pages", paged, todoIDs(all.Todos)...)
+	}
+}
+
+func testGetTodosInvalidOptions(t *testing.T, s storage.Storage) {
+	addListFixture(t, s)
+	page := mustList(t, s, model.ListOptions{Limit: 2})
+
+	for _, opts := range []model.ListOptions{
+		{SortBy: "image_path"},
+		{Status: "unknown"},
+		{Limit: -1},
+		{Limit: storage.MaxPageSize + 1},
+		{Cursor: "not a cursor"},
+		{Cursor: page.NextCursor, SortDesc: true}, // cursor of another sort order
+	} {
+		if _, err := s.GetTodos(ctx, opts); !errors.Is(err, storage.ErrValidation) {
+			t.Errorf("GetTodos(%+v) returned %v, want ErrValidation", opts, err)
+		}
+	}
+}
+
+func testUpdate(t *testing.T, s storage.Storage) {
+	added := mustAdd(t, s, model.ToDo{ID: "1", Title: "old", Status: model.Created, ImagePath: "img.png", ReminderTime: "1h"})
+	time.Sleep(2 * time.Millisecond)
+
+	updated, err := s.UpdateTodo(ctx, "1", model.ToDo{ID: "ignored", Title: "new", Status: model.Done, ImagePath: "ignored.png",
+		CreatedAt: time.Unix(0, 0)})
+	if err != nil {
+		t.Fatalf("UpdateTodo failed: %v", err)
+	}
+
+	// only title and status are updated, the storage moves updated_at and version
+	if updated.ID != "1" || updated.Title != "new" || updated.Status != model.Done || updated.ImagePath != "img.png" ||
+		updated.ReminderTime != "1h" || !updated.CreatedAt.Equal(added.CreatedAt) {
+		t.Errorf("UpdateTodo returned %+v, want changed title and status of %+v", updated, added)
+	}
+	if !updated.UpdatedAt.After(added.UpdatedAt) {
+		t.Errorf("UpdateTodo set updated_at to %v, want a time after %v", updated.UpdatedAt, added.UpdatedAt)
+	}
+	if updated.Version != added.Version+1 {
+		t.Errorf("UpdateTodo set version %d, want %d", updated.Version, added.Version+1)
+	}
+	if got := mustGet(t, s, "1"); !sameTodo(got, updated) {
+		t.Errorf("after UpdateTodo got %+v, want %+v", got, updated)
+	}
+}
+
+func testUpdateMissing(t *testing.T, s storage.Storage) {
+	if _, err := s.UpdateTodo(ctx, "missing", model.ToDo{Title: "new", Status: model.Done}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("UpdateTodo of a missing todo returned %v, want ErrNotFound", err)
+	}
+	if _, err := s.UpdateTodo(ctx, "missing", model.ToDo{Title: "new", Status: model.Done, Version: 1}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("UpdateTodo of a missing todo with a version returned %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetTodoById(ctx, "missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Error("UpdateTodo of a missing todo created it")
+	}
+}
+
+func testUpdateVersion(t *testing.T, s storage.Storage) {
+	added := mustAdd(t, s, model.ToDo{ID: "1", Title: "v1", Status: model.Created})
+
+	updated, err := s.UpdateTodo(ctx, "1", model.ToDo{Title: "v2", Status: model.Created, Version: added.Version})
+	if err != nil {
+		t.Fatalf("UpdateTodo with the current version failed: %v", err)
+	}
+	updated, err = s.UpdateTodo(ctx, "1", model.ToDo{Title: "v3", Status: model.Created, Version: updated.Version})
+	if err != nil {
+		t.Fatalf("UpdateTodo with the current version failed: %v", err)
+	}
+	if updated.Version != 3 || updated.Title != "v3" {
+		t.Errorf("after two updates got version %d and title %q, want 3 and v3", updated.Version, updated.Title)
+	}
+
+	// version 0 updates unconditionally
+	if updated, err = s.UpdateTodo(ctx, "1", model.ToDo{Title: "v4", Status: model.Created}); err != nil {
+		t.Fatalf("UpdateTodo without a version failed: %v", err)
+	}
+	if updated.Version != 4 {
+		t.Errorf("UpdateTodo without a version set version %d, want 4", updated.Version)
+	}
+}
+
+func testUpdateStaleVersion(t *testing.T, s storage.Storage) {
+	added := mustAdd(t, s, model.ToDo{ID: "1", Title: "original", Status: model.Created})
+	if _, err := s.UpdateTodo(ctx, "1", model.ToDo{Title: "first", Status: model.Done, Version: added.Version}); err != nil {
+		t.Fatalf("UpdateTodo failed: %v", err)
+	}
+
+	// the second writer still has the first version
+	_, err := s.UpdateTodo(ctx, "1", model.ToDo{Title: "second", Status: model.Created, Version: added.Version})
+	if !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("UpdateTodo with a stale version returned %v, want ErrConflict", err)
+	}
+	if got := mustGet(t, s, "1"); got.Title != "first" || got.Version != 2 {
+		t.Errorf("UpdateTodo with a stale version changed the todo to %+v", got)
+	}
+}
+
+func testPatch(t *testing.T, s storage.Storage) {
+	added := mustAdd(t, s, model.ToDo{ID: "1", Title: "old", Status: model.InProgress, ImagePath: "img.png"})
+
+	title := "new"
+	patched, err := s.PatchTodo(ctx, "1", model.ToDoPatch{Title: &title}, 0)
+	if err != nil {
+		t.Fatalf("PatchTodo failed: %v", err)
+	}
+	if patched.Title != "new" || patched.Status != model.InProgress || patched.ImagePath != "img.png" || patched.Version != added.Version+1 {
+		t.Errorf("PatchTodo of the title returned %+v", patched)
+	}
+
+	status := model.Done
+	patched, err = s.PatchTodo(ctx, "1", model.ToDoPatch{Status: &status}, patched.Version)
+	if err != nil {
+		t.Fatalf("PatchTodo with the current version failed: %v", err)
+	}
+	if patched.Title != "new" || patched.Status != model.Done || patched.Version != added.Version+2 {
+		t.Errorf("PatchTodo of the status returned %+v", patched)
+	}
+	if got := mustGet(t, s, "1"); !sameTodo(got, patched) {
+		t.Errorf("after PatchTodo got %+v, want %+v", got, patched)
+	}
+}
+
+func testPatchMissing(t *testing.T, s storage.Storage) {
+	title := "new"
+	for _, version := range []int64{0, 1} {
+		if _, err := s.PatchTodo(ctx, "missing", model.ToDoPatch{Title: &title}, version); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("PatchTodo of a missing todo with version %d returned %v, want ErrNotFound", version, err)
+		}
+	}
+}
+
+func testPatchStaleVersion(t *testing.T, s storage.Storage) {
+	added := mustAdd(t, s, model.ToDo{ID: "1", Title: "original", Status: model.Created})
+	first, second := "first", "second"
+	if _, err := s.PatchTodo(ctx, "1", model.ToDoPatch{Title: &first}, added.Version); err != nil {
+		t.Fatalf("PatchTodo failed: %v", err)
+	}
+
+	if _, err := s.PatchTodo(ctx, "1", model.ToDoPatch{Title: &second}, added.Version); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("PatchTodo with a stale version returned %v, want ErrConflict", err)
+	}
+	if got := mustGet(t, s, "1"); got.Title != "first" {
+		t.Errorf("PatchTodo with a stale version changed the title to %q", got.Title)
+	}
+}
+
+func testUpdateImage(t *testing.T, s storage.Storage) {
+	added := mustAdd(t, s, model.ToDo{ID: "1", Title: "task", Status: model.Created})
+
+	if err := s.UpdateTodoImage(ctx, "1", "uploads/images/a.png"); err != nil {
+		t.Fatalf("UpdateTodoImage failed: %v", err)
+	}
+
+	image, err := s.GetTodoImageById(ctx, "1")
+	if err != nil {
+		t.Fatalf("GetTodoImageById failed: %v", err)
+	}
+	if image.ImagePath != "uploads/images/a.png" {
+		t.Errorf("GetTodoImageById returned image path %q", image.ImagePath)
+	}
+	if got := mustGet(t, s, "1"); got.ImagePath != "uploads/images/a.png" || got.Title != "task" || got.Version != added.Version+1 {
+		t.Errorf("after UpdateTodoImage got %+v", got)
+	}
+}
+
+func testUpdateImageMissing(t *testing.T, s storage.Storage) {
+	if err := s.UpdateTodoImage(ctx, "missing", "a.png"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("UpdateTodoImage of a missing todo returned %v, want ErrNotFound", err)
+	}
+}
+
+func testDelete(t *testing.T, s storage.Storage) {
+	mustAdd(t, s, model.ToDo{ID: "1", Title: "task", Status: model.Created})
+	mustAdd(t, s, model.ToDo{ID: "2", Title: "other", Status: model.Created})
+
+	if err := s.DeleteTodo(ctx, "1", 0); err != nil {
+		t.Fatalf("DeleteTodo failed: %v", err)
+	}
+	if _, err := s.GetTodoById(ctx, "1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetTodoById of a deleted todo returned %v, want ErrNotFound", err)
+	}
+	expectIDs(t, "GetTodos after DeleteTodo", mustList(t, s, model.ListOptions{}).Todos, "2")
+}
+
+func testDeleteMissing(t *testing.T, s storage.Storage) {
+	if err := s.DeleteTodo(ctx, "missing", 0); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("DeleteTodo of a missing todo returned %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteTodo(ctx, "missing", 1); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("DeleteTodo of a missing todo with a version returned %v, want ErrNotFound", err)
+	}
+}
+
+func testDeleteVersion(t *testing.T, s storage.Storage) {
+	added := mustAdd(t, s, model.ToDo{ID: "1", Title: "task", Status: model.Created})
+	updated, err := s.UpdateTodo(ctx, "1", model.ToDo{Title: "changed", Status: model.Done})
+	if err != nil {
+		t.Fatalf("UpdateTodo failed: %v", err)
+	}
+
+	if err := s.DeleteTodo(ctx, "1", added.Version); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("DeleteTodo with a stale version returned %v, want ErrConflict", err)
+	}
+	mustGet(t, s, "1")
+
+	if err := s.DeleteTodo(ctx, "1", updated.Version); err != nil {
+		t.Fatalf("DeleteTodo with the current version failed: %v", err)
+	}
+	if _, err := s.GetTodoById(ctx, "1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetTodoById of a deleted todo returned %v, want ErrNotFound", err)
+	}
+}
+
+func testReminders(t *testing.T, s storage.Storage) {
+	if reminders, err := s.GetReminders(ctx, ""); err != nil || len(reminders) != 0 {
+		t.Fatalf("GetReminders on empty storage returned %v, %v", reminders, err)
+	}
+
+	// storages keep milliseconds
+	base := time.Now().UTC().Truncate(time.Millisecond)
+	later, err := s.AddReminder(ctx, model.Reminder{TodoID: "1", TaskName: "later", ReminderTime: base.Add(time.Hour),
+		Recurrence: "every day at 09:00", TimeZone: "Europe/Berlin"})
+	if err != nil {
+		t.Fatalf("AddReminder failed: %v", err)
+	}
+	if later.ID == "" {
+		t.Error("AddReminder did not generate an ID")
+	}
+	overdue, err := s.AddReminder(ctx, model.Reminder{ID: "r1", TodoID: "2", TaskName: "overdue", ReminderTime: base.Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("AddReminder failed: %v", err)
+	}
+	if _, err := s.AddReminder(ctx, model.Reminder{ID: "r1", TodoID: "2", TaskName: "again", ReminderTime: base}); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("AddReminder with an existing ID returned %v, want ErrConflict", err)
+	}
+
+	reminders, err := s.GetReminders(ctx, "")
+	if err != nil {
+		t.Fatalf("GetReminders failed: %v", err)
+	}
+	if len(reminders) != 2 {
+		t.Fatalf("GetReminders returned %d reminders, want 2", len(reminders))
+	}
+	for i, want := range []model.Reminder{overdue, later} {
+		got := reminders[i]
+		if got.ID != want.ID || got.TodoID != want.TodoID || got.TaskName != want.TaskName ||
+			!got.ReminderTime.Equal(want.ReminderTime) || got.State != model.ReminderPending ||
+			got.Recurrence != want.Recurrence || got.TimeZone != want.TimeZone {
+			t.Errorf("GetReminders returned %+v at %d, want %+v", got, i, want)
+		}
+	}
+}
+
+func testReminderStates(t *testing.T, s storage.Storage) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	first, err := s.AddReminder(ctx, model.Reminder{ID: "r1", TodoID: "1", TaskName: "first", ReminderTime: now})
+	if err != nil {
+		t.Fatalf("AddReminder failed: %v", err)
+	}
+	if _, err := s.AddReminder(ctx, model.Reminder{ID: "r2", TodoID: "1", TaskName: "second", ReminderTime: now}); err != nil {
+		t.Fatalf("AddReminder failed: %v", err)
+	}
+
+	if err := s.SetReminderState(ctx, first.ID, model.ReminderPending, model.ReminderDelivered); err != nil {
+		t.Fatalf("SetReminderState to delivered failed: %v", err)
+	}
+	// a reminder is delivered only once
+	if err := s.SetReminderState(ctx, first.ID, model.ReminderPending, model.ReminderDelivered); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("second SetReminderState to delivered returned %v, want ErrConflict", err)
+	}
+	if err := s.SetReminderState(ctx, "missing", model.ReminderPending, model.ReminderDelivered); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("SetReminderState of a missing reminder returned %v, want ErrNotFound", err)
+	}
+
+	pending, err := s.GetReminders(ctx, model.ReminderPending)
+	if err != nil {
+		t.Fatalf("GetReminders failed: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != "r2" {
+		t.Errorf("GetReminders of pending reminders returned %+v, want r2", pending)
+	}
+
+	if err := s.SetReminderState(ctx, first.ID, model.ReminderDelivered, model.ReminderAcknowledged); err != nil {
+		t.Fatalf("SetReminderState to acknowledged failed: %v", err)
+	}
+	if got, err := s.GetReminder(ctx, first.ID); err != nil || got.State != model.ReminderAcknowledged {
+		t.Errorf("GetReminder returned %+v, %v, want an acknowledged reminder", got, err)
+	}
+	if _, err := s.GetReminder(ctx, "missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetReminder of a missing reminder returned %v, want ErrNotFound", err)
+	}
+}
+
+func testReminderDelivery(t *testing.T, s storage.Storage) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	added, err := s.AddReminder(ctx, model.Reminder{ID: "r1", TodoID: "1", TaskName: "report", ReminderTime: now,
+		DueOffset: "-30m", Channel: model.ChannelLog, Message: "{{.TaskName}} is due in 30 minutes"})
+	if err != nil {
+		t.Fatalf("AddReminder failed: %v", err)
+	}
+	got, err := s.GetReminder(ctx, "r1")
+	if err != nil {
+		t.Fatalf("GetReminder failed: %v", err)
+	}
+	if got.DueOffset != added.DueOffset || got.Channel != added.Channel || got.Message != added.Message {
+		t.Errorf("GetReminder returned %+v, want %+v", got, added)
+	}
+
+	// reminders without a channel are sent as notifications
+	if _, err := s.AddReminder(ctx, model.Reminder{ID: "r2", TodoID: "1", TaskName: "report", ReminderTime: now}); err != nil {
+		t.Fatalf("AddReminder failed: %v", err)
+	}
+	if got, err := s.GetReminder(ctx, "r2"); err != nil || got.Channel != model.ChannelSSE {
+		t.Errorf("GetReminder returned %+v, %v, want channel %q", got, err, model.ChannelSSE)
+	}
+}
+
+func testDeleteReminder(t *testing.T, s storage.Storage) {
+	reminder, err := s.AddReminder(ctx, model.Reminder{TodoID: "1", TaskName: "task", ReminderTime: time.Now().UTC().Truncate(time.Millisecond)})
+	if err != nil {
+		t.Fatalf("AddReminder failed: %v", err)
+	}
+
+	if err := s.DeleteReminder(ctx, reminder.ID); err != nil {
+		t.Fatalf("DeleteReminder failed: %v", err)
+	}
+	if reminders, err := s.GetReminders(ctx, ""); err != nil || len(reminders) != 0 {
+		t.Errorf("GetReminders after DeleteReminder returned %v, %v", reminders, err)
+	}
+	if err := s.DeleteReminder(ctx, reminder.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("DeleteReminder of a missing reminder returned %v, want ErrNotFound", err)
+	}
+}
+
+func testTodoReminders(t *testing.T, s storage.Storage) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for _, reminder := range []model.Reminder{
+		{ID: "r1", TodoID: "1", TaskName: "later", ReminderTime: now.Add(time.Hour)},
+		{ID: "r2", TodoID: "2", TaskName: "other", ReminderTime: now},
+		{ID: "r3", TodoID: "1", TaskName: "earlier", ReminderTime: now},
+	} {
+		if _, err := s.AddReminder(ctx, reminder); err != nil {
+			t.Fatalf("AddReminder failed: %v", err)
+		}
+	}
+	// reminders in every state are returned
+	if err := s.SetReminderState(ctx, "r3", model.ReminderPending, model.ReminderDelivered); err != nil {
+		t.Fatalf("SetReminderState failed: %v", err)
+	}
+
+	reminders, err := s.GetTodoReminders(ctx, "1")
+	if err != nil {
+		t.Fatalf("GetTodoReminders failed: %v", err)
+	}
+	if len(reminders) != 2 || reminders[0].ID != "r3" || reminders[1].ID != "r1" {
+		t.Errorf("GetTodoReminders returned %+v, want r3, r1", reminders)
+	}
+	if reminders, err := s.GetTodoReminders(ctx, "missing"); err != nil || len(reminders) != 0 {
+		t.Errorf("GetTodoReminders of a todo without reminders returned %v, %v", reminders, err)
+	}
+}
+
+func testUpdateReminder(t *testing.T, s storage.Storage) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	reminder, err := s.AddReminder(ctx, model.Reminder{TodoID: "1", TaskName: "task", ReminderTime: now})
+	if err != nil {
+		t.Fatalf("AddReminder failed: %v", err)
+	}
+
+	reminder.TaskName = "renamed"
+	reminder.ReminderTime = now.Add(time.Hour)
+	if err := s.UpdateReminder(ctx, reminder); err != nil {
+		t.Fatalf("UpdateReminder failed: %v", err)
+	}
+	got, err := s.GetReminder(ctx, reminder.ID)
+	if err != nil {
+		t.Fatalf("GetReminder failed: %v", err)
+	}
+	if got.TaskName != "renamed" || !got.ReminderTime.Equal(reminder.ReminderTime) || got.State != model.ReminderPending {
+		t.Errorf("GetReminder after UpdateReminder returned %+v, want %+v", got, reminder)
+	}
+
+	reminder.ID = "missing"
+	if err := s.UpdateReminder(ctx, reminder); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("UpdateReminder of a missing reminder returned %v, want ErrNotFound", err)
+	}
+}
+
+func testSnoozeReminder(t *testing.T, s storage.Storage) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for _, id := range []string{"pending", "delivered", "acknowledged"} {
+		if _, err := s.AddReminder(ctx, model.Reminder{ID: id, TodoID: "1", TaskName: id, ReminderTime: now}); err != nil {
+			t.Fatalf("AddReminder failed: %v", err)
+		}
+	}
+	for _, id := range []string{"delivered", "acknowledged"} {
+		if err := s.SetReminderState(ctx, id, model.ReminderPending, model.ReminderDelivered); err != nil {
+			t.Fatalf("SetReminderState failed: %v", err)
+		}
+	}
+	if err := s.SetReminderState(ctx, "acknowledged", model.ReminderDelivered, model.ReminderAcknowledged); err != nil {
+		t.Fatalf("SetReminderState failed: %v", err)
+	}
+
+	later := now.Add(10 * time.Minute)
+	for _, id := range []string{"pending", "delivered"} {
+		snoozed, err := s.SnoozeReminder(ctx, id, later)
+		if err != nil {
+			t.Fatalf("SnoozeReminder of a %s reminder failed: %v", id, err)
+		}
+		if snoozed.ID != id || snoozed.TaskName != id || !snoozed.ReminderTime.Equal(later) || snoozed.State != model.ReminderPending {
+			t.Errorf("SnoozeReminder returned %+v, want a pending reminder at %v", snoozed, later)
+		}
+		if got, err := s.GetReminder(ctx, id); err != nil || !got.ReminderTime.Equal(later) || got.State != model.ReminderPending {
+			t.Errorf("GetReminder after SnoozeReminder returned %+v, %v", got, err)
+		}
+	}
+	if _, err := s.SnoozeReminder(ctx, "acknowledged", later); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("SnoozeReminder of an acknowledged reminder returned %v, want ErrConflict", err)
+	}
+	if _, err := s.SnoozeReminder(ctx, "missing", later); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("SnoozeReminder of a missing reminder returned %v, want ErrNotFound", err)
+	}
+}
+
+func testReminderEvents(t *testing.T, s storage.Storage) {
+	if events, err := s.GetReminderEvents(ctx, "1"); err != nil || len(events) != 0 {
+		t.Fatalf("GetReminderEvents on empty storage returned %v, %v", events, err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for _, event := range []model.ReminderEvent{
+		{ID: "e2", ReminderID: "r1", TodoID: "1", Type: model.ReminderEventDelivered, State: model.ReminderDelivered,
+			ReminderTime: now, OccurredAt: now.Add(time.Second)},
+		{ID: "e1", ReminderID: "r1", TodoID: "1", Type: model.ReminderEventCreated, State: model.ReminderPending,
+			ReminderTime: now, OccurredAt: now},
+		{ID: "e3", ReminderID: "r2", TodoID: "2", Type: model.ReminderEventCreated, State: model.ReminderPending,
+			ReminderTime: now, OccurredAt: now},
+		{ReminderID: "r1", TodoID: "1", Type: model.ReminderEventCanceled, ReminderTime: now, OccurredAt: now.Add(2 * time.Second)},
+	} {
+		if err := s.AddReminderEvent(ctx, event); err != nil {
+			t.Fatalf("AddReminderEvent failed: %v", err)
+		}
+	}
+
+	events, err := s.GetReminderEvents(ctx, "1")
+	if err != nil {
+		t.Fatalf("GetReminderEvents failed: %v", err)
+	}
+	if len(events) != 3 || events[0].ID != "e1" || events[1].ID != "e2" || events[2].ID == "" {
+		t.Fatalf("GetReminderEvents returned %+v, want e1, e2 and an event with a generated ID", events)
+	}
+	if got := events[1]; got.ReminderID != "r1" || got.Type != model.ReminderEventDelivered || got.State != model.ReminderDelivered ||
+		!got.ReminderTime.Equal(now) || !got.OccurredAt.Equal(now.Add(time.Second)) {
+		t.Errorf("GetReminderEvents returned %+v", got)
+	}
+	if got := events[2]; got.Type != model.ReminderEventCanceled || got.State != "" {
+		t.Errorf("GetReminderEvents returned %+v, want a canceled event without state", got)
+	}
+
+	if err := s.DeleteReminderEvents(ctx, "1"); err != nil {
+		t.Fatalf("DeleteReminderEvents failed: %v", err)
+	}
+	if events, err := s.GetReminderEvents(ctx, "1"); err != nil || len(events) != 0 {
+		t.Errorf("GetReminderEvents after DeleteReminderEvents returned %v, %v", events, err)
+	}
+	if events, err := s.GetReminderEvents(ctx, "2"); err != nil || len(events) != 1 {
+		t.Errorf("DeleteReminderEvents dropped events of another todo, GetReminderEvents returned %v, %v", events, err)
+	}
+}
+
+func testNotifications(t *testing.T, s storage.Storage) {
+	if notifications, err := s.GetNotifications(ctx, 0, 10); err != nil || len(notifications) != 0 {
+		t.Fatalf("GetNotifications on empty storage returned %v, %v", notifications, err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	var added []model.Notification
+	for i, notificationType := range []model.NotificationType{model.NotificationTodoCreated,
+		model.NotificationReminder, model.NotificationTodoDeleted} {
+		notification, err := s.AddNotification(ctx, model.Notification{Type: notificationType,
+			Data: fmt.Sprintf(`{"n":%d}`, i), CreatedAt: now})
+		if err != nil {
+			t.Fatalf("AddNotification failed: %v", err)
+		}
+		if i > 0 && notification.ID <= added[i-1].ID {
+			t.Errorf("AddNotification returned ID %d after %d, want a greater one", notification.ID, added[i-1].ID)
+		}
+		added = append(added, notification)
+	}
+
+	got, err := s.GetNotifications(ctx, added[0].ID, 1)
+	if err != nil {
+		t.Fatalf("GetNotifications failed: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != added[1].ID || got[0].Type != added[1].Type || got[0].Data != added[1].Data ||
+		!got[0].CreatedAt.Equal(now) {
+		t.Errorf("GetNotifications after %d returned %+v, want %+v", added[0].ID, got, added[1])
+	}
+
+	// IDs of deleted notifications are not reused
+	if err := s.DeleteNotifications(ctx, added[2].ID); err != nil {
+		t.Fatalf("DeleteNotifications failed: %v", err)
+	}
+	if got, err := s.GetNotifications(ctx, 0, 10); err != nil || len(got) != 0 {
+		t.Fatalf("GetNotifications after DeleteNotifications returned %v, %v", got, err)
+	}
+	next, err := s.AddNotification(ctx, model.Notification{Type: model.NotificationTodoUpdated, Data: "{}", CreatedAt: now})
+	if err != nil {
+		t.Fatalf("AddNotification failed: %v", err)
+	}
+	if next.ID <= added[2].ID {
+		t.Errorf("AddNotification returned ID %d after deleting %d, want a greater one", next.ID, added[2].ID)
+	}
+}
+
+func outboxTypes(outbox []model.OutboxEvent) []string {
+	types := make([]string, 0, len(outbox))
+	for _, event := range outbox {
+		types = append(types, event.TodoID+" "+event.Type)
+	}
+	return types
+}
+
+func testOutbox(t *testing.T, s storage.Storage) {
+	if outbox, err := s.ClaimOutboxEvents(ctx, "", time.Minute, 10); err != nil || len(outbox) != 0 {
+		t.Fatalf("ClaimOutboxEvents on empty storage returned %v, %v", outbox, err)
+	}
+
+	// every change writes its event with the todo after it
+	mustAdd(t, s, model.ToDo{ID: "1", Title: "first", Status: model.Created})
+	mustAdd(t, s, model.ToDo{ID: "2", Title: "second", Status: model.Created})
+	updated, err := s.UpdateTodo(ctx, "1", model.ToDo{Title: "first", Status: model.Done})
+	if err != nil {
+		t.Fatalf("UpdateTodo failed: %v", err)
+	}
+	if err := s.UpdateTodoImage(ctx, "1", "uploads/images/a.png"); err != nil {
+		t.Fatalf("UpdateTodoImage failed: %v", err)
+	}
+	if err := s.DeleteTodo(ctx, "2", 0); err != nil {
+		t.Fatalf("DeleteTodo failed: %v", err)
+	}
+	// failed changes write no event
+	if _, err := s.UpdateTodo(ctx, "missing", model.ToDo{Title: "x", Status: model.Done}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("UpdateTodo of a missing todo returned %v, want ErrNotFound", err)
+	}
+
+	// only the oldest pending event of a todo is claimed
+	claimed, err := s.ClaimOutboxEvents(ctx, "1", time.Minute, 10)
+	if err != nil {
+		t.Fatalf("ClaimOutboxEvents failed: %v", err)
+	}
+	if got := strings.Join(outboxTypes(claimed), ","); got != "1 todo.created" {
+		t.Fatalf("ClaimOutboxEvents of todo 1 returned %v, want 1 todo.created", got)
+	}
+	created := claimed[0]
+	if created.ID == "" || created.Status != model.OutboxPending || created.Attempts != 0 || created.CreatedAt.IsZero() {
+		t.Errorf("ClaimOutboxEvents returned %+v, want a new pending event", created)
+	}
+
+	// claimed events are not due again before their lease passed, the later events of their todo wait
+	rest, err := s.ClaimOutboxEvents(ctx, "", 0, 10)
+	if err != nil {
+		t.Fatalf("ClaimOutboxEvents failed: %v", err)
+	}
+	if got := strings.Join(outboxTypes(rest), ","); got != "2 todo.created" {
+		t.Fatalf("ClaimOutboxEvents after claiming todo 1 returned %v, want 2 todo.created", got)
+	}
+
+	// once the lease passed they are due again
+	time.Sleep(2 * time.Millisecond)
+	again, err := s.ClaimOutboxEvents(ctx, "", time.Minute, 1)
+	if err != nil {
+		t.Fatalf("ClaimOutboxEvents failed: %v", err)
+	}
+	if len(again) != 1 || again[0].ID != rest[0].ID {
+		t.Errorf("ClaimOutboxEvents after the lease returned %v, want %v", outboxTypes(again), rest[0].ID)
+	}
+
+	// the next event is claimed once the older one is published
+	created.Status = model.OutboxPublished
+	mustUpdateOutboxEvent(t, s, created)
+	claimed = mustClaimOutboxEvents(t, s, "1", "1 todo.updated")
+	if claimed[0].OldStatus != model.Created {
+		t.Errorf("todo.updated has old status %q, want %q", claimed[0].OldStatus, model.Created)
+	}
+	if todo, err := claimed[0].DecodeTodo(); err != nil || todo.Status != model.Done || todo.Version != updated.Version {
+		t.Errorf("todo.updated has todo %+v, %v, want %+v", todo, err, updated)
+	}
+
+	// a failed event waiting for its retry holds back the later events of its todo
+	failed := claimed[0]
+	failed.Attempts, failed.LastError = 1, "boom"
+	failed.NextAttemptAt = time.Now().UTC().Truncate(time.Millisecond).Add(time.Hour)
+	mustUpdateOutboxEvent(t, s, failed)
+	mustClaimOutboxEvents(t, s, "1", "")
+
+	// a dead event does not
+	failed.Status = model.OutboxDead
+	mustUpdateOutboxEvent(t, s, failed)
+	claimed = mustClaimOutboxEvents(t, s, "1", "1 todo.image_uploaded")
+	if todo, err := claimed[0].DecodeTodo(); err != nil || todo.ImagePath != "uploads/images/a.png" {
+		t.Errorf("todo.image_uploaded has todo %+v, %v, want the new image", todo, err)
+	}
+
+	again[0].Status = model.OutboxPublished
+	mustUpdateOutboxEvent(t, s, again[0])
+	claimed = mustClaimOutboxEvents(t, s, "2", "2 todo.deleted")
+	if todo, err := claimed[0].DecodeTodo(); err != nil || todo.ID != "2" {
+		t.Errorf("todo.deleted has todo %+v, %v, want ID 2", todo, err)
+	}
+}
+
+func mustUpdateOutboxEvent(t *testing.T, s storage.Storage, event model.OutboxEvent) {
+	t.Helper()
+	if err := s.UpdateOutboxEvent(ctx, event); err != nil {
+		t.Fatalf("UpdateOutboxEvent failed: %v", err)
+	}
+}
+
+// claims the events of the todo and checks their types
+func mustClaimOutboxEvents(t *testing.T, s storage.Storage, todoID string, want string) []model.OutboxEvent {
+	t.Helper()
+	claimed, err := s.ClaimOutboxEvents(ctx, todoID, time.Minute, 10)
+	if err != nil {
+		t.Fatalf("ClaimOutboxEvents failed: %v", err)
+	}
+	if got := strings.Join(outboxTypes(claimed), ","); got != want {
+		t.Fatalf("ClaimOutboxEvents of todo %v returned %q, want %q", todoID, got, want)
+	}
+	return claimed
+}
+
+func testOutboxEvents(t *testing.T, s storage.Storage) {
+	mustAdd(t, s, model.ToDo{ID: "1", Title: "first", Status: model.Created})
+	mustAdd(t, s, model.ToDo{ID: "2", Title: "second", Status: model.Created})
+	pending, err := s.GetOutboxEvents(ctx, model.OutboxPending, 10)
+	if err != nil {
+		t.Fatalf("GetOutboxEvents failed: %v", err)
+	}
+	if got := strings.Join(outboxTypes(pending), ","); got != "1 todo.created,2 todo.created" {
+		t.Fatalf("GetOutboxEvents returned %v, want the created events", got)
+	}
+
+	// storages keep milliseconds
+	retryAt := time.Now().UTC().Truncate(time.Millisecond).Add(time.Hour)
+	dead, published := pending[0], pending[1]
+	dead.Status, dead.Attempts, dead.LastError, dead.NextAttemptAt = model.OutboxDead, 3, "boom", retryAt
+	dead.Dropped, dead.Delivered = 2, 1
+	published.Status = model.OutboxPublished
+	for _, event := range []model.OutboxEvent{dead, published} {
+		if err := s.UpdateOutboxEvent(ctx, event); err != nil {
+			t.Fatalf("UpdateOutboxEvent failed: %v", err)
+		}
+	}
+	got, err := s.GetOutboxEvent(ctx, dead.ID)
+	if err != nil {
+		t.Fatalf("GetOutboxEvent failed: %v", err)
+	}
+	if got.Status != model.OutboxDead || got.Attempts != 3 || got.LastError != "boom" || !got.NextAttemptAt.Equal(retryAt) || got.Dropped != 2 || got.Delivered != 1 ||
+		got.Type != dead.Type || got.TodoID != "1" || got.Todo != dead.Todo || !got.CreatedAt.Equal(dead.CreatedAt) {
+		t.Errorf("after UpdateOutboxEvent got %+v, want %+v", got, dead)
+	}
+	for status, want := range map[model.OutboxStatus]string{
+		model.OutboxPending: "", model.OutboxDead: dead.ID, model.OutboxPublished: published.ID} {
+		outbox, err := s.GetOutboxEvents(ctx, status, 10)
+		if err != nil {
+			t.Fatalf("GetOutboxEvents failed: %v", err)
+		}
+		if ids := strings.Join(outboxIDs(outbox), ","); ids != want {
+			t.Errorf("GetOutboxEvents(%v) returned %v, want %v", status, ids, want)
+		}
+	}
+	// dead and published events are never claimed
+	if claimed, err := s.ClaimOutboxEvents(ctx, "", time.Minute, 10); err != nil || len(claimed) != 0 {
+		t.Errorf("ClaimOutboxEvents returned %v, %v, want none", outboxTypes(claimed), err)
+	}
+
+	// only published events are deleted
+	if err := s.DeleteOutboxEvents(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("DeleteOutboxEvents failed: %v", err)
+	}
+	if _, err := s.GetOutboxEvent(ctx, published.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetOutboxEvent of a deleted event returned %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetOutboxEvent(ctx, dead.ID); err != nil {
+		t.Errorf("GetOutboxEvent of a dead event after DeleteOutboxEvents returned %v", err)
+	}
+	if err := s.UpdateOutboxEvent(ctx, published); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("UpdateOutboxEvent of a deleted event returned %v, want ErrNotFound", err)
+	}
+}
+
+func outboxIDs(outbox []model.OutboxEvent) []string {
+	ids := make([]string, 0, len(outbox))
+	for _, event := range outbox {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
@@ -0,0 +1,415 @@
+// Package timeparse resolves reminder times typed by people, like "tomorrow 9am",
+// "next friday", "in 2 hours" or "every weekday at 8", against a reference time.
+package timeparse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHour is the time of day of phrases without one, like "tomorrow" or "next friday"
+const DefaultHour = 9
+
+// local time layouts, read in the time zone given to the parser
+var localTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// Result is a resolved reminder time
+type Result struct {
+	At         time.Time // the first occurrence
+	Recurrence string    // canonical form of a recurring phrase, e.g. "every weekday at 08:00", empty if it does not repeat
+}
+
+// Clock returns the current time
+type Clock func() time.Time
+
+// Parser resolves texts against the time of its clock, a fixed clock makes the results deterministic
+type Parser struct {
+	clock Clock
+}
+
+// NewParser creates a parser, a nil clock is time.Now
+func NewParser(clock Clock) *Parser {
+	if clock == nil {
+		clock = time.Now
+	}
+	return &Parser{clock: clock}
+}
+
+// Now returns the current time of the clock
+func (p *Parser) Now() time.Time {
+	return p.clock()
+}
+
+// Parse resolves the text against the current time of the clock, see ParseAt
+func (p *Parser) Parse(text string, loc *time.Location) (Result, error) {
+	return ParseAt(text, p.clock(), loc)
+}
+
+// ParseAt resolves the text against ref in the time zone loc. Accepted are:
+//   - durations counted from ref: 30m, 1h30m
+//   - RFC 3339 times: 2026-10-18T09:00:00+02:00
+//   - local times: 2026-10-18T09:00, 2026-10-18 09:00
+//   - phrases: in 2 hours, tomorrow 9am, next friday, at 18:30, every weekday at 8
+//
+// Phrases resolve to the first matching time after ref, except "today", which may be over already
+func ParseAt(text string, ref time.Time, loc *time.Location) (Result, error) {
+	text = strings.TrimSpace(text)
+	if duration, err := time.ParseDuration(text); err == nil {
+		return Result{At: ref.Add(duration)}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, text); err == nil {
+		return Result{At: t}, nil
+	}
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, text, loc); err == nil {
+			return Result{At: t}, nil
+		}
+	}
+
+	p := &phrase{tokens: strings.Fields(strings.ToLower(strings.ReplaceAll(text, ",", " ")))}
+	if len(p.tokens) == 0 {
+		return Result{}, fmt.Errorf("time is empty")
+	}
+	return p.parse(ref.In(loc))
+}
+
+// phrase is a tokenized natural-language time
+type phrase struct {
+	tokens []string
+	pos    int
+}
+
+func (p *phrase) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *phrase) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// consumes the next token if it is one of words
+func (p *phrase) accept(words ...string) bool {
+	for _, word := range words {
+		if p.peek() == word {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *phrase) unexpected() error {
+	if p.done() {
+		return fmt.Errorf("unexpected end of %q", strings.Join(p.tokens, " "))
+	}
+	return fmt.Errorf("unexpected %q in %q", p.peek(), strings.Join(p.tokens, " "))
+}
+
+func (p *phrase) parse(ref time.Time) (Result, error) {
+	switch {
+	case p.accept("in"):
+		return p.parseIn(ref)
+	case p.accept("every"):
+		days, err := p.parseDays()
+		if err != nil {
+			return Result{}, err
+		}
+		return p.parseRepeat(ref, days)
+	case p.accept("daily"):
+		return p.parseRepeat(ref, everyDay)
+	}
+	return p.parseOnce(ref)
+}
+
+// parses "in 2 hours", "in an hour and 30 minutes", "in 3 days", "in 90m"
+func (p *phrase) parseIn(ref time.Time) (Result, error) {
+	if len(p.tokens)-p.pos == 1 {
+		if duration, err := time.ParseDuration(p.peek()); err == nil && duration > 0 {
+			return Result{At: ref.Add(duration)}, nil
+		}
+	}
+
+	at := ref
+	for first := true; first || p.accept("and"); first = false {
+		var amount int
+		if p.accept("a", "an", "one") {
+			amount = 1
+		} else {
+			n, err := strconv.Atoi(p.peek())
+			if err != nil || n <= 0 {
+				return Result{}, p.unexpected()
+			}
+			p.pos++
+			amount = n
+		}
+
+		switch {
+		case p.accept("minute", "minutes", "min", "mins"):
+			at = at.Add(time.Duration(amount) * time.Minute)
+		case p.accept("hour", "hours", "hr", "hrs"):
+			at = at.Add(time.Duration(amount) * time.Hour)
+		case p.accept("day", "days"):
+			// days and weeks keep the time of day over daylight saving changes
+			at = at.AddDate(0, 0, amount)
+		case p.accept("week", "weeks"):
+			at = at.AddDate(0, 0, 7*amount)
+		default:
+			return Result{}, p.unexpected()
+		}
+	}
+	if !p.done() {
+		return Result{}, p.unexpected()
+	}
+	return Result{At: at}, nil
+}
+
+// parses a day and a time of day in any order, both are optional, but not at the same time
+func (p *phrase) parseOnce(ref time.Time) (Result, error) {
+	var c *clock
+	var d *day
+	for !p.done() {
+		if c == nil {
+			if parsed, ok := p.parseClock(); ok {
+				c = &parsed
+				continue
+			}
+		}
+		if d == nil {
+			if parsed, ok := p.parseDay(); ok {
+				d = &parsed
+				continue
+			}
+		}
+		return Result{}, p.unexpected()
+	}
+
+	if c == nil {
+		c = &clock{hour: DefaultHour}
+	}
+	if d == nil {
+		// just a time of day: today, or tomorrow if it is over
+		at := c.on(ref, 0)
+		if !at.After(ref) {
+			at = c.on(ref, 1)
+		}
+		return Result{At: at}, nil
+	}
+	if d.weekday < 0 {
+		return Result{At: c.on(ref, d.offset)}, nil
+	}
+
+	offset := (int(d.weekday) - int(ref.Weekday()) + 7) % 7
+	if offset == 0 && (d.next || !c.on(ref, 0).After(ref)) {
+		offset = 7
+	}
+	return Result{At: c.on(ref, offset)}, nil
+}
+
+// parses the rest of "every <days> [at <time>]"
+func (p *phrase) parseRepeat(ref time.Time, days weekdays) (Result, error) {
+	c := clock{hour: DefaultHour}
+	if !p.done() {
+		parsed, ok := p.parseClock()
+		if !ok || !p.done() {
+			return Result{}, p.unexpected()
+		}
+		c = parsed
+	}
+
+	// the first matching day within a week, today if the time is not over yet
+	for offset := 0; offset <= 7; offset++ {
+		if !days.has(time.Weekday((int(ref.Weekday()) + offset) % 7)) {
+			continue
+		}
+		if at := c.on(ref, offset); at.After(ref) {
+			return Result{At: at, Recurrence: "every " + days.String() + " at " + c.String()}, nil
+		}
+	}
+	return Result{}, fmt.Errorf("no day to repeat on in %q", strings.Join(p.tokens, " "))
+}
+
+// day of a one-time phrase, either an offset from today or a weekday
+type day struct {
+	offset  int
+	weekday time.Weekday // -1 if offset is used
+	next    bool         // "next friday" is never today
+}
+
+func (p *phrase) parseDay() (day, bool) {
+	switch {
+	case p.accept("today"):
+		return day{offset: 0, weekday: -1}, true
+	case p.accept("tomorrow"):
+		return day{offset: 1, weekday: -1}, true
+	}
+
+	start := p.pos
+	next := p.accept("next", "this") && p.tokens[start] == "next"
+	if weekday, ok := weekdayNames[p.peek()]; ok {
+		p.pos++
+		return day{weekday: weekday, next: next}, true
+	}
+	p.pos = start
+	return day{}, false
+}
+
+// parses the days of "every": day, weekday, weekend or weekday names joined with "and"
+func (p *phrase) parseDays() (weekdays, error) {
+	switch {
+	case p.accept("day"):
+		return everyDay, nil
+	case p.accept("weekday"):
+		return workDays, nil
+	case p.accept("weekend"):
+		return weekendDays, nil
+	}
+
+	var days weekdays
+	for {
+		weekday, ok := weekdayNames[p.peek()]
+		if !ok {
+			return 0, p.unexpected()
+		}
+		p.pos++
+		days |= 1 << uint(weekday)
+		if !p.accept("and") {
+			return days, nil
+		}
+	}
+}
+
+// clock is a time of day
+type clock struct {
+	hour, minute int
+}
+
+// returns the time of day offset days after the day of ref
+func (c clock) on(ref time.Time, offset int) time.Time {
+	y, m, d := ref.Date()
+	return time.Date(y, m, d+offset, c.hour, c.minute, 0, 0, ref.Location())
+}
+
+func (c clock) String() string {
+	return fmt.Sprintf("%02d:%02d", c.hour, c.minute)
+}
+
+// parses [at] 9, 9am, 9 am, 9:30pm, 21:00, noon or midnight
+func (p *phrase) parseClock() (clock, bool) {
+	start := p.pos
+	p.accept("at")
+
+	c, ok := p.parseClockToken()
+	if !ok {
+		p.pos = start
+		return clock{}, false
+	}
+	return c, true
+}
+
+func (p *phrase) parseClockToken() (clock, bool) {
+	switch {
+	case p.accept("noon"):
+		return clock{hour: 12}, true
+	case p.accept("midnight"):
+		return clock{hour: 0}, true
+	}
+
+	token := p.peek()
+	suffix := ""
+	for _, s := range []string{"am", "pm"} {
+		if strings.HasSuffix(token, s) {
+			token, suffix = strings.TrimSuffix(token, s), s
+		}
+	}
+	if token == "" {
+		return clock{}, false
+	}
+
+	hourText, minuteText, hasMinutes := strings.Cut(token, ":")
+	hour, err := strconv.Atoi(hourText)
+	if err != nil {
+		return clock{}, false
+	}
+	minute := 0
+	if hasMinutes {
+		if len(minuteText) != 2 {
+			return clock{}, false
+		}
+		if minute, err = strconv.Atoi(minuteText); err != nil || minute > 59 {
+			return clock{}, false
+		}
+	}
+
+	consumed := 1
+	if next := p.pos + 1; suffix == "" && next < len(p.tokens) && (p.tokens[next] == "am" || p.tokens[next] == "pm") {
+		suffix = p.tokens[next]
+		consumed++
+	}
+	switch {
+	case suffix == "" && hour >= 0 && hour <= 23:
+	case suffix != "" && hour >= 1 && hour <= 12:
+		// 12am is midnight and 12pm is noon
+		hour %= 12
+		if suffix == "pm" {
+			hour += 12
+		}
+	default:
+		return clock{}, false
+	}
+	p.pos += consumed
+	return clock{hour: hour, minute: minute}, true
+}
+
+// weekdays is a set of days, bit n is time.Weekday(n)
+type weekdays uint8
+
+const (
+	everyDay    weekdays = 1<<7 - 1
+	weekendDays weekdays = 1<<time.Saturday | 1<<time.Sunday
+	workDays             = everyDay &^ weekendDays
+)
+
+func (w weekdays) has(weekday time.Weekday) bool {
+	return w&(1<<uint(weekday)) != 0
+}
+
+// returns the form parseDays reads back
+func (w weekdays) String() string {
+	switch w {
+	case everyDay:
+		return "day"
+	case workDays:
+		return "weekday"
+	case weekendDays:
+		return "weekend"
+	}
+	var names []string
+	// the week starts on monday
+	for i := 1; i <= 7; i++ {
+		weekday := time.Weekday(i % 7)
+		if w.has(weekday) {
+			names = append(names, strings.ToLower(weekday.String()))
+		}
+	}
+	return strings.Join(names, " and ")
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
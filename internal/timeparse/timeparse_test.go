@@ -0,0 +1,174 @@
+package timeparse
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("could not load time zone %v: %v", name, err)
+	}
+	return loc
+}
+
+func TestParseAt(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, berlin)
+	}
+	friday := at(time.March, 1, 10, 0)   // Friday, 1 March 2024, 10:00
+	saturday := at(time.March, 2, 10, 0) // Saturday, 2 March 2024, 10:00
+	// the clocks in Berlin go from 02:00 to 03:00 on Sunday, 31 March 2024
+	beforeDST := at(time.March, 30, 10, 0)
+
+	tests := []struct {
+		text       string
+		ref        time.Time
+		want       time.Time
+		recurrence string
+	}{
+		// durations and absolute times
+		{"30m", friday, friday.Add(30 * time.Minute), ""},
+		{"1h30m", friday, friday.Add(90 * time.Minute), ""},
+		{"2024-03-05T09:00:00+02:00", friday, time.Date(2024, 3, 5, 7, 0, 0, 0, time.UTC), ""},
+		{"2024-03-05T09:00", friday, at(time.March, 5, 9, 0), ""},
+		{"2024-03-05 09:00:30", friday, at(time.March, 5, 9, 0).Add(30 * time.Second), ""},
+
+		// in ...
+		{"in 2 hours", friday, at(time.March, 1, 12, 0), ""},
+		{"in an hour and 30 minutes", friday, at(time.March, 1, 11, 30), ""},
+		{"In 90m", friday, at(time.March, 1, 11, 30), ""},
+		{"in 3 days", friday, at(time.March, 4, 10, 0), ""},
+		{"in 1 week", friday, at(time.March, 8, 10, 0), ""},
+
+		// one day
+		{"tomorrow 9am", friday, at(time.March, 2, 9, 0), ""},
+		{"9am tomorrow", friday, at(time.March, 2, 9, 0), ""},
+		{"tomorrow", friday, at(time.March, 2, DefaultHour, 0), ""},
+		{"today at 18:30", friday, at(time.March, 1, 18, 30), ""},
+		{"today 9am", friday, at(time.March, 1, 9, 0), ""}, // today may be over already
+		{"at 11", friday, at(time.March, 1, 11, 0), ""},
+		{"9:30 pm", friday, at(time.March, 1, 21, 30), ""},
+		{"8am", friday, at(time.March, 2, 8, 0), ""}, // over today, so tomorrow
+
+		// weekdays, it is Friday 10:00
+		{"next friday", friday, at(time.March, 8, DefaultHour, 0), ""},
+		{"next friday 11am", friday, at(time.March, 8, 11, 0), ""},
+		{"friday 11am", friday, at(time.March, 1, 11, 0), ""},
+		{"friday", friday, at(time.March, 8, DefaultHour, 0), ""}, // 9:00 is over today
+		{"this monday at 7", friday, at(time.March, 4, 7, 0), ""},
+		{"Sat, 10pm", friday, at(time.March, 2, 22, 0), ""},
+
+		// 12am is midnight, 12pm is noon
+		{"12am", friday, at(time.March, 2, 0, 0), ""},
+		{"12pm", friday, at(time.March, 1, 12, 0), ""},
+		{"12 pm", friday, at(time.March, 1, 12, 0), ""},
+		{"12:30am tomorrow", friday, at(time.March, 2, 0, 30), ""},
+		{"noon", friday, at(time.March, 1, 12, 0), ""},
+		{"midnight", friday, at(time.March, 2, 0, 0), ""},
+
+		// recurring, it is Saturday 10:00
+		{"every weekday at 8", saturday, at(time.March, 4, 8, 0), "every weekday at 08:00"},
+		{"every weekend at 11am", saturday, at(time.March, 2, 11, 0), "every weekend at 11:00"},
+		{"every weekend at 8", saturday, at(time.March, 3, 8, 0), "every weekend at 08:00"},
+		{"every day", saturday, at(time.March, 3, DefaultHour, 0), "every day at 09:00"},
+		{"daily at 7", saturday, at(time.March, 3, 7, 0), "every day at 07:00"},
+		{"every wednesday and monday at 18:30", saturday, at(time.March, 4, 18, 30), "every monday and wednesday at 18:30"},
+		{"every saturday at 9", saturday, at(time.March, 9, 9, 0), "every saturday at 09:00"},
+
+		// over the change to daylight saving time: days keep the time of day, hours do not
+		{"tomorrow 9am", beforeDST, at(time.March, 31, 9, 0), ""},
+		{"in 1 day", beforeDST, at(time.March, 31, 10, 0), ""},
+		{"in 24 hours", beforeDST, at(time.March, 31, 11, 0), ""},
+		{"24h", beforeDST, at(time.March, 31, 11, 0), ""},
+		{"every day at 9", beforeDST, at(time.March, 31, 9, 0), "every day at 09:00"},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseAt(tt.text, tt.ref, berlin)
+		if err != nil {
+			t.Errorf("ParseAt(%q, %v) failed: %v", tt.text, tt.ref, err)
+			continue
+		}
+		if !got.At.Equal(tt.want) || got.Recurrence != tt.recurrence {
+			t.Errorf("ParseAt(%q, %v) = %v %q, want %v %q", tt.text, tt.ref, got.At.In(berlin), got.Recurrence, tt.want, tt.recurrence)
+		}
+	}
+}
+
+func TestParseAtDSTOffsets(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	ref := time.Date(2024, 3, 30, 10, 0, 0, 0, berlin)
+
+	got, err := ParseAt("tomorrow 9am", ref, berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 9:00 CET is 08:00 UTC, 9:00 CEST is 07:00 UTC
+	if want := time.Date(2024, 3, 31, 7, 0, 0, 0, time.UTC); !got.At.Equal(want) {
+		t.Errorf("tomorrow 9am over the DST change is %v, want %v", got.At.UTC(), want)
+	}
+	if hours := got.At.Sub(ref).Hours(); hours != 22 {
+		t.Errorf("tomorrow 9am over the DST change is %v hours ahead, want 22", hours)
+	}
+}
+
+func TestParseAtErrors(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	ref := time.Date(2024, 3, 1, 10, 0, 0, 0, berlin)
+
+	for _, text := range []string{
+		"",
+		"   ",
+		"soon",
+		"in",
+		"in 0 hours",
+		"in -2 hours",
+		"in 2 fortnights",
+		"in 2 hours sharp",
+		"in an hour and",
+		"13pm",
+		"0am",
+		"25:00",
+		"9:5",
+		"9:60",
+		"tomorrow tomorrow",
+		"9am 10am",
+		"next",
+		"next week",
+		"every",
+		"every someday",
+		"every monday and",
+		"every weekday at",
+		"every weekday at 8 sharp",
+		"daily tomorrow",
+	} {
+		if got, err := ParseAt(text, ref, berlin); err == nil {
+			t.Errorf("ParseAt(%q) = %v, want an error", text, got.At)
+		}
+	}
+}
+
+func TestParserClock(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, berlin)
+	p := NewParser(func() time.Time { return now })
+
+	if got := p.Now(); !got.Equal(now) {
+		t.Errorf("Now = %v, want the time of the clock %v", got, now)
+	}
+	got, err := p.Parse("in 2 hours", berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := now.Add(2 * time.Hour); !got.At.Equal(want) {
+		t.Errorf("Parse(in 2 hours) = %v, want %v", got.At, want)
+	}
+
+	if NewParser(nil).Now().IsZero() {
+		t.Error("a parser without a clock has no current time")
+	}
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -10,10 +12,22 @@ import (
 type Config struct {
 	DBType              string
 	DBConnectionString  string
+	PgMaxConns          int32
+	PgMinConns          int32
+	PgMaxConnLifetime   time.Duration
+	PgMaxConnIdleTime   time.Duration
+	PgHealthCheckPeriod time.Duration
 	MongoURI            string
 	MongoDBName         string
 	MongoCollectionName string
+	MongoReplicaSet     bool // refuse to start on a standalone server without transactions
+	SqlitePath          string
 	ServerAddress       string
+	RequestTimeout      time.Duration
+	TimeZone            *time.Location
+	NotificationLogSize int
+	OutboxMaxAttempts   int
+	OutboxRetention     time.Duration
 }
 
 func LoadConfig() *Config {
@@ -25,15 +39,24 @@ func LoadConfig() *Config {
 
 	dbType := os.Getenv("DB_TYPE")
 	if dbType == "" {
-		log.Fatal("DB_TYPE not found, specify 'postgres' or 'mongo'")
+		log.Fatal("DB_TYPE not found, specify 'postgres', 'mongo', 'sqlite' or 'memory'")
 	}
 
-	var dbConnectionString, mongoURI, mongoDBName, mongoCollectionName string
+	var dbConnectionString, mongoURI, mongoDBName, mongoCollectionName, sqlitePath string
+	var mongoReplicaSet bool
+	var pgMaxConns, pgMinConns int32
+	var pgMaxConnLifetime, pgMaxConnIdleTime, pgHealthCheckPeriod time.Duration
 	if dbType == "postgres" {
 		dbConnectionString = os.Getenv("DB_CONNECTION_STRING")
 		if dbConnectionString == "" {
 			log.Fatal("DB_CONNECTION_STRING not found")
 		}
+		// zero means the pgxpool default
+		pgMaxConns = int32(getEnvInt("PG_MAX_CONNS", 0))
+		pgMinConns = int32(getEnvInt("PG_MIN_CONNS", 0))
+		pgMaxConnLifetime = getEnvDuration("PG_MAX_CONN_LIFETIME", 0)
+		pgMaxConnIdleTime = getEnvDuration("PG_MAX_CONN_IDLE_TIME", 0)
+		pgHealthCheckPeriod = getEnvDuration("PG_HEALTH_CHECK_PERIOD", 0)
 	} else if dbType == "mongo" {
 		mongoURI = os.Getenv("MONGO_URI")
 		if mongoURI == "" {
@@ -47,7 +70,14 @@ func LoadConfig() *Config {
 		if mongoCollectionName == "" {
 			log.Fatal("MONGO_COLLECTION_NAME not found")
 		}
-	} else {
+		// without it a standalone server is used without transactions
+		mongoReplicaSet = getEnvBool("MONGO_REQUIRE_REPLICA_SET", false)
+	} else if dbType == "sqlite" {
+		sqlitePath = os.Getenv("SQLITE_PATH")
+		if sqlitePath == "" {
+			sqlitePath = "todos.db"
+		}
+	} else if dbType != "memory" { // memory storage needs no settings
 		log.Printf("Unsupported DB type: %v", dbType)
 	}
 
@@ -56,12 +86,88 @@ func LoadConfig() *Config {
 		serverAddress = "localhost:8080"
 	}
 
+	requestTimeout := getEnvDuration("REQUEST_TIMEOUT", 10*time.Second)
+
+	// reminder times of todos without a time zone are read in it
+	timeZoneName := os.Getenv("DEFAULT_TIME_ZONE")
+	if timeZoneName == "" {
+		timeZoneName = "UTC"
+	}
+	timeZone, err := time.LoadLocation(timeZoneName)
+	if err != nil {
+		log.Fatalf("Invalid DEFAULT_TIME_ZONE %v, expected an IANA name like Europe/Berlin", timeZoneName)
+	}
+
+	// notifications kept for clients that reconnect with Last-Event-ID
+	notificationLogSize := getEnvInt("NOTIFICATION_LOG_SIZE", 1000)
+	if notificationLogSize == 0 {
+		log.Fatal("NOTIFICATION_LOG_SIZE must be positive")
+	}
+
+	// an outbox event that failed this often is dead, published ones are kept this long
+	outboxMaxAttempts := getEnvInt("OUTBOX_MAX_ATTEMPTS", 10)
+	if outboxMaxAttempts == 0 {
+		log.Fatal("OUTBOX_MAX_ATTEMPTS must be positive")
+	}
+	outboxRetention := getEnvDuration("OUTBOX_RETENTION", 24*time.Hour)
+
 	return &Config{
 		DBType:              dbType,
 		DBConnectionString:  dbConnectionString,
+		PgMaxConns:          pgMaxConns,
+		PgMinConns:          pgMinConns,
+		PgMaxConnLifetime:   pgMaxConnLifetime,
+		PgMaxConnIdleTime:   pgMaxConnIdleTime,
+		PgHealthCheckPeriod: pgHealthCheckPeriod,
 		MongoURI:            mongoURI,
 		MongoDBName:         mongoDBName,
 		MongoCollectionName: mongoCollectionName,
+		MongoReplicaSet:     mongoReplicaSet,
+		SqlitePath:          sqlitePath,
 		ServerAddress:       serverAddress,
+		RequestTimeout:      requestTimeout,
+		TimeZone:            timeZone,
+		NotificationLogSize: notificationLogSize,
+		OutboxMaxAttempts:   outboxMaxAttempts,
+		OutboxRetention:     outboxRetention,
+	}
+}
+
+// returns the integer value of the variable or def if it is not set
+func getEnvInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Fatalf("Invalid %v %v, expected a non-negative number", name, value)
+	}
+	return n
+}
+
+// returns the boolean value of the variable (true, false, 1, 0) or def if it is not set
+func getEnvBool(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Invalid %v %v, expected true or false", name, value)
+	}
+	return b
+}
+
+// returns the duration value of the variable (e.g. 30s, 5m) or def if it is not set
+func getEnvDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Fatalf("Invalid %v %v, expected a duration like 30s or 5m", name, value)
 	}
+	return d
 }